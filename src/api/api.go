@@ -1,70 +1,236 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"d7024e/files"
 	"d7024e/kademlia"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strings"
+	"time"
 )
 
+// Largest object that can be uploaded in a single request
+const maxObjectSize = 16 << 20
+
+// Objects are addressed by the hash of their content, so they never change and can be cached for a year
+const objectCacheControl = "public, max-age=31536000, immutable"
+
 type API struct {
-	kademlia *kademlia.Kademlia
+	kademlia  *kademlia.Kademlia
+	fileStore *files.FileStore
 }
 
 // Create a new API instance.
 func NewAPI(kademlia *kademlia.Kademlia) API {
-	return API{kademlia}
+	return API{kademlia, files.NewFileStore(kademlia)}
 }
 
-// Handle POST request to upload objects.
+// Handle POST and PUT requests to upload objects. A JSON body {"data": "..."} stores the string in data,
+// any other content type, such as application/octet-stream, stores the body as it is.
 func (api *API) UploadObjectHandler(w http.ResponseWriter, r *http.Request) {
-	var content struct {
-		Data string `json:"data"`
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&content)
+	jsonMode := isJSON(r.Header.Get("Content-Type"))
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxObjectSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Object larger than %d bytes", maxObjectSize), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	hash := api.kademlia.Store([]byte(content.Data))
-	response := map[string]string{"hash": hash, "data": content.Data}
+	data := body
+	if jsonMode {
+		var content struct {
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal(body, &content); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		data = []byte(content.Data)
+	}
+
+	hash, replicas, err := api.kademlia.Store(r.Context(), data)
+	if err != nil {
+		api.storeFailed(w, "Data", replicas, err)
+		return
+	}
+
+	// Binary data is not echoed back, it would not fit in a JSON string anyway
+	response := map[string]interface{}{"hash": hash, "size": len(data), "replicas": replicas}
+	if jsonMode {
+		response["data"] = string(data)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Location", fmt.Sprintf("/objects/%s", hash)) // Set Location header
-	w.WriteHeader(http.StatusCreated)                            // Set 201 Created status code
+	w.Header().Set("ETag", etag(hash))
+	w.WriteHeader(http.StatusCreated) // Set 201 Created status code
 	jsonResponse, _ := json.Marshal(response)
 	w.Write(jsonResponse)
 }
 
-// Handle GET request to retrieve objects based on their hash.
+// Handle GET and HEAD requests to retrieve objects based on their hash. The object is returned as a JSON
+// string in {"data": "..."}, or as raw bytes if the client accepts application/octet-stream or sets ?raw.
 func (api *API) GetObjectHandler(w http.ResponseWriter, r *http.Request) {
-	hash := strings.TrimPrefix(r.URL.Path, "/objects/")
-	if len(hash) != 40 {
-		http.Error(w, "Invalid hash length", http.StatusBadRequest)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	data := api.kademlia.LookupData(hash)
+	hash, valid := parseHash(w, r, "/objects/")
+	if !valid {
+		return
+	}
+
+	// The lookup is cancelled if the client disconnects
+	data, err := api.kademlia.LookupData(r.Context(), hash)
+	if err != nil {
+		http.Error(w, "Lookup cancelled", http.StatusServiceUnavailable)
+		return
+	}
 	if data == nil {
 		http.Error(w, "Data not found", http.StatusNotFound)
 		return
 	}
 
-	response := map[string]string{"data": string(data)}
-	jsonResponse, _ := json.Marshal(response)
+	// The object behind a hash never changes, so a client that has it is only told that it exists
+	jsonMode := !acceptsRaw(r)
+	tag := etag(hash)
+	if jsonMode {
+		tag = etag(hash + "-json")
+	}
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", objectCacheControl)
+	w.Header().Set("Vary", "Accept")
+	if matchesETag(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if jsonMode {
+		response := map[string]string{"data": string(data)}
+		data, _ = json.Marshal(response)
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+
+	// ServeContent sets Content-Length and handles HEAD and range requests
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// Handle GET request to retrieve the stats of the node.
+func (api *API) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResponse, _ := json.Marshal(api.kademlia.Stats())
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonResponse)
 }
 
+// Responds to a store of what that failed with err after replicas nodes confirmed it. Too few replicas and
+// a cancelled store are 503 Service Unavailable, any other error is 500 Internal Server Error.
+func (api *API) storeFailed(w http.ResponseWriter, what string, replicas int, err error) {
+	switch {
+	case errors.Is(err, kademlia.ErrInsufficientReplicas):
+		http.Error(w, fmt.Sprintf("%s stored on %d nodes, at least %d required", what, replicas, api.kademlia.MinReplicas), http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "Store cancelled", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, fmt.Sprintf("Store failed: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// Returns the lowercase hash at the end of the path of r, which starts with prefix. Responds with
+// 400 Bad Request and returns false if it is not a valid hash.
+func parseHash(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	hash := strings.ToLower(strings.TrimPrefix(r.URL.Path, prefix))
+	if len(hash) != 40 {
+		http.Error(w, "Invalid hash length", http.StatusBadRequest)
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		http.Error(w, "Invalid hash", http.StatusBadRequest)
+		return "", false
+	}
+	return hash, true
+}
+
+// Returns the ETag of the object with hash.
+func etag(hash string) string {
+	return fmt.Sprintf("\"%s\"", hash)
+}
+
+// Reports whether an If-None-Match header lists tag.
+func matchesETag(ifNoneMatch string, tag string) bool {
+	for _, listed := range strings.Split(ifNoneMatch, ",") {
+		listed = strings.TrimPrefix(strings.TrimSpace(listed), "W/")
+		if listed == "*" || listed == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a request body with contentType is JSON. Requests without a content type are
+// treated as JSON, since that is what clients sent before raw uploads were supported.
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// Reports whether an Accept header asks for JSON rather than raw bytes.
+func acceptsJSON(accept string) bool {
+	return acceptsMediaType(accept, "application/json")
+}
+
+// Reports whether r asks for raw bytes rather than JSON, with ?raw or an Accept header that lists
+// application/octet-stream.
+func acceptsRaw(r *http.Request) bool {
+	return r.URL.Query().Has("raw") || acceptsMediaType(r.Header.Get("Accept"), "application/octet-stream")
+}
+
+// Reports whether an Accept header lists mediaType.
+func acceptsMediaType(accept string, mediaType string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		listed, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err == nil && listed == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
 // Start the RESTful API server.
 func StartServer(kademlia *kademlia.Kademlia, port int) {
 	api := NewAPI(kademlia)
-	http.HandleFunc("/objects", api.UploadObjectHandler) // Handle POST requests for uploading objects
-	http.HandleFunc("/objects/", api.GetObjectHandler)   // Handle GET requests for retrieving objects by hash
+	http.HandleFunc("/objects", api.UploadObjectHandler)   // Handle POST and PUT requests for uploading objects
+	http.HandleFunc("/objects/", api.GetObjectHandler)     // Handle GET and HEAD requests for retrieving objects by hash
+	http.HandleFunc("/files", api.UploadFileHandler)       // Handle POST and PUT requests for uploading files in chunks
+	http.HandleFunc("/files/", api.GetFileHandler)         // Handle GET and HEAD requests for retrieving files by manifest hash
+	http.HandleFunc("/records", api.PublishRecordHandler)  // Handle POST and PUT requests for publishing the mutable record of the node
+	http.HandleFunc("/records/", api.ResolveRecordHandler) // Handle GET and HEAD requests for resolving mutable records by key
+	http.HandleFunc("/stats", api.GetStatsHandler)         // Handle GET requests for the stats of the node
 
 	portStr := fmt.Sprintf("0.0.0.0:%d", port) // Listen on all interfaces
 	err := http.ListenAndServe(portStr, nil)
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"d7024e/kademlia"
+	"d7024e/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Creates an API for a node that has joined a network of count nodes on the memory network.
+func newTestAPI(t *testing.T, count int) API {
+	nodes, err := kademlia.NewMemoryNetwork().NewCluster(count)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+	return NewAPI(nodes[len(nodes)-1])
+}
+
+func TestUploadBinaryObject(t *testing.T) {
+	api := newTestAPI(t, 3)
+	data := []byte{0x00, 0xff, 0xfe, 0x80, '"', '\n', 0x00}
+
+	request := httptest.NewRequest(http.MethodPut, "/objects", bytes.NewReader(data))
+	request.Header.Set("Content-Type", "application/octet-stream")
+	recorder := httptest.NewRecorder()
+	api.UploadObjectHandler(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, but got %d: %s", http.StatusCreated, recorder.Code, recorder.Body.String())
+	}
+
+	var response map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	hash, _ := response["hash"].(string)
+	if recorder.Header().Get("ETag") != `"`+hash+`"` {
+		t.Errorf("Expected the ETag to be the hash %s, but got %s", hash, recorder.Header().Get("ETag"))
+	}
+	if _, exist := response["data"]; exist {
+		t.Error("Expected binary data not to be echoed back")
+	}
+
+	// Test that the raw bytes are returned as they were uploaded
+	request = httptest.NewRequest(http.MethodGet, "/objects/"+hash, nil)
+	request.Header.Set("Accept", "application/octet-stream")
+	recorder = httptest.NewRecorder()
+	api.GetObjectHandler(recorder, request)
+	if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), data) {
+		t.Fatalf("Expected status %d and the uploaded bytes, but got %d %v", http.StatusOK, recorder.Code, recorder.Body.Bytes())
+	}
+	headers := recorder.Header()
+	if headers.Get("Content-Type") != "application/octet-stream" || headers.Get("Content-Length") != strconv.Itoa(len(data)) {
+		t.Errorf("Unexpected Content-Type %s or Content-Length %s", headers.Get("Content-Type"), headers.Get("Content-Length"))
+	}
+	if headers.Get("ETag") != `"`+hash+`"` || headers.Get("Cache-Control") != objectCacheControl {
+		t.Errorf("Unexpected ETag %s or Cache-Control %s", headers.Get("ETag"), headers.Get("Cache-Control"))
+	}
+
+	// Test that a client that has the object is told it has not changed
+	request = httptest.NewRequest(http.MethodGet, "/objects/"+hash+"?raw", nil)
+	request.Header.Set("If-None-Match", `"`+hash+`"`)
+	recorder = httptest.NewRecorder()
+	api.GetObjectHandler(recorder, request)
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Errorf("Expected status %d without a body, but got %d", http.StatusNotModified, recorder.Code)
+	}
+
+	// Test that any ETag does not hide that an object does not exist
+	request = httptest.NewRequest(http.MethodGet, "/objects/1111111111111111111111111111111111111111", nil)
+	request.Header.Set("If-None-Match", "*")
+	recorder = httptest.NewRecorder()
+	api.GetObjectHandler(recorder, request)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, but got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestJSONObject(t *testing.T) {
+	api := newTestAPI(t, 3)
+
+	// Test that requests without a content type are still read as JSON
+	request := httptest.NewRequest(http.MethodPost, "/objects", bytes.NewReader([]byte(`{"data": "hello world"}`)))
+	recorder := httptest.NewRecorder()
+	api.UploadObjectHandler(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, but got %d: %s", http.StatusCreated, recorder.Code, recorder.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if response["data"] != "hello world" {
+		t.Errorf("Expected the data to be echoed back, but got %v", response["data"])
+	}
+
+	// Test that objects are returned as JSON by default
+	request = httptest.NewRequest(http.MethodGet, "/objects/"+response["hash"].(string), nil)
+	recorder = httptest.NewRecorder()
+	api.GetObjectHandler(recorder, request)
+	var object map[string]string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &object); err != nil || object["data"] != "hello world" {
+		t.Errorf("Expected the JSON object, but got %s", recorder.Body.String())
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected Content-Type application/json, but got %s", recorder.Header().Get("Content-Type"))
+	}
+}
+
+func TestObjectRequestErrors(t *testing.T) {
+	api := newTestAPI(t, 1)
+
+	tests := []struct {
+		method  string
+		path    string
+		body    []byte
+		handler http.HandlerFunc
+		status  int
+	}{
+		{http.MethodDelete, "/objects", nil, api.UploadObjectHandler, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/objects", []byte("not json"), api.UploadObjectHandler, http.StatusBadRequest},
+		{http.MethodPut, "/objects", make([]byte, maxObjectSize+1), api.UploadObjectHandler, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/objects/abc", nil, api.GetObjectHandler, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/objects/abc", nil, api.GetObjectHandler, http.StatusBadRequest},
+		{http.MethodGet, "/objects/zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz", nil, api.GetObjectHandler, http.StatusBadRequest},
+		{http.MethodGet, "/objects/1111111111111111111111111111111111111111", nil, api.GetObjectHandler, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, bytes.NewReader(test.body))
+		if test.body != nil && test.body[0] == 0 {
+			request.Header.Set("Content-Type", "application/octet-stream")
+		}
+		recorder := httptest.NewRecorder()
+		test.handler(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%s %s: expected status %d, but got %d", test.method, test.path, test.status, recorder.Code)
+		}
+	}
+}
+
+func TestStoreCancelled(t *testing.T) {
+	api := newTestAPI(t, 3)
+
+	// Test that a store cut short by the client is not reported as too few replicas
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest(http.MethodPost, "/objects", bytes.NewReader([]byte(`{"data": "hello world"}`))).WithContext(ctx)
+	recorder := httptest.NewRecorder()
+	api.UploadObjectHandler(recorder, request)
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "Store cancelled") {
+		t.Errorf("Expected status %d for a cancelled store, but got %d: %s", http.StatusServiceUnavailable, recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestFile(t *testing.T) {
+	api := newTestAPI(t, 3)
+	api.fileStore.ChunkSize = 100
+	file := make([]byte, 1050)
+	for i := range file {
+		file[i] = byte(i)
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "/files?name=report.pdf", bytes.NewReader(file))
+	recorder := httptest.NewRecorder()
+	api.UploadFileHandler(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, but got %d: %s", http.StatusCreated, recorder.Code, recorder.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	hash, _ := response["hash"].(string)
+	if response["chunks"] != float64(11) {
+		t.Errorf("Expected the file to be stored in 11 chunks, but got %v", response["chunks"])
+	}
+
+	// Test that the file is streamed with its size and name
+	request = httptest.NewRequest(http.MethodGet, "/files/"+hash, nil)
+	recorder = httptest.NewRecorder()
+	api.GetFileHandler(recorder, request)
+	if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), file) {
+		t.Fatalf("Expected status %d and the uploaded file, but got %d with %d bytes", http.StatusOK, recorder.Code, recorder.Body.Len())
+	}
+	if recorder.Header().Get("Content-Length") != "1050" || recorder.Header().Get("Content-Disposition") != `attachment; filename=report.pdf` {
+		t.Errorf("Unexpected Content-Length %s or Content-Disposition %s", recorder.Header().Get("Content-Length"), recorder.Header().Get("Content-Disposition"))
+	}
+
+	// Test that a client that has the file is told it has not changed
+	request = httptest.NewRequest(http.MethodGet, "/files/"+hash, nil)
+	request.Header.Set("If-None-Match", recorder.Header().Get("ETag"))
+	recorder = httptest.NewRecorder()
+	api.GetFileHandler(recorder, request)
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Errorf("Expected status %d without a body, but got %d", http.StatusNotModified, recorder.Code)
+	}
+
+	// Test that a hash that is not of a manifest is rejected, whatever ETag the client has
+	request = httptest.NewRequest(http.MethodGet, "/files/"+utils.Hash(file[:100]), nil)
+	request.Header.Set("If-None-Match", "*")
+	recorder = httptest.NewRecorder()
+	api.GetFileHandler(recorder, request)
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, but got %d", http.StatusUnprocessableEntity, recorder.Code)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	api := newTestAPI(t, 3)
+
+	request := httptest.NewRequest(http.MethodPut, "/records", bytes.NewReader([]byte("latest version")))
+	request.Header.Set("Content-Type", "application/octet-stream")
+	recorder := httptest.NewRecorder()
+	api.PublishRecordHandler(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, but got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	key, _ := response["key"].(string)
+
+	request = httptest.NewRequest(http.MethodGet, "/records/"+key, nil)
+	recorder = httptest.NewRecorder()
+	api.ResolveRecordHandler(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "latest version" {
+		t.Fatalf("Expected status %d and the published value, but got %d %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("X-Record-Sequence") != "1" || recorder.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Unexpected X-Record-Sequence %s or Cache-Control %s", recorder.Header().Get("X-Record-Sequence"), recorder.Header().Get("Cache-Control"))
+	}
+
+	// Test that a client with the latest version is told it has not changed
+	request = httptest.NewRequest(http.MethodGet, "/records/"+key, nil)
+	request.Header.Set("If-None-Match", recorder.Header().Get("ETag"))
+	recorder = httptest.NewRecorder()
+	api.ResolveRecordHandler(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d, but got %d", http.StatusNotModified, recorder.Code)
+	}
+
+	// Test that a publish cut short by the client is not reported as too few replicas
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request = httptest.NewRequest(http.MethodPut, "/records", bytes.NewReader([]byte("next version"))).WithContext(ctx)
+	request.Header.Set("Content-Type", "application/octet-stream")
+	recorder = httptest.NewRecorder()
+	api.PublishRecordHandler(recorder, request)
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "Store cancelled") {
+		t.Errorf("Expected status %d for a cancelled publish, but got %d: %s", http.StatusServiceUnavailable, recorder.Code, recorder.Body.String())
+	}
+}
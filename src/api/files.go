@@ -0,0 +1,115 @@
+package api
+
+import (
+	"d7024e/files"
+	"d7024e/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// Largest file that can be uploaded in a single request
+const maxFileSize = 1 << 30
+
+// Handle POST and PUT requests to upload files. The body is split into chunks that are stored separately,
+// and the file is retrieved by the hash of its manifest. The optional name query parameter is kept in the manifest.
+func (api *API) UploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	hash, manifest, err := api.fileStore.Put(r.Context(), name, http.MaxBytesReader(w, r.Body, maxFileSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("File larger than %d bytes", maxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		utils.LogError("UploadFileHandler: %s", err)
+		http.Error(w, "File could not be stored", http.StatusServiceUnavailable)
+		return
+	}
+	response := map[string]interface{}{"hash": hash, "name": manifest.Name, "size": manifest.Size, "chunks": len(manifest.Chunks)}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/files/%s", hash))
+	w.Header().Set("ETag", etag(hash))
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse, _ := json.Marshal(response)
+	w.Write(jsonResponse)
+}
+
+// Handle GET and HEAD requests to retrieve files based on the hash of their manifest. The chunks are
+// fetched in parallel and streamed in order. Clients that accept application/json get the manifest instead.
+func (api *API) GetFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash, valid := parseHash(w, r, "/files/")
+	if !valid {
+		return
+	}
+
+	manifest, err := api.fileStore.Manifest(r.Context(), hash)
+	switch {
+	case errors.Is(err, files.ErrNotFound):
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	case errors.Is(err, files.ErrInvalidManifest):
+		http.Error(w, "Object is not a file manifest", http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, "Lookup cancelled", http.StatusServiceUnavailable)
+		return
+	}
+
+	// A file that has been found never changes, so a client that has it does not need the chunks
+	jsonMode := acceptsJSON(r.Header.Get("Accept"))
+	tag := etag(hash)
+	if jsonMode {
+		tag = etag(hash + "-json")
+	}
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", objectCacheControl)
+	w.Header().Set("Vary", "Accept")
+	if matchesETag(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if jsonMode {
+		jsonResponse, _ := json.Marshal(manifest)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(jsonResponse)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(jsonResponse)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.FormatInt(manifest.Size, 10))
+	if manifest.Name != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": manifest.Name}))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// The status has been sent, so a failed chunk can only be reported by cutting the response short
+	if err := api.fileStore.Get(r.Context(), manifest, w); err != nil {
+		utils.LogError("GetFileHandler: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Handle POST and PUT requests to publish a new version of the mutable record of the node. The body is
+// read like an object upload, either as JSON {"data": "..."} or as raw bytes.
+func (api *API) PublishRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxObjectSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Record larger than %d bytes", maxObjectSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	value := body
+	if isJSON(r.Header.Get("Content-Type")) {
+		var content struct {
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal(body, &content); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		value = []byte(content.Data)
+	}
+
+	key, sequence, replicas, err := api.kademlia.Publish(r.Context(), value)
+	if err != nil {
+		api.storeFailed(w, "Record", replicas, err)
+		return
+	}
+	response := map[string]interface{}{"key": key, "sequence": sequence, "replicas": replicas}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/records/%s", key))
+	w.WriteHeader(http.StatusOK)
+	jsonResponse, _ := json.Marshal(response)
+	w.Write(jsonResponse)
+}
+
+// Handle GET and HEAD requests to resolve the latest version of a mutable record by its key. The value is
+// returned as raw bytes with its sequence number in X-Record-Sequence, or as JSON if the client accepts it.
+func (api *API) ResolveRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, valid := parseHash(w, r, "/records/")
+	if !valid {
+		return
+	}
+
+	// Records change, so clients must always ask whether they have the latest version
+	record, err := api.kademlia.Resolve(r.Context(), key)
+	if err != nil {
+		http.Error(w, "Lookup cancelled", http.StatusServiceUnavailable)
+		return
+	}
+	if record == nil {
+		http.Error(w, "Record not found", http.StatusNotFound)
+		return
+	}
+
+	data := record.Value
+	tag := etag(fmt.Sprintf("%s-%d", key, record.Sequence))
+	if acceptsJSON(r.Header.Get("Accept")) {
+		response := map[string]interface{}{"data": string(record.Value), "sequence": record.Sequence, "publicKey": hex.EncodeToString(record.PublicKey)}
+		data, _ = json.Marshal(response)
+		tag = etag(fmt.Sprintf("%s-%d-json", key, record.Sequence))
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("X-Record-Sequence", strconv.FormatUint(record.Sequence, 10))
+
+	// ServeContent answers If-None-Match with 304 Not Modified when the client has the latest version
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
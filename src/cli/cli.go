@@ -2,21 +2,27 @@ package cli
 
 import (
 	"bufio"
+	"context"
+	"d7024e/files"
 	"d7024e/kademlia"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 type CLI struct {
-	kademlia *kademlia.Kademlia
-	syncExit *sync.WaitGroup
+	kademlia  *kademlia.Kademlia
+	fileStore *files.FileStore
+	syncExit  *sync.WaitGroup
 }
 
 // Create a new CLI instance.
 func NewCLI(kademlia *kademlia.Kademlia, exit *sync.WaitGroup) CLI {
-	return CLI{kademlia, exit}
+	return CLI{kademlia, files.NewFileStore(kademlia), exit}
 }
 
 // Listen for user input and execute commands.
@@ -30,7 +36,12 @@ func (cli *CLI) Listen() {
 		fmt.Println("DEFINED COMMANDS:")
 		fmt.Println("put [content]")
 		fmt.Println("get [hash]")
+		fmt.Println("putfile [path]")
+		fmt.Println("getfile [hash] [path]")
+		fmt.Println("publish [content]")
+		fmt.Println("resolve [key]")
 		fmt.Println("forget [hash]")
+		fmt.Println("stats")
 		fmt.Println("exit")
 		fmt.Println("")
 
@@ -46,8 +57,18 @@ func (cli *CLI) Listen() {
 			cli.put(args[1])
 		case args[0] == "get" && len(args) > 1:
 			cli.get(args[1])
+		case args[0] == "putfile" && len(args) > 1:
+			cli.putFile(args[1])
+		case args[0] == "getfile" && len(args) > 1:
+			cli.getFile(args[1])
+		case args[0] == "publish" && len(args) > 1:
+			cli.publish(args[1])
+		case args[0] == "resolve" && len(args) > 1:
+			cli.resolve(args[1])
 		case args[0] == "forget" && len(args) > 1:
 			cli.forget(args[1])
+		case text == "stats":
+			cli.stats()
 		default:
 			fmt.Println("Invalid command.")
 		}
@@ -56,8 +77,16 @@ func (cli *CLI) Listen() {
 
 // Handle put command by storing content on the network.
 func (cli *CLI) put(content string) {
-	hash := cli.kademlia.Store([]byte(content))
-	fmt.Println("Stored content with hash", hash)
+	hash, replicas, err := cli.kademlia.Store(context.Background(), []byte(content))
+	if errors.Is(err, kademlia.ErrInsufficientReplicas) {
+		fmt.Printf("Failed to store content with hash %s, stored on %d nodes but at least %d required\n", hash, replicas, cli.kademlia.MinReplicas)
+		return
+	}
+	if err != nil {
+		fmt.Println("Failed to store content:", err)
+		return
+	}
+	fmt.Printf("Stored content with hash %s on %d nodes\n", hash, replicas)
 }
 
 // Handle get command by retrieving data from the network.
@@ -67,14 +96,90 @@ func (cli *CLI) get(hash string) {
 		return
 	}
 
-	data := cli.kademlia.LookupData(hash)
-	if data == nil {
+	data, err := cli.kademlia.LookupData(context.Background(), hash)
+	if err != nil {
+		fmt.Println("Lookup failed:", err)
+	} else if data == nil {
 		fmt.Println("Data not found")
 	} else {
 		fmt.Println("Data:", string(data))
 	}
 }
 
+// Handle putfile command by storing the file at path on the network in chunks.
+func (cli *CLI) putFile(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Could not open file:", err)
+		return
+	}
+	defer file.Close()
+
+	hash, manifest, err := cli.fileStore.Put(context.Background(), filepath.Base(path), file)
+	if err != nil {
+		fmt.Println("Failed to store file:", err)
+		return
+	}
+	fmt.Printf("Stored file %s of %d bytes in %d chunks with hash %s\n", manifest.Name, manifest.Size, len(manifest.Chunks), hash)
+}
+
+// Handle getfile command by retrieving the file with the manifest hash from the network and writing it to path.
+func (cli *CLI) getFile(args string) {
+	hash, path, _ := strings.Cut(args, " ")
+	if len([]byte(hash)) != 40 || path == "" {
+		fmt.Println("Expected a hash of length 40 and a path")
+		return
+	}
+
+	manifest, err := cli.fileStore.Manifest(context.Background(), hash)
+	if err != nil {
+		fmt.Println("Lookup failed:", err)
+		return
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Println("Could not create file:", err)
+		return
+	}
+	defer file.Close()
+
+	// A partial file is removed, so a file at path is always complete
+	if err := cli.fileStore.Get(context.Background(), manifest, file); err != nil {
+		file.Close()
+		os.Remove(path)
+		fmt.Println("Retrieving file failed:", err)
+		return
+	}
+	fmt.Printf("Retrieved file %s of %d bytes to %s\n", manifest.Name, manifest.Size, path)
+}
+
+// Handle publish command by publishing content as the new version of the mutable record of the node.
+func (cli *CLI) publish(content string) {
+	key, sequence, replicas, err := cli.kademlia.Publish(context.Background(), []byte(content))
+	if err != nil {
+		fmt.Println("Failed to publish record:", err)
+		return
+	}
+	fmt.Printf("Published record %s with sequence number %d on %d nodes\n", key, sequence, replicas)
+}
+
+// Handle resolve command by retrieving the latest version of the mutable record with key.
+func (cli *CLI) resolve(key string) {
+	if len([]byte(key)) != 40 {
+		fmt.Printf("Expected key length of 40 but got %d\n", len([]byte(key)))
+		return
+	}
+
+	record, err := cli.kademlia.Resolve(context.Background(), key)
+	if err != nil {
+		fmt.Println("Lookup failed:", err)
+	} else if record == nil {
+		fmt.Println("Record not found")
+	} else {
+		fmt.Printf("Data (sequence number %d): %s\n", record.Sequence, string(record.Value))
+	}
+}
+
 func (cli *CLI) forget(hash string) {
 	if len([]byte(hash)) != 40 {
 		fmt.Printf("Expected hash length of 20 but got %d", len([]byte(hash)))
@@ -84,6 +189,18 @@ func (cli *CLI) forget(hash string) {
 	cli.kademlia.Forget(hash)
 }
 
+// Handle stats command by printing the stats of the node.
+func (cli *CLI) stats() {
+	stats := cli.kademlia.Stats()
+	fmt.Printf("Node %s at %s\n", stats.ID, stats.Address)
+	fmt.Printf("Contacts: %d\n", stats.Contacts)
+	fmt.Printf("Requests in flight: %d, timed out: %d, orphaned responses: %d\n", stats.Requests.InFlight, stats.Requests.TimedOut, stats.Requests.Orphaned)
+	fmt.Printf("Buckets refreshed: %d, last refresh: %s\n", stats.BucketRefreshes, stats.LastBucketRefresh.Format(time.RFC3339))
+	fmt.Printf("Storage: %d values (limit %d, %d cached), %d bytes (limit %d), evicted: %d, rejected: %d\n", stats.Storage.Keys, stats.Storage.MaxKeys, stats.Storage.Cached, stats.Storage.Bytes, stats.Storage.MaxBytes, stats.Storage.Evicted, stats.Storage.Rejected)
+	fmt.Printf("Invalid values received: %d, misbehaving nodes: %d, unsigned messages dropped: %d, unsolved puzzles dropped: %d\n", stats.InvalidValues, stats.MisbehavingNodes, stats.InvalidMessages, stats.InvalidPuzzles)
+	fmt.Printf("Contacts rejected by IP limits: %d\n", stats.IPRejections)
+}
+
 // Handle exit command by exiting the program.
 func (cli *CLI) exit() {
 	cli.syncExit.Done()
@@ -0,0 +1,68 @@
+package erasure
+
+// Arithmetic in the finite field GF(2^8), built with the polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d).
+// Addition and subtraction are both XOR. Multiplication and division use tables of logarithms to the
+// base 2, which generates every non-zero element of the field.
+
+const fieldPolynomial = 0x11d
+
+var (
+	expTable [510]byte // expTable[i] is 2^i, repeated so that the sum of two logarithms can be looked up directly
+	logTable [256]byte // logTable[a] is the logarithm of a, logTable[0] is unused
+)
+
+func init() {
+	value := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(value)
+		expTable[i+255] = byte(value)
+		logTable[value] = byte(i)
+		value <<= 1
+		if value&0x100 != 0 {
+			value ^= fieldPolynomial
+		}
+	}
+}
+
+// Returns a * b.
+func gfMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// Returns a / b. b must not be zero.
+func gfDiv(a byte, b byte) byte {
+	if b == 0 {
+		panic("gfDiv: division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+// Returns a to the power of n.
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+// Adds factor * source to destination.
+func gfMulAdd(destination []byte, source []byte, factor byte) {
+	if factor == 0 {
+		return
+	}
+	logFactor := int(logTable[factor])
+	for i, value := range source {
+		if value != 0 {
+			destination[i] ^= expTable[logFactor+int(logTable[value])]
+		}
+	}
+}
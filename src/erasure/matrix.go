@@ -0,0 +1,99 @@
+package erasure
+
+import "errors"
+
+// Returned when a matrix that has no inverse is inverted
+var errSingularMatrix = errors.New("matrix is singular")
+
+// A matrix over GF(2^8), stored row by row
+type matrix [][]byte
+
+// Returns a matrix of zeros with the given size.
+func newMatrix(rows int, columns int) matrix {
+	m := make(matrix, rows)
+	for row := range m {
+		m[row] = make([]byte, columns)
+	}
+	return m
+}
+
+// Returns the identity matrix with the given size.
+func identityMatrix(size int) matrix {
+	m := newMatrix(size, size)
+	for i := range m {
+		m[i][i] = 1
+	}
+	return m
+}
+
+// Returns the Vandermonde matrix whose element at row r and column c is r^c. Any columns rows of it are
+// linearly independent as long as there are at most 256 rows, since every row uses a different element.
+func vandermondeMatrix(rows int, columns int) matrix {
+	m := newMatrix(rows, columns)
+	for row := range m {
+		for column := range m[row] {
+			m[row][column] = gfPow(byte(row), column)
+		}
+	}
+	return m
+}
+
+// Returns the product m * other.
+func (m matrix) multiply(other matrix) matrix {
+	product := newMatrix(len(m), len(other[0]))
+	for row := range m {
+		for i, factor := range m[row] {
+			gfMulAdd(product[row], other[i], factor)
+		}
+	}
+	return product
+}
+
+// Returns a matrix of the given rows of m.
+func (m matrix) selectRows(rows []int) matrix {
+	selected := make(matrix, len(rows))
+	for i, row := range rows {
+		selected[i] = append([]byte{}, m[row]...)
+	}
+	return selected
+}
+
+// Returns the inverse of the square matrix m, found by Gauss-Jordan elimination.
+func (m matrix) invert() (matrix, error) {
+	size := len(m)
+	// Work on m with the identity appended, which turns into the inverse as m turns into the identity
+	identity := identityMatrix(size)
+	work := make(matrix, size)
+	for row := range m {
+		work[row] = append(append([]byte{}, m[row]...), identity[row]...)
+	}
+
+	for column := 0; column < size; column++ {
+		// Find a row with a non-zero pivot and move it into place
+		pivot := column
+		for pivot < size && work[pivot][column] == 0 {
+			pivot++
+		}
+		if pivot == size {
+			return nil, errSingularMatrix
+		}
+		work[column], work[pivot] = work[pivot], work[column]
+
+		// Scale the pivot to 1 and clear the column in every other row
+		scale := gfDiv(1, work[column][column])
+		for i := range work[column] {
+			work[column][i] = gfMul(work[column][i], scale)
+		}
+		for row := 0; row < size; row++ {
+			if row != column && work[row][column] != 0 {
+				gfMulAdd(work[row], work[column], work[row][column])
+			}
+		}
+	}
+
+	inverse := make(matrix, size)
+	for row := range work {
+		inverse[row] = work[row][size:]
+	}
+	return inverse, nil
+}
@@ -0,0 +1,116 @@
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned when too few shards are left to rebuild the data
+var ErrTooFewShards = errors.New("too few shards")
+
+// Encoder splits data into shards with a systematic Reed-Solomon code. The first required shards hold
+// the data itself and the rest hold parity, so that any required of the total shards rebuild the data.
+type Encoder struct {
+	required int
+	total    int
+	matrix   matrix // total x required, the top required rows are the identity
+}
+
+// Create a new Encoder instance that encodes data into total shards, any required of which rebuild it.
+func NewEncoder(required int, total int) (*Encoder, error) {
+	if required <= 0 || total < required || total > 256 {
+		return nil, fmt.Errorf("NewEncoder: %d of %d shards is not a valid code, 0 < required <= total <= 256", required, total)
+	}
+
+	// Multiplying by the inverse of the top rows keeps every set of required rows independent
+	vandermonde := vandermondeMatrix(total, required)
+	top, err := vandermonde.selectRows(firstRows(required)).invert()
+	if err != nil {
+		return nil, fmt.Errorf("NewEncoder: %w", err)
+	}
+	return &Encoder{required, total, vandermonde.multiply(top)}, nil
+}
+
+// Returns the number of shards needed to rebuild the data.
+func (encoder *Encoder) Required() int {
+	return encoder.required
+}
+
+// Returns the number of shards data is encoded into.
+func (encoder *Encoder) Total() int {
+	return encoder.total
+}
+
+// Returns the size of every shard of data of size bytes.
+func (encoder *Encoder) ShardSize(size int) int {
+	return (size + encoder.required - 1) / encoder.required
+}
+
+// Encodes data into shards of equal size. The last data shard is padded with zeros.
+func (encoder *Encoder) Encode(data []byte) [][]byte {
+	shardSize := encoder.ShardSize(len(data))
+	shards := make([][]byte, encoder.total)
+	for i := range shards {
+		shards[i] = make([]byte, shardSize)
+	}
+	for i := 0; i < encoder.required; i++ {
+		start := i * shardSize
+		if start < len(data) {
+			copy(shards[i], data[start:])
+		}
+	}
+
+	for i := encoder.required; i < encoder.total; i++ {
+		for j, factor := range encoder.matrix[i] {
+			gfMulAdd(shards[i], shards[j], factor)
+		}
+	}
+	return shards
+}
+
+// Rebuilds the data of size bytes from shards, which holds the shards by index with nil for those that
+// are missing. All shards must have the size returned by ShardSize.
+func (encoder *Encoder) Decode(shards [][]byte, size int) ([]byte, error) {
+	if len(shards) != encoder.total {
+		return nil, fmt.Errorf("Decode: expected %d shards, but got %d", encoder.total, len(shards))
+	}
+	shardSize := encoder.ShardSize(size)
+	present := []int{}
+	for index, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if len(shard) != shardSize {
+			return nil, fmt.Errorf("Decode: shard %d has %d bytes, expected %d", index, len(shard), shardSize)
+		}
+		if len(present) < encoder.required {
+			present = append(present, index)
+		}
+	}
+	if len(present) < encoder.required {
+		return nil, fmt.Errorf("Decode: %w, %d of %d required", ErrTooFewShards, len(present), encoder.required)
+	}
+
+	// The present shards are the product of their rows of the matrix and the data shards
+	decoding, err := encoder.matrix.selectRows(present).invert()
+	if err != nil {
+		return nil, fmt.Errorf("Decode: %w", err)
+	}
+	data := make([]byte, encoder.required*shardSize)
+	for i := 0; i < encoder.required; i++ {
+		dataShard := data[i*shardSize : (i+1)*shardSize]
+		for j, index := range present {
+			gfMulAdd(dataShard, shards[index], decoding[i][j])
+		}
+	}
+	return data[:size], nil
+}
+
+// Returns the indices of the first count rows.
+func firstRows(count int) []int {
+	rows := make([]int, count)
+	for i := range rows {
+		rows[i] = i
+	}
+	return rows
+}
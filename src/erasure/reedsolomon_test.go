@@ -0,0 +1,106 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFieldArithmetic(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			product := gfMul(byte(a), byte(b))
+			if product == 0 || gfDiv(product, byte(b)) != byte(a) {
+				t.Fatalf("Expected %d * %d / %d to be %d, but got %d", a, b, b, a, gfDiv(product, byte(b)))
+			}
+		}
+		if gfMul(byte(a), 0) != 0 || gfDiv(0, byte(a)) != 0 {
+			t.Fatalf("Expected multiplying and dividing zero by %d to give zero", a)
+		}
+	}
+
+	// Test a product that needs the polynomial to reduce, 0x80 * 2 = 0x100 ^ 0x11d
+	if product := gfMul(0x80, 2); product != 0x1d {
+		t.Errorf("Expected 0x80 * 2 to be 0x1d, but got %#x", product)
+	}
+	if power := gfPow(2, 8); power != 0x1d {
+		t.Errorf("Expected 2^8 to be 0x1d, but got %#x", power)
+	}
+}
+
+func TestInvert(t *testing.T) {
+	m := vandermondeMatrix(5, 5)
+	inverse, err := m.invert()
+	if err != nil {
+		t.Fatalf("invert() returned an error: %v", err)
+	}
+	product := m.multiply(inverse)
+	for row := range product {
+		if !bytes.Equal(product[row], identityMatrix(5)[row]) {
+			t.Fatalf("Expected the product with the inverse to be the identity, but got %v", product)
+		}
+	}
+
+	singular := matrix{{1, 2}, {2, 4}}
+	if _, err := singular.invert(); !errors.Is(err, errSingularMatrix) {
+		t.Errorf("Expected errSingularMatrix, but got %v", err)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	encoder, err := NewEncoder(4, 7)
+	if err != nil {
+		t.Fatalf("NewEncoder() returned an error: %v", err)
+	}
+	data := []byte("any four of the seven shards are enough to rebuild this")
+	shards := encoder.Encode(data)
+
+	// Test that the code is systematic, so the data shards hold the data as it is
+	if !bytes.HasPrefix(data, shards[0]) {
+		t.Errorf("Expected the first shard to hold the start of the data, but got %q", shards[0])
+	}
+
+	// Test that every combination of four or more shards rebuilds the data
+	for present := 0; present < 1<<7; present++ {
+		subset := make([][]byte, 7)
+		count := 0
+		for i := range subset {
+			if present&(1<<i) != 0 {
+				subset[i] = shards[i]
+				count++
+			}
+		}
+
+		decoded, err := encoder.Decode(subset, len(data))
+		if count < 4 {
+			if !errors.Is(err, ErrTooFewShards) {
+				t.Errorf("Expected ErrTooFewShards with %d shards, but got %v", count, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Fatalf("Expected shards %07b to rebuild the data, but got %q %v", present, decoded, err)
+		}
+	}
+}
+
+func TestNewEncoder(t *testing.T) {
+	for _, code := range [][2]int{{0, 3}, {4, 3}, {10, 257}} {
+		if _, err := NewEncoder(code[0], code[1]); err == nil {
+			t.Errorf("Expected an error for %d of %d shards", code[0], code[1])
+		}
+	}
+
+	// Test the largest code and an empty value
+	encoder, err := NewEncoder(200, 256)
+	if err != nil {
+		t.Fatalf("NewEncoder() returned an error: %v", err)
+	}
+	shards := encoder.Encode(nil)
+	for i := 0; i < 56; i++ {
+		shards[i] = nil
+	}
+	if decoded, err := encoder.Decode(shards, 0); err != nil || len(decoded) != 0 {
+		t.Errorf("Expected an empty value to be rebuilt, but got %v %v", decoded, err)
+	}
+}
@@ -0,0 +1,227 @@
+package files
+
+import (
+	"context"
+	"d7024e/kademlia"
+	"d7024e/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	DefaultChunkSize   = 256 << 10 // Bytes of a file stored under each chunk hash
+	DefaultParallelism = 8         // Chunks stored or fetched at the same time
+	manifestVersion    = 1         // Version of the manifest format written by Put
+)
+
+// Returned when the object stored under a hash is not a valid manifest
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// Returned when a manifest or a chunk of a file cannot be found on the network
+var ErrNotFound = errors.New("not found")
+
+// Returned when a chunk does not have the hash or size listed in the manifest
+var ErrInvalidChunk = errors.New("invalid chunk")
+
+// Manifest lists the chunks a file was split into. It is stored as a JSON object under its own hash.
+type Manifest struct {
+	Version   int      `json:"version"`
+	Name      string   `json:"name,omitempty"`
+	Size      int64    `json:"size"`
+	ChunkSize int      `json:"chunkSize"`
+	Chunks    []string `json:"chunks"`
+}
+
+// FileStore splits files into chunks that are stored on the network as separate values
+type FileStore struct {
+	kademlia    *kademlia.Kademlia
+	ChunkSize   int // Size of the chunks new files are split into
+	Parallelism int // Chunks stored or fetched at the same time
+}
+
+// Create a new FileStore instance.
+func NewFileStore(kademlia *kademlia.Kademlia) *FileStore {
+	return &FileStore{kademlia, DefaultChunkSize, DefaultParallelism}
+}
+
+// Reads a file from r, stores every chunk of it and then its manifest on the network. name is optional.
+// Returns the hash of the manifest, which is the hash the file is retrieved by.
+func (store *FileStore) Put(ctx context.Context, name string, r io.Reader) (string, *Manifest, error) {
+	manifest := &Manifest{Version: manifestVersion, Name: name, ChunkSize: store.ChunkSize, Chunks: []string{}}
+
+	// A slot is taken before a chunk is read, so at most Parallelism chunks are held in memory
+	slots := make(chan struct{}, store.Parallelism)
+	errs := make(chan error, 1)
+	var stores sync.WaitGroup
+	for index := 0; ; index++ {
+		slots <- struct{}{}
+		if len(errs) > 0 {
+			// A chunk could not be stored, so the file cannot be put together anyway
+			<-slots
+			break
+		}
+		chunk := make([]byte, store.ChunkSize)
+		n, err := io.ReadFull(r, chunk)
+		if err == io.EOF || (err == nil && n == 0) {
+			<-slots
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			<-slots
+			stores.Wait()
+			return "", nil, fmt.Errorf("Put: %w", err)
+		}
+		chunk = chunk[:n]
+		manifest.Size += int64(n)
+		manifest.Chunks = append(manifest.Chunks, utils.Hash(chunk))
+
+		stores.Add(1)
+		go func(index int, chunk []byte) {
+			defer stores.Done()
+			defer func() { <-slots }()
+			if _, _, err := store.kademlia.Store(ctx, chunk); err != nil {
+				select {
+				case errs <- fmt.Errorf("chunk %d: %w", index, err):
+				default:
+				}
+			}
+		}(index, chunk)
+
+		if n < store.ChunkSize {
+			break
+		}
+	}
+	stores.Wait()
+
+	select {
+	case err := <-errs:
+		return "", nil, fmt.Errorf("Put: %w", err)
+	default:
+	}
+
+	encoded, _ := json.Marshal(manifest)
+	hash, _, err := store.kademlia.Store(ctx, encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("Put: manifest: %w", err)
+	}
+	utils.Log(1, "Stored file %q of %d bytes in %d chunks with manifest %s", name, manifest.Size, len(manifest.Chunks), hash)
+	return hash, manifest, nil
+}
+
+// Looks up the manifest stored under hash.
+func (store *FileStore) Manifest(ctx context.Context, hash string) (*Manifest, error) {
+	data, err := store.kademlia.LookupData(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("Manifest: %w", err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("Manifest: %w: %s", ErrNotFound, hash)
+	}
+	if utils.Hash(data) != hash {
+		return nil, fmt.Errorf("Manifest: %w: %s is not the hash of the data", ErrInvalidManifest, hash)
+	}
+	return DecodeManifest(data)
+}
+
+// Decodes a manifest and checks that it describes a file that can be put together from its chunks.
+func DecodeManifest(data []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidManifest, err)
+	}
+	if manifest.Version != manifestVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrInvalidManifest, manifest.Version)
+	}
+	if manifest.ChunkSize <= 0 || manifest.Size < 0 {
+		return nil, fmt.Errorf("%w: chunk size %d and size %d", ErrInvalidManifest, manifest.ChunkSize, manifest.Size)
+	}
+	chunks := (manifest.Size + int64(manifest.ChunkSize) - 1) / int64(manifest.ChunkSize)
+	if int64(len(manifest.Chunks)) != chunks {
+		return nil, fmt.Errorf("%w: %d chunks listed but %d bytes need %d", ErrInvalidManifest, len(manifest.Chunks), manifest.Size, chunks)
+	}
+	for _, hash := range manifest.Chunks {
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != kademlia.IDLength {
+			return nil, fmt.Errorf("%w: chunk hash %q", ErrInvalidManifest, hash)
+		}
+	}
+	return &manifest, nil
+}
+
+// Returns the size of chunk index of the file.
+func (manifest *Manifest) chunkSize(index int) int {
+	if index == len(manifest.Chunks)-1 {
+		return int(manifest.Size - int64(index)*int64(manifest.ChunkSize))
+	}
+	return manifest.ChunkSize
+}
+
+// Fetches the chunks of the file described by manifest in parallel, verifies them and writes them to w
+// in order. Nothing more is written after an error, so w may hold the first part of the file.
+func (store *FileStore) Get(ctx context.Context, manifest *Manifest, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make([]chan result, len(manifest.Chunks))
+	for index := range results {
+		results[index] = make(chan result, 1)
+	}
+
+	// A slot is freed when a chunk has been written, so at most Parallelism chunks are held in memory
+	slots := make(chan struct{}, store.Parallelism)
+	go func() {
+		for index, hash := range manifest.Chunks {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(index int, hash string) {
+				data, err := store.fetchChunk(ctx, hash, manifest.chunkSize(index))
+				if err != nil {
+					err = fmt.Errorf("chunk %d: %w", index, err)
+				}
+				results[index] <- result{data, err}
+			}(index, hash)
+		}
+	}()
+
+	for index := range manifest.Chunks {
+		var chunk result
+		select {
+		case chunk = <-results[index]:
+		case <-ctx.Done():
+			return fmt.Errorf("Get: %w", ctx.Err())
+		}
+		if chunk.err != nil {
+			return fmt.Errorf("Get: %w", chunk.err)
+		}
+		if _, err := w.Write(chunk.data); err != nil {
+			return fmt.Errorf("Get: %w", err)
+		}
+		<-slots
+	}
+	return nil
+}
+
+// Looks up the chunk with hash and checks that it has that hash and size.
+func (store *FileStore) fetchChunk(ctx context.Context, hash string, size int) ([]byte, error) {
+	data, err := store.kademlia.LookupData(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, hash)
+	}
+	if utils.Hash(data) != hash || len(data) != size {
+		return nil, fmt.Errorf("%w: %s has %d bytes with hash %s, expected %d bytes", ErrInvalidChunk, hash, len(data), utils.Hash(data), size)
+	}
+	return data, nil
+}
@@ -0,0 +1,114 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"d7024e/kademlia"
+	"d7024e/utils"
+	"errors"
+	"testing"
+)
+
+// Creates a file store for a node that has joined a network of count nodes on the memory network.
+func newTestFileStore(t *testing.T, count int) *FileStore {
+	nodes, err := kademlia.NewMemoryNetwork().NewCluster(count)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+	return NewFileStore(nodes[len(nodes)-1])
+}
+
+func TestPutGet(t *testing.T) {
+	store := newTestFileStore(t, 4)
+	store.ChunkSize = 1000
+	store.Parallelism = 3
+
+	for _, size := range []int{0, 1, 1000, 4500, 10000} {
+		file := make([]byte, size)
+		for i := range file {
+			file[i] = byte(i * 7 / 3)
+		}
+
+		hash, manifest, err := store.Put(context.Background(), "file.bin", bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("Put() of %d bytes returned an error: %v", size, err)
+		}
+		if manifest.Size != int64(size) || len(manifest.Chunks) != (size+999)/1000 {
+			t.Errorf("Expected %d bytes in %d chunks, but got %d bytes in %d chunks", size, (size+999)/1000, manifest.Size, len(manifest.Chunks))
+		}
+
+		// Test that the file is put together from its chunks by the hash of the manifest
+		fetched, err := store.Manifest(context.Background(), hash)
+		if err != nil {
+			t.Fatalf("Manifest() returned an error: %v", err)
+		}
+		if fetched.Name != "file.bin" || fetched.Size != manifest.Size {
+			t.Errorf("Expected the stored manifest, but got %v", fetched)
+		}
+		var buffer bytes.Buffer
+		if err := store.Get(context.Background(), fetched, &buffer); err != nil {
+			t.Fatalf("Get() of %d bytes returned an error: %v", size, err)
+		}
+		if !bytes.Equal(buffer.Bytes(), file) {
+			t.Errorf("Expected the file of %d bytes to be retrieved as it was stored, but got %d bytes", size, buffer.Len())
+		}
+	}
+}
+
+func TestGetInvalidChunks(t *testing.T) {
+	store := newTestFileStore(t, 3)
+	chunk := []byte("abc")
+	if _, _, err := store.kademlia.Store(context.Background(), chunk); err != nil {
+		t.Fatalf("Store() returned an error: %v", err)
+	}
+
+	// Test that a chunk that is not on the network is reported
+	missing := &Manifest{Version: manifestVersion, Size: 6, ChunkSize: 3, Chunks: []string{utils.Hash(chunk), utils.Hash([]byte("def"))}}
+	var buffer bytes.Buffer
+	if err := store.Get(context.Background(), missing, &buffer); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, but got %v", err)
+	}
+	if buffer.String() != "abc" {
+		t.Errorf("Expected the chunks before the missing one to be written, but got %q", buffer.String())
+	}
+
+	// Test that a chunk with a different size than in the manifest is rejected
+	wrongSize := &Manifest{Version: manifestVersion, Size: 4, ChunkSize: 4, Chunks: []string{utils.Hash(chunk)}}
+	if err := store.Get(context.Background(), wrongSize, &bytes.Buffer{}); !errors.Is(err, ErrInvalidChunk) {
+		t.Errorf("Expected ErrInvalidChunk, but got %v", err)
+	}
+
+	// Test that an object that is not a manifest is rejected
+	if _, err := store.Manifest(context.Background(), utils.Hash(chunk)); !errors.Is(err, ErrInvalidManifest) {
+		t.Errorf("Expected ErrInvalidManifest, but got %v", err)
+	}
+	if _, err := store.Manifest(context.Background(), utils.Hash([]byte("def"))); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, but got %v", err)
+	}
+}
+
+func TestDecodeManifest(t *testing.T) {
+	hash := utils.Hash([]byte("chunk"))
+	tests := []struct {
+		manifest string
+		valid    bool
+	}{
+		{`{"version": 1, "size": 5, "chunkSize": 5, "chunks": ["` + hash + `"]}`, true},
+		{`{"version": 1, "size": 0, "chunkSize": 5, "chunks": []}`, true},
+		{`{"version": 2, "size": 5, "chunkSize": 5, "chunks": ["` + hash + `"]}`, false},
+		{`{"version": 1, "size": 6, "chunkSize": 5, "chunks": ["` + hash + `"]}`, false},
+		{`{"version": 1, "size": 5, "chunkSize": 0, "chunks": ["` + hash + `"]}`, false},
+		{`{"version": 1, "size": 5, "chunkSize": 5, "chunks": ["abc"]}`, false},
+		{`not json`, false},
+	}
+
+	for _, test := range tests {
+		_, err := DecodeManifest([]byte(test.manifest))
+		if test.valid && err != nil {
+			t.Errorf("DecodeManifest(%s) returned an error: %v", test.manifest, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidManifest) {
+			t.Errorf("Expected ErrInvalidManifest for %s, but got %v", test.manifest, err)
+		}
+	}
+}
@@ -2,42 +2,120 @@ package kademlia
 
 import (
 	"container/list"
+	"time"
 )
 
+// Number of contacts kept in the replacement cache of a bucket
+const replacementCacheSize = bucketSize
+
 // bucket definition
-// contains a List
+// contains a List of contacts, most recently seen first, and a replacement cache
+// of contacts that did not fit in the bucket
 type bucket struct {
-	list *list.List
+	list         *list.List
+	replacements *list.List
+	stale        map[string]bool // Contacts that failed to respond to an RPC
+	pinging      *KademliaID     // The least recently seen contact being pinged before it is evicted
+	lastLookup   time.Time       // Last time a lookup was done for an ID in the range of the bucket
+	diversity    *ipDiversity    // Contacts per IP and subnet, shared with the other buckets of the table
 }
 
 // newBucket returns a new instance of a bucket
 func newBucket() *bucket {
 	bucket := &bucket{}
 	bucket.list = list.New()
+	bucket.replacements = list.New()
+	bucket.stale = make(map[string]bool)
+	bucket.lastLookup = time.Now()
+	bucket.diversity = newIPDiversity(IPLimits{})
 	return bucket
 }
 
 // AddContact adds the Contact to the front of the bucket
-// or moves it to the front of the bucket if it already existed
-func (bucket *bucket) AddContact(contact Contact) {
-	var element *list.Element
-	for e := bucket.list.Front(); e != nil; e = e.Next() {
-		nodeID := e.Value.(Contact).ID
+// or moves it to the front of the bucket if it already existed.
+// If the bucket is full, a stale contact is replaced by the Contact. Otherwise the Contact
+// is kept in the replacement cache and the least recently seen contact is returned so that
+// it can be pinged and evicted if it does not respond. Returns nil if no contact needs a ping.
+// A new Contact that would exceed the IP limits is dropped and counted as rejected.
+func (bucket *bucket) AddContact(contact Contact) *Contact {
+	element := bucket.find(contact.ID)
+	if element != nil {
+		bucket.list.MoveToFront(element)
+		delete(bucket.stale, contact.ID.String())
+		if bucket.pinging != nil && bucket.pinging.Equals(contact.ID) {
+			bucket.pinging = nil
+		}
+		return nil
+	}
 
-		if (contact).ID.Equals(nodeID) {
-			element = e
+	if !bucket.diversity.allows(bucket, contact) {
+		bucket.diversity.reject(contact)
+		return nil
+	}
+
+	if bucket.list.Len() < bucketSize {
+		bucket.push(contact)
+		return nil
+	}
+
+	// Replace a stale contact right away
+	for e := bucket.list.Back(); e != nil; e = e.Prev() {
+		staleContact := e.Value.(Contact)
+		if bucket.stale[staleContact.ID.String()] {
+			bucket.remove(e)
+			bucket.push(contact)
+			return nil
 		}
 	}
 
+	bucket.addReplacement(contact)
+
+	// Only one contact at a time is pinged for eviction
+	if bucket.pinging != nil {
+		return nil
+	}
+	leastRecentlySeen := bucket.list.Back().Value.(Contact)
+	bucket.pinging = leastRecentlySeen.ID
+	return &leastRecentlySeen
+}
+
+// RemoveContact removes the Contact from the bucket and replaces it with
+// the most recently seen contact in the replacement cache that fits the IP limits
+func (bucket *bucket) RemoveContact(contact Contact) {
+	if bucket.pinging != nil && bucket.pinging.Equals(contact.ID) {
+		bucket.pinging = nil
+	}
+
+	element := bucket.find(contact.ID)
 	if element == nil {
-		if bucket.list.Len() < bucketSize {
-			bucket.list.PushFront(contact)
+		return
+	}
+	bucket.remove(element)
+
+	for replacement := bucket.replacements.Front(); replacement != nil; replacement = replacement.Next() {
+		if bucket.diversity.allows(bucket, replacement.Value.(Contact)) {
+			bucket.replacements.Remove(replacement)
+			bucket.push(replacement.Value.(Contact))
+			return
 		}
-	} else {
-		bucket.list.MoveToFront(element)
 	}
 }
 
+// MarkStale marks the Contact as stale after it failed to respond. The Contact
+// is swapped out right away if the replacement cache has a contact to replace it with,
+// otherwise it is replaced by the next new contact that does not fit in the bucket.
+func (bucket *bucket) MarkStale(contact Contact) {
+	if bucket.find(contact.ID) == nil {
+		return
+	}
+
+	if bucket.replacements.Len() > 0 {
+		bucket.RemoveContact(contact)
+		return
+	}
+	bucket.stale[contact.ID.String()] = true
+}
+
 // GetContactAndCalcDistance returns an array of Contacts where
 // the distance has already been calculated
 func (bucket *bucket) GetContactAndCalcDistance(target *KademliaID) []Contact {
@@ -56,3 +134,42 @@ func (bucket *bucket) GetContactAndCalcDistance(target *KademliaID) []Contact {
 func (bucket *bucket) Len() int {
 	return bucket.list.Len()
 }
+
+// Returns the element holding the contact with id, or nil if it is not in the bucket.
+func (bucket *bucket) find(id *KademliaID) *list.Element {
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if id.Equals(e.Value.(Contact).ID) {
+			return e
+		}
+	}
+	return nil
+}
+
+// Adds a contact to the front of the bucket and counts its IP.
+func (bucket *bucket) push(contact Contact) {
+	bucket.list.PushFront(contact)
+	bucket.diversity.add(contact)
+}
+
+// Removes an element from the bucket together with its stale mark and the count of its IP.
+func (bucket *bucket) remove(element *list.Element) {
+	delete(bucket.stale, element.Value.(Contact).ID.String())
+	bucket.diversity.remove(element.Value.(Contact))
+	bucket.list.Remove(element)
+}
+
+// Adds the contact to the front of the replacement cache, dropping the oldest
+// replacement if the cache is full.
+func (bucket *bucket) addReplacement(contact Contact) {
+	for e := bucket.replacements.Front(); e != nil; e = e.Next() {
+		if contact.ID.Equals(e.Value.(Contact).ID) {
+			bucket.replacements.MoveToFront(e)
+			return
+		}
+	}
+
+	bucket.replacements.PushFront(contact)
+	if bucket.replacements.Len() > replacementCacheSize {
+		bucket.replacements.Remove(bucket.replacements.Back())
+	}
+}
@@ -1,13 +1,13 @@
 package kademlia
 
 import (
-	"container/list"
+	"fmt"
 	"testing"
 )
 
 func TestAddContact(t *testing.T) {
 	// Test case 1: Adding a new contact to an empty bucket
-	b := &bucket{list: list.New()}
+	b := newBucket()
 	newContact := NewContact(NewRandomKademliaID(), "0")
 	b.AddContact(newContact)
 
@@ -38,6 +38,71 @@ func TestAddContact(t *testing.T) {
 	}
 }
 
+func TestBucketEviction(t *testing.T) {
+	b := newBucket()
+	for i := 0; i < bucketSize; i++ {
+		b.AddContact(NewContact(NewRandomKademliaID(), fmt.Sprint(i)))
+	}
+	leastRecentlySeen := b.list.Back().Value.(Contact)
+
+	// Test that a full bucket asks for the least recently seen contact to be pinged only once
+	newcomer := NewContact(NewRandomKademliaID(), "newcomer")
+	ping := b.AddContact(newcomer)
+	if ping == nil || !ping.ID.Equals(leastRecentlySeen.ID) {
+		t.Fatalf("Expected AddContact() to return the least recently seen contact %s, but got %v", leastRecentlySeen.Address, ping)
+	}
+	if b.AddContact(NewContact(NewRandomKademliaID(), "other")) != nil {
+		t.Error("AddContact() should not ask for another ping while one is in progress")
+	}
+	if b.replacements.Len() != 2 {
+		t.Errorf("Expected 2 contacts in the replacement cache, got %d", b.replacements.Len())
+	}
+
+	// Test that a contact that responds moves to the front and stays in the bucket
+	b.AddContact(leastRecentlySeen)
+	if !b.list.Front().Value.(Contact).ID.Equals(leastRecentlySeen.ID) || b.pinging != nil {
+		t.Error("A contact that responded should be moved to the front of the bucket")
+	}
+
+	// Test that an evicted contact is replaced by the most recently seen replacement
+	evicted := b.list.Back().Value.(Contact)
+	b.RemoveContact(evicted)
+	if b.find(evicted.ID) != nil {
+		t.Errorf("Expected %s to be evicted", evicted.Address)
+	}
+	if b.list.Len() != bucketSize || b.list.Front().Value.(Contact).Address != "other" {
+		t.Error("Expected the evicted contact to be replaced by the most recent replacement")
+	}
+}
+
+func TestBucketMarkStale(t *testing.T) {
+	b := newBucket()
+	for i := 0; i < bucketSize; i++ {
+		b.AddContact(NewContact(NewRandomKademliaID(), fmt.Sprint(i)))
+	}
+	stale := b.list.Front().Value.(Contact)
+
+	// Test that a stale contact without a replacement is kept until a new contact arrives
+	b.MarkStale(stale)
+	if b.find(stale.ID) == nil {
+		t.Fatal("A stale contact should be kept while there is no replacement")
+	}
+	if ping := b.AddContact(NewContact(NewRandomKademliaID(), "newcomer")); ping != nil {
+		t.Error("A stale contact should be replaced without a ping")
+	}
+	if b.find(stale.ID) != nil || b.list.Front().Value.(Contact).Address != "newcomer" {
+		t.Error("Expected the stale contact to be replaced by the new contact")
+	}
+
+	// Test that a stale contact is swapped out right away when there is a replacement
+	b.AddContact(NewContact(NewRandomKademliaID(), "replacement"))
+	stale = b.list.Front().Value.(Contact)
+	b.MarkStale(stale)
+	if b.find(stale.ID) != nil || b.list.Front().Value.(Contact).Address != "replacement" {
+		t.Error("Expected the stale contact to be swapped out for the replacement")
+	}
+}
+
 func TestLenEmptyBucket(t *testing.T) {
 	b := newBucket()
 	expectedLength := 0
@@ -0,0 +1,406 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Bytes a sealed packet adds to the message it carries, at most. Every field of the packet and the packet
+// itself take a tag and a length, and the ciphertext is longer than the message by the tag of AES-GCM.
+const sealOverhead = 2 + 2 + // Packet
+	1 + 1 + 32 + // Exchange key
+	1 + 2 + maxAddressLength + // Address
+	1 + 1 + packetNonceSize + // Nonce
+	1 + 2 + 16 // Ciphertext
+
+const (
+	handshakeTimeout  = time.Second            // Time to wait for the answer to a handshake before it is resent
+	handshakeRetries  = 3                      // Number of times a handshake is sent before the peer counts as unreachable
+	handshakeMaxAge   = time.Minute            // Handshakes signed longer ago than this are not accepted
+	handshakeInterval = time.Second            // Minimum time between unasked handshakes, or answers to handshakes, sent to the same address
+	handshakePrefix   = "d7024e handshake\n"   // Signed together with the handshake, so that no other signature is a valid handshake
+	sessionKeyPrefix  = "d7024e session key\n" // Hashed together with the shared secret to derive the session key
+	packetNonceSize   = 12                     // Size of the random nonce every sealed packet is encrypted with
+	maxHandshakeTimes = 1024                   // Addresses to remember the last unasked handshake, or answer, for
+	maxSessions       = 4096                   // Sessions kept at once, the least recently used is dropped first
+	sessionIdleTime   = 10 * time.Minute       // Sessions not used for longer than this are dropped
+)
+
+// Returned when a packet cannot be decrypted or a handshake is not valid
+var ErrInvalidPacket = errors.New("invalid packet")
+
+// Returned when no handshake could be completed with a peer
+var ErrHandshakeFailed = errors.New("handshake failed")
+
+// A session with a peer, whose key was agreed through a signed handshake
+type session struct {
+	id          *KademliaID // ID of the peer, derived from the key that signed its handshake
+	address     string
+	exchangeKey []byte
+	aead        cipher.AEAD
+	lastUsed    time.Time // Guarded by the lock of the channel
+}
+
+// secureChannel holds the X25519 key of a node and the sessions agreed with its peers
+type secureChannel struct {
+	exchangeKey *ecdh.PrivateKey
+
+	mu           sync.Mutex
+	peers        map[string]*session      // Sessions by the address of the peer, used to send
+	sessions     map[string]*session      // Sessions by the exchange key of the peer, used to receive
+	handshakes   map[string]chan struct{} // Closed when a handshake with the address is answered
+	lastSent     map[string]time.Time     // When a handshake was last sent unasked to an address
+	lastAnswered map[string]time.Time     // When a handshake from an address was last answered
+}
+
+// Turns on encryption. Every message is then sent encrypted with a session key agreed with the receiver
+// through a handshake signed with the identity of the node, and packets that are not encrypted are dropped.
+// Must be called before Start. Nodes that do not encrypt cannot talk to nodes that do.
+func (network *Network) EnableEncryption() error {
+	exchangeKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("EnableEncryption: %w", err)
+	}
+	network.channel = &secureChannel{
+		exchangeKey:  exchangeKey,
+		peers:        make(map[string]*session),
+		sessions:     make(map[string]*session),
+		handshakes:   make(map[string]chan struct{}),
+		lastSent:     make(map[string]time.Time),
+		lastAnswered: make(map[string]time.Time),
+	}
+	return nil
+}
+
+// Returns the size of the largest serialized message that is sent in a single packet.
+func (network *Network) maxMessageSize() int {
+	if network.channel != nil {
+		return maxPacketSize - sealOverhead
+	}
+	return maxPacketSize
+}
+
+// Handles a single incoming packet from the address source, which is a plaintext message or an encrypted
+// packet depending on whether encryption is turned on.
+func (network *Network) handlePacket(source string, data []byte) {
+	if network.channel == nil {
+		network.handleMessage(source, nil, data)
+		return
+	}
+
+	packet, err := protobuf.DeserializePacket(data)
+	if err != nil {
+		utils.LogError("Listen failed to deserialize packet %s", err)
+		return
+	}
+	switch body := packet.Body.(type) {
+	case *protobuf.Packet_Handshake:
+		if err := network.receiveHandshake(body.Handshake); err != nil {
+			utils.LogError("Listen dropped handshake from %s: %s", body.Handshake.Address, err)
+		}
+
+	case *protobuf.Packet_Sealed:
+		message, peerID, err := network.open(body.Sealed)
+		if err != nil {
+			utils.LogError("Listen dropped packet from %s: %s", body.Sealed.Address, err)
+			return
+		}
+		network.handleMessage(source, peerID, message)
+
+	default:
+		utils.LogError("Listen dropped packet that is not encrypted")
+	}
+}
+
+// Encrypts data for contact and sends it. A handshake is made first if there is no session with the
+// address of contact, or if the session belongs to another node than contact.
+func (network *Network) seal(contact *Contact, data []byte) error {
+	peer, err := network.session(contact)
+	if err != nil {
+		return err
+	}
+
+	// A nonce must never be used twice with the same key, so nothing is sent without a fresh one
+	nonce := make([]byte, packetNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("seal: could not generate nonce %w", err)
+	}
+	packet, err := protobuf.SerializePacket(&protobuf.Packet{Body: &protobuf.Packet_Sealed{Sealed: &protobuf.Sealed{
+		ExchangeKey: network.channel.exchangeKey.PublicKey().Bytes(),
+		Address:     network.rt.me.Address,
+		Nonce:       nonce,
+		Ciphertext:  peer.aead.Seal(nil, nonce, data, nil),
+	}}})
+	if err != nil {
+		return err
+	}
+	return network.transport.Send(contact.Address, packet)
+}
+
+// Decrypts a sealed packet and returns it with the ID of the node the session was agreed with. If the session
+// it was sealed with is unknown or outdated, a handshake is sent to its sender so that the next packet can be decrypted.
+func (network *Network) open(sealed *protobuf.Sealed) ([]byte, *KademliaID, error) {
+	channel := network.channel
+	channel.mu.Lock()
+	peer, exist := channel.sessions[string(sealed.ExchangeKey)]
+	if exist {
+		peer.lastUsed = time.Now()
+	}
+	channel.mu.Unlock()
+
+	if exist && len(sealed.Nonce) == packetNonceSize {
+		data, err := peer.aead.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+		if err == nil {
+			return data, peer.id, nil
+		}
+	}
+
+	// The sender may have restarted, or agreed on a session with a key this node no longer has
+	if channel.shouldSendHandshake(sealed.Address) {
+		if err := network.sendHandshake(sealed.Address, true); err != nil {
+			utils.LogError("open: %s", err)
+		}
+	}
+	if !exist {
+		return nil, nil, fmt.Errorf("%w: no session with the sender", ErrInvalidPacket)
+	}
+	return nil, nil, fmt.Errorf("%w: could not decrypt", ErrInvalidPacket)
+}
+
+// Returns the session with the address of contact, making a handshake if there is none.
+func (network *Network) session(contact *Contact) (*session, error) {
+	channel := network.channel
+	for attempt := 0; attempt < handshakeRetries; attempt++ {
+		channel.mu.Lock()
+		peer := channel.peers[contact.Address]
+		if peer != nil && (contact.ID == nil || peer.id.Equals(contact.ID)) {
+			peer.lastUsed = time.Now()
+			channel.mu.Unlock()
+			return peer, nil
+		}
+
+		// Concurrent senders wait for the same handshake
+		answered, inProgress := channel.handshakes[contact.Address]
+		if !inProgress {
+			answered = make(chan struct{})
+			channel.handshakes[contact.Address] = answered
+		}
+		channel.mu.Unlock()
+
+		if !inProgress {
+			if err := network.sendHandshake(contact.Address, true); err != nil {
+				channel.handshakeDone(contact.Address, answered)
+				return nil, err
+			}
+		}
+		select {
+		case <-answered:
+		case <-time.After(handshakeTimeout):
+			channel.handshakeDone(contact.Address, answered)
+		}
+	}
+
+	channel.mu.Lock()
+	defer channel.mu.Unlock()
+	if peer := channel.peers[contact.Address]; peer != nil {
+		return nil, fmt.Errorf("%w: %s belongs to %s, not %s", ErrHandshakeFailed, contact.Address, peer.id.String(), contact.ID.String())
+	}
+	return nil, fmt.Errorf("%w: no answer from %s", ErrHandshakeFailed, contact.Address)
+}
+
+// Ends the handshake with address, if answered is still the one in progress.
+func (channel *secureChannel) handshakeDone(address string, answered chan struct{}) {
+	channel.mu.Lock()
+	defer channel.mu.Unlock()
+
+	if channel.handshakes[address] == answered {
+		delete(channel.handshakes, address)
+		close(answered)
+	}
+}
+
+// Returns whether a handshake may be sent unasked to address, which is limited so that forged packets
+// cannot make the node send a flood of handshakes.
+func (channel *secureChannel) shouldSendHandshake(address string) bool {
+	return channel.limitHandshakes(channel.lastSent, address)
+}
+
+// Returns whether a handshake from address may be answered, which is limited since a signed handshake
+// can be replayed until it is too old.
+func (channel *secureChannel) shouldAnswerHandshake(address string) bool {
+	return channel.limitHandshakes(channel.lastAnswered, address)
+}
+
+// Returns whether a handshake may be sent to address, given when handshakes were last sent to each address,
+// and records it if so.
+func (channel *secureChannel) limitHandshakes(lastSent map[string]time.Time, address string) bool {
+	channel.mu.Lock()
+	defer channel.mu.Unlock()
+
+	if time.Since(lastSent[address]) < handshakeInterval {
+		return false
+	}
+	if len(lastSent) >= maxHandshakeTimes {
+		for sentTo, sent := range lastSent {
+			if time.Since(sent) >= handshakeInterval {
+				delete(lastSent, sentTo)
+			}
+		}
+		// Handshakes were sent to too many addresses within the interval to keep track of another one
+		if len(lastSent) >= maxHandshakeTimes {
+			return false
+		}
+	}
+	lastSent[address] = time.Now()
+	return true
+}
+
+// Sends a handshake with the exchange key of the node to address.
+func (network *Network) sendHandshake(address string, reply bool) error {
+	handshake := &protobuf.Handshake{
+		PublicKey:   network.identity.PrivateKey.Public().(ed25519.PublicKey),
+		ExchangeKey: network.channel.exchangeKey.PublicKey().Bytes(),
+		Address:     network.rt.me.Address,
+		Timestamp:   time.Now().UnixMilli(),
+		Reply:       reply,
+	}
+	handshake.Signature = ed25519.Sign(network.identity.PrivateKey, handshakeSignedData(handshake))
+
+	data, err := protobuf.SerializePacket(&protobuf.Packet{Body: &protobuf.Packet_Handshake{Handshake: handshake}})
+	if err != nil {
+		return fmt.Errorf("sendHandshake: %w", err)
+	}
+	if err := network.transport.Send(address, data); err != nil {
+		return fmt.Errorf("sendHandshake: %w", err)
+	}
+	return nil
+}
+
+// Returns the data the signature of a handshake is made over.
+func handshakeSignedData(handshake *protobuf.Handshake) []byte {
+	signed := []byte(handshakePrefix)
+	signed = append(signed, byte(len(handshake.ExchangeKey)))
+	signed = append(signed, handshake.ExchangeKey...)
+	signed = binary.BigEndian.AppendUint64(signed, uint64(handshake.Timestamp))
+	if handshake.Reply {
+		signed = append(signed, 1)
+	} else {
+		signed = append(signed, 0)
+	}
+	return append(signed, handshake.Address...)
+}
+
+// Checks a handshake and agrees on a session with its sender, answering it if the sender asks for it.
+func (network *Network) receiveHandshake(handshake *protobuf.Handshake) error {
+	if len(handshake.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: handshake with a public key of %d bytes", ErrInvalidPacket, len(handshake.PublicKey))
+	}
+	if !ed25519.Verify(handshake.PublicKey, handshakeSignedData(handshake), handshake.Signature) {
+		return fmt.Errorf("%w: bad handshake signature", ErrInvalidPacket)
+	}
+	if age := time.Since(time.UnixMilli(handshake.Timestamp)); age > handshakeMaxAge || age < -handshakeMaxAge {
+		return fmt.Errorf("%w: handshake signed %s ago", ErrInvalidPacket, age)
+	}
+
+	peerKey, err := ecdh.X25519().NewPublicKey(handshake.ExchangeKey)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPacket, err)
+	}
+	aead, err := network.channel.sessionKey(peerKey)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPacket, err)
+	}
+	peer := &session{NodeID(handshake.PublicKey), handshake.Address, handshake.ExchangeKey, aead, time.Now()}
+
+	channel := network.channel
+	channel.mu.Lock()
+	if previous := channel.peers[peer.address]; previous != nil {
+		channel.removeSession(previous)
+	}
+	channel.makeRoom(peer.lastUsed)
+	channel.peers[peer.address] = peer
+	channel.sessions[string(peer.exchangeKey)] = peer
+	answered := channel.handshakes[peer.address]
+	channel.mu.Unlock()
+
+	if answered != nil {
+		channel.handshakeDone(peer.address, answered)
+	}
+	utils.Log(1, "Agreed on a session with %s at %s", peer.id.String(), peer.address)
+
+	if handshake.Reply && channel.shouldAnswerHandshake(peer.address) {
+		return network.sendHandshake(peer.address, false)
+	}
+	return nil
+}
+
+// Drops the sessions that have been idle for too long, and the least recently used sessions while there
+// is no room for another one. Must be called with the lock held.
+func (channel *secureChannel) makeRoom(now time.Time) {
+	for _, sessions := range []map[string]*session{channel.peers, channel.sessions} {
+		for _, peer := range sessions {
+			if now.Sub(peer.lastUsed) > sessionIdleTime {
+				channel.removeSession(peer)
+			}
+		}
+	}
+
+	for len(channel.peers) >= maxSessions || len(channel.sessions) >= maxSessions {
+		var oldest *session
+		for _, sessions := range []map[string]*session{channel.peers, channel.sessions} {
+			for _, peer := range sessions {
+				if oldest == nil || peer.lastUsed.Before(oldest.lastUsed) {
+					oldest = peer
+				}
+			}
+		}
+		channel.removeSession(oldest)
+	}
+}
+
+// Removes a session from the maps it is in. Must be called with the lock held.
+func (channel *secureChannel) removeSession(peer *session) {
+	if channel.peers[peer.address] == peer {
+		delete(channel.peers, peer.address)
+	}
+	if channel.sessions[string(peer.exchangeKey)] == peer {
+		delete(channel.sessions, string(peer.exchangeKey))
+	}
+}
+
+// Derives the key of the session with the node that has peerKey. Both nodes derive the same key.
+func (channel *secureChannel) sessionKey(peerKey *ecdh.PublicKey) (cipher.AEAD, error) {
+	secret, err := channel.exchangeKey.ECDH(peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The exchange keys are hashed in the same order on both sides
+	own, peer := channel.exchangeKey.PublicKey().Bytes(), peerKey.Bytes()
+	if bytes.Compare(own, peer) > 0 {
+		own, peer = peer, own
+	}
+	hash := sha256.New()
+	hash.Write([]byte(sessionKeyPrefix))
+	hash.Write(secret)
+	hash.Write(own)
+	hash.Write(peer)
+
+	block, err := aes.NewCipher(hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
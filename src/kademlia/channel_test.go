@@ -0,0 +1,296 @@
+package kademlia
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"d7024e/protobuf"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Keeps a copy of every packet sent through it and the address it was sent to
+type recordingTransport struct {
+	Transport
+	mu        sync.Mutex
+	sent      [][]byte
+	addresses []string
+}
+
+func (transport *recordingTransport) Send(address string, data []byte) error {
+	transport.mu.Lock()
+	transport.sent = append(transport.sent, append([]byte{}, data...))
+	transport.addresses = append(transport.addresses, address)
+	transport.mu.Unlock()
+	return transport.Transport.Send(address, data)
+}
+
+func TestEncryptedNetwork(t *testing.T) {
+	memory := NewMemoryNetwork()
+	recorder := &recordingTransport{Transport: memory.NewTransport()}
+	bootstrap, err := NewMemoryNode(recorder, NewIdentity(), "10.0.0.1", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	nodes := []*Kademlia{bootstrap}
+	for i := 2; i <= 5; i++ {
+		node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), fmt.Sprintf("10.0.0.%d", i), (*Network).EnableEncryption)
+		if err != nil {
+			t.Fatalf("NewMemoryNode() returned an error: %v", err)
+		}
+		if err := node.JoinNetwork(context.Background(), &nodes[0].network.rt.me); err != nil {
+			t.Fatalf("JoinNetwork() returned an error: %v", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	// Test that values are stored and found with every message encrypted
+	data := []byte("nobody else may read this value")
+	hash, _, err := nodes[0].Store(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Store() returned an error: %v", err)
+	}
+	nodes[4].network.storage = NewMemoryStorage(time.Minute, StorageLimits{})
+	if found, err := nodes[4].LookupData(context.Background(), hash); err != nil || !bytes.Equal(found, data) {
+		t.Fatalf("Expected LookupData() to find the value, but got %q %v", found, err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	for _, packet := range recorder.sent {
+		if bytes.Contains(packet, data) || bytes.Contains(packet, []byte(nodes[0].network.rt.me.ID[:])) {
+			t.Fatal("Expected no value or node ID to be sent in plaintext")
+		}
+	}
+}
+
+func TestEncryptionMismatch(t *testing.T) {
+	memory := NewMemoryNetwork()
+	encrypted, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	plaintext, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+
+	// Test that a plaintext node cannot join through an encrypted one, and is not added to its routing table
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := plaintext.JoinNetwork(ctx, &encrypted.network.rt.me); err == nil {
+		t.Error("Expected a plaintext node not to join an encrypted network")
+	}
+	if encrypted.network.rt.NumContacts() != 0 {
+		t.Error("Expected the plaintext node not to be added to the routing table")
+	}
+
+	// Test that an encrypted node cannot make a handshake with a plaintext one
+	err = encrypted.network.SendPingMessage(context.Background(), &plaintext.network.rt.me, NewRandomKademliaID())
+	if !errors.Is(err, ErrHandshakeFailed) {
+		t.Errorf("Expected the handshake to fail, but got %v", err)
+	}
+}
+
+func TestSealOverhead(t *testing.T) {
+	memory := NewMemoryNetwork()
+	recorder := &recordingTransport{Transport: memory.NewTransport()}
+	senderNode, err := NewMemoryNode(recorder, NewIdentity(), strings.Repeat("a", maxAddressLength-len(":80")), (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+
+	// Test that the largest message fits in a packet even when it is sealed by a node with the longest address
+	if err := sender.seal(&receiver.rt.me, make([]byte, sender.maxMessageSize())); err != nil {
+		t.Fatalf("seal() returned an error: %v", err)
+	}
+	recorder.mu.Lock()
+	sealed := recorder.sent[len(recorder.sent)-1]
+	recorder.mu.Unlock()
+	if len(sealed) > maxPacketSize {
+		t.Errorf("Expected a sealed packet of at most %d bytes, but got %d", maxPacketSize, len(sealed))
+	}
+
+	// Test that a node cannot have an address that would make its packets larger
+	identity := NewIdentity()
+	rt := NewRoutingTable(identity.Contact(strings.Repeat("a", maxAddressLength+1)))
+	if _, err := NewNetworkWithTransport(memory.NewTransport(), identity, rt, 20, 3, time.Minute, time.Minute); err == nil {
+		t.Error("Expected NewNetwork() to refuse an address that is too long")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	memory := NewMemoryNetwork()
+	senderNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+
+	// Test that a handshake with an address fails if another node than the contact answers
+	impostor := NewContact(NewRandomKademliaID(), receiver.rt.me.Address)
+	if err := sender.SendPingMessage(context.Background(), &impostor, NewRandomKademliaID()); !errors.Is(err, ErrHandshakeFailed) {
+		t.Errorf("Expected the handshake to fail for a contact with another ID, but got %v", err)
+	}
+
+	// Test that a packet sealed with an outdated session key is answered with a new handshake, after which
+	// messages arrive again
+	sender.channel.mu.Lock()
+	sender.channel.peers[receiver.rt.me.Address].aead, _ = sender.channel.sessionKey(sender.channel.exchangeKey.PublicKey())
+	sender.channel.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rpcID := NewRandomKademliaID()
+	sender.SendPingMessage(ctx, &receiver.rt.me, rpcID)
+	if _, err := sender.ListenForResponse(ctx, rpcID); err == nil {
+		t.Fatal("Expected no response to a message sealed with an outdated session")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rpcID = NewRandomKademliaID()
+	sender.SendPingMessage(ctx, &receiver.rt.me, rpcID)
+	if _, err := sender.ListenForResponse(ctx, rpcID); err != nil {
+		t.Errorf("Expected a response after a new handshake, but got %v", err)
+	}
+}
+
+// Returns a handshake from address signed by a new identity with a new exchange key.
+func newTestHandshake(t *testing.T, address string) *protobuf.Handshake {
+	identity := NewIdentity()
+	exchangeKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	handshake := &protobuf.Handshake{
+		PublicKey:   identity.PrivateKey.Public().(ed25519.PublicKey),
+		ExchangeKey: exchangeKey.PublicKey().Bytes(),
+		Address:     address,
+		Timestamp:   time.Now().UnixMilli(),
+	}
+	handshake.Signature = ed25519.Sign(identity.PrivateKey, handshakeSignedData(handshake))
+	return handshake
+}
+
+func TestSessionLimits(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodeNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	node := nodeNode.network
+	channel := node.channel
+
+	// Test that a session that has not been used for a while is dropped by the next handshake
+	idle := newTestHandshake(t, "10.0.1.1:80")
+	if err := node.receiveHandshake(idle); err != nil {
+		t.Fatalf("receiveHandshake() returned an error: %v", err)
+	}
+	channel.mu.Lock()
+	channel.peers["10.0.1.1:80"].lastUsed = time.Now().Add(-sessionIdleTime - time.Second)
+	channel.mu.Unlock()
+	node.receiveHandshake(newTestHandshake(t, "10.0.1.2:80"))
+	channel.mu.Lock()
+	if _, exist := channel.sessions[string(idle.ExchangeKey)]; exist || channel.peers["10.0.1.1:80"] != nil {
+		t.Error("Expected the idle session to be dropped")
+	}
+	channel.mu.Unlock()
+
+	// Test that handshakes from ever new keys do not grow the sessions beyond the limit
+	for i := 0; i < maxSessions; i++ {
+		node.receiveHandshake(newTestHandshake(t, fmt.Sprintf("10.%d.%d.%d:80", 2+i/65536, i/256%256, i%256)))
+	}
+	channel.mu.Lock()
+	defer channel.mu.Unlock()
+	if len(channel.peers) > maxSessions || len(channel.sessions) > maxSessions {
+		t.Errorf("Expected at most %d sessions, but got %d by address and %d by key", maxSessions, len(channel.peers), len(channel.sessions))
+	}
+	if channel.peers["10.0.1.2:80"] != nil {
+		t.Error("Expected the least recently used session to be dropped")
+	}
+}
+
+func TestHandshakeReplies(t *testing.T) {
+	memory := NewMemoryNetwork()
+	recorder := &recordingTransport{Transport: memory.NewTransport()}
+	nodeNode, err := NewMemoryNode(recorder, NewIdentity(), "10.0.0.1", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	node := nodeNode.network
+
+	// Test that a handshake that asks for an answer is answered once, however often it is replayed
+	handshake := newTestHandshake(t, "10.0.1.1:80")
+	identity := NewIdentity()
+	handshake.PublicKey = identity.PrivateKey.Public().(ed25519.PublicKey)
+	handshake.Reply = true
+	handshake.Signature = ed25519.Sign(identity.PrivateKey, handshakeSignedData(handshake))
+	for i := 0; i < 5; i++ {
+		if err := node.receiveHandshake(handshake); err != nil {
+			t.Fatalf("receiveHandshake() returned an error: %v", err)
+		}
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.sent) != 1 {
+		t.Errorf("Expected 1 answer to the replayed handshake, but got %d", len(recorder.sent))
+	}
+}
+
+func TestSealedSender(t *testing.T) {
+	memory := NewMemoryNetwork()
+	senderNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2", (*Network).EnableEncryption)
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+
+	// A message that is signed by another node than the one the session was agreed with
+	other := NewIdentity()
+	contact := other.Contact("10.0.0.3:80")
+	message := &protobuf.KademliaMessage{Sender: contact.Node(), RpcId: NewRandomKademliaID()[:], Body: &protobuf.KademliaMessage_Ping{Ping: &protobuf.Ping{}}}
+	other.sign(message)
+	data, err := protobuf.SerializeMessage(message)
+	if err != nil {
+		t.Fatalf("SerializeMessage() returned an error: %v", err)
+	}
+
+	// Test that the message is dropped, even though it is signed and sealed with a valid session
+	if err := sender.seal(&receiver.rt.me, data); err != nil {
+		t.Fatalf("seal() returned an error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for receiver.InvalidMessages() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if receiver.InvalidMessages() != 1 {
+		t.Errorf("Expected 1 invalid message, but got %d", receiver.InvalidMessages())
+	}
+	if receiver.rt.NumContacts() != 0 {
+		t.Error("The sender named in the message should not be added to the routing table")
+	}
+}
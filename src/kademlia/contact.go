@@ -1,9 +1,8 @@
 package kademlia
 
 import (
-	"errors"
+	"d7024e/protobuf"
 	"fmt"
-	"regexp"
 	"sort"
 )
 
@@ -20,20 +19,19 @@ func NewContact(id *KademliaID, address string) Contact {
 	return Contact{id, address, nil}
 }
 
-func NewContactFromString(str string) (Contact, error) {
-	re := regexp.MustCompile(`contact\((.*?)\)`)
-
-	// Find the first match of the pattern in the input string
-	match := re.FindStringSubmatch(str)
-
-	// Check if a match was found
-	if len(match) > 1 {
-		// Split the elements string into a slice of elements
-		elements := regexp.MustCompile(`,\s*`).Split(match[1], -1)
-		return Contact{NewKademliaID(elements[0]), elements[1], NewKademliaID(elements[2])}, nil
+// NewContactFromNode returns a new instance of a Contact based on a protobuf Node
+func NewContactFromNode(node *protobuf.Node) (Contact, error) {
+	id, err := NewKademliaIDFromBytes(node.GetId())
+	if err != nil {
+		return Contact{}, fmt.Errorf("NewContactFromNode: %w", err)
 	}
 
-	return NewContact(NewRandomKademliaID(), "0"), errors.New("NewContactFromString: failed to extract data from string")
+	return NewContact(id, node.GetAddress()), nil
+}
+
+// Node returns the protobuf Node representation of a Contact
+func (contact *Contact) Node() *protobuf.Node {
+	return &protobuf.Node{Id: contact.ID[:], Address: contact.Address}
 }
 
 // CalcDistance calculates the distance to the target and
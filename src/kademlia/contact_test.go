@@ -4,26 +4,24 @@ import (
 	"testing"
 )
 
-func TestNewContactFromString(t *testing.T) {
+func TestNewContactFromNode(t *testing.T) {
 	// Test valid input
-	str := "contact(0123456789abcdef0123456789abcdef01234567, 192.168.1.1, 0123456789abcdef0123456789abcdef01234568)"
-	contact, err := NewContactFromString(str)
+	contact := NewContact(NewKademliaID("0123456789abcdef0123456789abcdef01234567"), "192.168.1.1")
+	result, err := NewContactFromNode(contact.Node())
 	if err != nil {
-		t.Errorf("NewContactFromString() returned an error for valid input: %v", err)
+		t.Errorf("NewContactFromNode() returned an error for valid input: %v", err)
 	}
-	expectedID := "0123456789abcdef0123456789abcdef01234567"
-	expectedAddress := "192.168.1.1"
-	expectedDistance := "0123456789abcdef0123456789abcdef01234568"
 
-	if contact.ID.String() != expectedID || contact.Address != expectedAddress || contact.distance.String() != expectedDistance {
-		t.Errorf("NewContactFromString() returned incorrect Contact data.\nGot: %s\nExpected: %s", contact.String(), str)
+	if !result.ID.Equals(contact.ID) || result.Address != contact.Address {
+		t.Errorf("NewContactFromNode() returned incorrect Contact data.\nGot: %s, %s\nExpected: %s, %s", result.ID.String(), result.Address, contact.ID.String(), contact.Address)
 	}
 
 	// Test invalid input
-	str = "invalid_contact_format"
-	_, err = NewContactFromString(str)
+	node := contact.Node()
+	node.Id = node.Id[:IDLength-1]
+	_, err = NewContactFromNode(node)
 	if err == nil {
-		t.Error("NewContactFromString() did not return an error for invalid input")
+		t.Error("NewContactFromNode() did not return an error for an ID of invalid length")
 	}
 }
 
@@ -0,0 +1,460 @@
+package kademlia
+
+import (
+	"bufio"
+	"bytes"
+	"d7024e/utils"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Types of the records in the log of a FileStorage
+const (
+	putRecord    byte = 1 // A value together with its expiry, last store time, flags and kind
+	touchRecord  byte = 2 // A new expiry, last store time and flags for a value written earlier in the log
+	deleteRecord byte = 3 // A value that was evicted
+)
+
+// Every record starts with the length and the CRC-32 of its payload
+const recordHeaderSize = 8
+
+// Largest payload of a record, a value of the largest size that can be received and the fields around it
+const maxRecordSize = maxTransferSize + 1 + 2 + math.MaxUint16 + 8 + 8 + 1 + 1
+
+// Flags of a value in put and touch records
+const cachedFlag byte = 1
+
+// How often the log is checked for compaction
+const compactInterval = 10 * time.Minute
+
+// FileStorage keeps the values in memory and appends every change to a log file, so the values and
+// their TTLs survive a restart. Expired values are never deleted from the log, they are skipped when
+// the log is read and dropped when it is compacted.
+type FileStorage struct {
+	mu      sync.Mutex // Serializes changes so the log has them in the same order as memory
+	memory  *MemoryStorage
+	path    string
+	file    *os.File
+	records int // Records in the log, compared to the number of values to decide when to compact
+	done    chan struct{}
+}
+
+// Opens the log at path, creating it if it does not exist, and loads the values in it. A record that
+// was only partly written when the node stopped is cut off the end of the log.
+func OpenFileStorage(path string, defaultTTL time.Duration, limits StorageLimits) (*FileStorage, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("OpenFileStorage: failed to create directory %w", err)
+	}
+
+	// A leftover compacted log means a compaction was interrupted before it replaced the log
+	os.Remove(compactPath(path))
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("OpenFileStorage: failed to open log %w", err)
+	}
+
+	storage := &FileStorage{
+		memory: NewMemoryStorage(defaultTTL, limits),
+		path:   path,
+		file:   file,
+		done:   make(chan struct{}),
+	}
+
+	err = storage.load()
+	if err != nil {
+		file.Close()
+		storage.memory.Close()
+		return nil, fmt.Errorf("OpenFileStorage: %w", err)
+	}
+
+	go storage.startCompactionTask()
+	return storage, nil
+}
+
+// Stores data and appends it to the log. The log is synced before returning, so a value that was
+// reported as stored survives a crash.
+func (storage *FileStorage) StoreData(key string, data []byte, ttl time.Duration) error {
+	return storage.store("StoreData", StoredValue{Key: key, Data: data}, ttl)
+}
+
+// Stores a record of kind and appends it to the log.
+func (storage *FileStorage) StoreRecord(key string, kind RecordKind, data []byte, ttl time.Duration) error {
+	return storage.store("StoreRecord", StoredValue{Key: key, Data: data, Kind: kind}, ttl)
+}
+
+// Stores a cached copy of data and appends it to the log.
+func (storage *FileStorage) CacheData(key string, data []byte, ttl time.Duration) error {
+	return storage.store("CacheData", StoredValue{Key: key, Data: data, Cached: true}, ttl)
+}
+
+// Stores value like StoreData, or like CacheData if it is cached, and appends the change to the log.
+func (storage *FileStorage) store(operation string, value StoredValue, ttl time.Duration) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	key := value.Key
+	existing, _, exist := storage.memory.entry(key)
+	evicted, err := storage.memory.storeData(value, ttl)
+	if err != nil {
+		return err
+	}
+
+	// Evicted values must not come back when the log is read, so their delete records are synced like puts
+	for _, evictedKey := range evicted {
+		if err := storage.append(encodeRecord(deleteRecord, StoredValue{Key: evictedKey}, time.Time{})); err != nil {
+			utils.LogError("FileStorage.%s: failed to write the eviction of %s to the log %s", operation, evictedKey, err)
+		}
+	}
+	if len(evicted) > 0 {
+		if err := storage.file.Sync(); err != nil {
+			utils.LogError("FileStorage.%s: failed to sync the evictions to the log %s", operation, err)
+		}
+	}
+
+	// Storing the same data again only changes its expiry, so the data does not have to be written again
+	stored, lastStored, _ := storage.memory.entry(key)
+	if exist && time.Now().Before(existing.Expires) && bytes.Equal(existing.Data, stored.Data) {
+		return storage.appendTouch(key)
+	}
+
+	err = storage.append(encodeRecord(putRecord, stored, lastStored))
+	if err == nil {
+		err = storage.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("FileStorage.%s: failed to write %s to the log %w", operation, key, err)
+	}
+	return nil
+}
+
+// Tries to retrieve data and returns it together with the success of the fetch. The reset TTL is appended to the log.
+func (storage *FileStorage) FetchData(key string) ([]byte, bool) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	data, exist := storage.memory.FetchData(key)
+	if exist {
+		storage.logTouch("FetchData", key)
+	}
+	return data, exist
+}
+
+// Refreshes the TTL for a data object and appends the new TTL to the log. Returns true if the TTL was refreshed.
+func (storage *FileStorage) RefreshDataTTL(key string, ttl time.Duration) bool {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	refreshed := storage.memory.RefreshDataTTL(key, ttl)
+	if refreshed {
+		storage.logTouch("RefreshDataTTL", key)
+	}
+	return refreshed
+}
+
+// Returns the kind of the record stored under key.
+func (storage *FileStorage) Kind(key string) RecordKind {
+	return storage.memory.Kind(key)
+}
+
+// Returns the unexpired replicas that have not been stored or republished for at least age.
+func (storage *FileStorage) ValuesStoredBefore(age time.Duration) []StoredValue {
+	return storage.memory.ValuesStoredBefore(age)
+}
+
+// Records that key was just republished, so it is skipped until it is due again, also after a restart.
+func (storage *FileStorage) MarkRepublished(key string) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	storage.memory.MarkRepublished(key)
+	storage.logTouch("MarkRepublished", key)
+}
+
+// Returns how much of the storage limits is used.
+func (storage *FileStorage) Usage() StorageUsage {
+	return storage.memory.Usage()
+}
+
+// Stops the compaction and cleanup tasks and closes the log.
+func (storage *FileStorage) Close() error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	if storage.file == nil {
+		return errors.New("FileStorage.Close: already closed")
+	}
+	close(storage.done)
+	storage.memory.Close()
+
+	err := storage.file.Close()
+	storage.file = nil
+	if err != nil {
+		return fmt.Errorf("FileStorage.Close: %w", err)
+	}
+	return nil
+}
+
+// Reads every record in the log into memory and drops the values that have expired since they were
+// written. The log is truncated after the last complete record.
+func (storage *FileStorage) load() error {
+	reader := bufio.NewReader(storage.file)
+	var offset int64
+
+	// A put may be followed by a touch that extends it, so expired values are only dropped at the end
+	defer storage.memory.removeExpired(time.Now())
+
+	for {
+		payload, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			utils.Log(3, "Discarding the end of %s after %d bytes: %s", storage.path, offset, err)
+			if err := storage.file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate log %w", err)
+			}
+			return nil
+		}
+
+		recordType, value, lastStored, err := decodeRecord(payload)
+		if err != nil {
+			return fmt.Errorf("corrupt record at offset %d %w", offset, err)
+		}
+		offset += int64(recordHeaderSize + len(payload))
+		storage.records++
+
+		switch recordType {
+		case putRecord:
+			storage.memory.restore(value, lastStored)
+		case touchRecord:
+			existing, _, exist := storage.memory.entry(value.Key)
+			if exist {
+				existing.Expires = value.Expires
+				existing.Cached = value.Cached
+				storage.memory.restore(existing, lastStored)
+			}
+		case deleteRecord:
+			storage.memory.discard(value.Key)
+		}
+	}
+}
+
+// Appends the current expiry and last store time of key to the log. Must be called with mu held.
+func (storage *FileStorage) appendTouch(key string) error {
+	value, lastStored, exist := storage.memory.entry(key)
+	if !exist {
+		return nil
+	}
+
+	err := storage.append(encodeRecord(touchRecord, StoredValue{Key: key, Expires: value.Expires, Cached: value.Cached}, lastStored))
+	if err != nil {
+		return fmt.Errorf("FileStorage: failed to write the TTL of %s to the log %w", key, err)
+	}
+	return nil
+}
+
+// Appends a touch record and logs the error if it fails. A lost touch record only means the value
+// expires or is republished a bit early after a restart.
+func (storage *FileStorage) logTouch(operation string, key string) {
+	if err := storage.appendTouch(key); err != nil {
+		utils.LogError("FileStorage.%s: %s", operation, err)
+	}
+}
+
+// Appends a record to the log in a single write. Must be called with mu held.
+func (storage *FileStorage) append(payload []byte) error {
+	if storage.file == nil {
+		return errors.New("storage is closed")
+	}
+
+	_, err := storage.file.Write(frameRecord(payload))
+	if err != nil {
+		return err
+	}
+	storage.records++
+	return nil
+}
+
+// Periodically compacts the log once most of its records are outdated.
+func (storage *FileStorage) startCompactionTask() {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := storage.compact(false); err != nil {
+				utils.LogError("%s", err)
+			}
+		case <-storage.done:
+			return
+		}
+	}
+}
+
+// Rewrites the log with a single record for every unexpired value. Unless force is set, the log is
+// only rewritten if it holds more outdated records than values. The new log is written next to the
+// old one and renamed over it, so the old log stays intact if the node stops halfway.
+func (storage *FileStorage) compact(force bool) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	if storage.file == nil {
+		return nil
+	}
+
+	values, lastStored := storage.memory.snapshot()
+	if !force && storage.records <= 2*len(values) {
+		return nil
+	}
+
+	tmpPath := compactPath(storage.path)
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("FileStorage.compact: failed to create %s %w", tmpPath, err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for i, value := range values {
+		if _, err = writer.Write(frameRecord(encodeRecord(putRecord, value, lastStored[i]))); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, storage.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("FileStorage.compact: failed to write compacted log %w", err)
+	}
+
+	// The old file handle still points to the log that was replaced
+	storage.file.Close()
+	storage.file, err = os.OpenFile(storage.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("FileStorage.compact: failed to reopen log %w", err)
+	}
+
+	utils.Log(1, "Compacted %s from %d to %d records", storage.path, storage.records, len(values))
+	storage.records = len(values)
+	return nil
+}
+
+// Returns the path the compacted log is written to before it replaces the log at path.
+func compactPath(path string) string {
+	return path + ".compact"
+}
+
+// Reads the payload of the next record. Returns io.EOF if the log ends before the record starts, and
+// another error if the record is incomplete, longer than a record can be or its checksum does not match.
+func readRecord(reader io.Reader) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return nil, fmt.Errorf("record length %d exceeds %d", length, maxRecordSize)
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return payload, nil
+}
+
+// Puts the header in front of a record payload.
+func frameRecord(payload []byte) []byte {
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+// Encodes a record as its type, the key prefixed by its length and, except for delete records, the
+// expiry and last store time in unix nanoseconds and the flags, followed by the kind and the data of put records.
+func encodeRecord(recordType byte, value StoredValue, lastStored time.Time) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte(recordType)
+	binary.Write(&buffer, binary.BigEndian, uint16(len(value.Key)))
+	buffer.WriteString(value.Key)
+	if recordType == deleteRecord {
+		return buffer.Bytes()
+	}
+	binary.Write(&buffer, binary.BigEndian, value.Expires.UnixNano())
+	binary.Write(&buffer, binary.BigEndian, lastStored.UnixNano())
+	var flags byte
+	if value.Cached {
+		flags |= cachedFlag
+	}
+	buffer.WriteByte(flags)
+	if recordType == putRecord {
+		buffer.WriteByte(byte(value.Kind))
+		buffer.Write(value.Data)
+	}
+	return buffer.Bytes()
+}
+
+// Decodes a record payload written by encodeRecord.
+func decodeRecord(payload []byte) (byte, StoredValue, time.Time, error) {
+	if len(payload) < 3 {
+		return 0, StoredValue{}, time.Time{}, errors.New("record too short")
+	}
+	recordType := payload[0]
+	keyLength := int(binary.BigEndian.Uint16(payload[1:3]))
+	payload = payload[3:]
+
+	if recordType != putRecord && recordType != touchRecord && recordType != deleteRecord {
+		return 0, StoredValue{}, time.Time{}, fmt.Errorf("unknown record type %d", recordType)
+	}
+	if len(payload) < keyLength {
+		return 0, StoredValue{}, time.Time{}, errors.New("record too short")
+	}
+
+	value := StoredValue{Key: string(payload[:keyLength])}
+	payload = payload[keyLength:]
+	if recordType == deleteRecord {
+		return recordType, value, time.Time{}, nil
+	}
+	if len(payload) < 17 {
+		return 0, StoredValue{}, time.Time{}, errors.New("record too short")
+	}
+	value.Expires = time.Unix(0, int64(binary.BigEndian.Uint64(payload[0:8])))
+	lastStored := time.Unix(0, int64(binary.BigEndian.Uint64(payload[8:16])))
+	value.Cached = payload[16]&cachedFlag != 0
+	if recordType == putRecord {
+		if len(payload) < 18 {
+			return 0, StoredValue{}, time.Time{}, errors.New("record too short")
+		}
+		value.Kind = RecordKind(payload[17])
+		value.Data = payload[18:]
+	}
+	return recordType, value, lastStored, nil
+}
@@ -0,0 +1,221 @@
+package kademlia
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorageRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage", "kademlia.log")
+	storage, err := OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+
+	storage.StoreData("key", []byte("data"), time.Hour)
+	storage.StoreData("short", []byte("short lived"), 10*time.Millisecond)
+	if err := storage.StoreData("key", []byte("other data"), time.Hour); err == nil {
+		t.Error("Expected an error when storing different data for key")
+	}
+	expires := storage.memory.dataStore["key"].TTL
+	storage.Close()
+
+	// Test that values and their TTLs are loaded from the log, and expired values are not
+	time.Sleep(20 * time.Millisecond)
+	storage, err = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	data, exist := storage.FetchData("key")
+	if !exist || string(data) != "data" {
+		t.Fatalf("Expected the value to survive a restart, but got %s", string(data))
+	}
+	if _, exist := storage.memory.dataStore["short"]; exist || storage.Usage().Keys != 1 {
+		t.Error("Expected an expired value not to be loaded")
+	}
+
+	// Test that the TTL reset by FetchData() and the last store time are kept
+	storage.MarkRepublished("key")
+	storage.Close()
+	storage, err = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	defer storage.Close()
+	if values := storage.ValuesStoredBefore(0); len(values) != 1 || !values[0].Expires.Before(expires) {
+		t.Errorf("Expected the TTL reset by FetchData() to be kept, but got %v", values)
+	}
+	if values := storage.ValuesStoredBefore(time.Minute); len(values) != 0 {
+		t.Errorf("Expected no values to be due right after a restart, but got %d", len(values))
+	}
+}
+
+func TestFileStorageTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kademlia.log")
+	storage, err := OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	storage.StoreData("key1", []byte("data1"), time.Hour)
+	storage.StoreData("key2", []byte("data2"), time.Hour)
+	storage.Close()
+
+	// Test that a record cut off halfway is discarded
+	record := frameRecord(encodeRecord(putRecord, StoredValue{Key: "key3", Data: []byte("data3"), Expires: time.Now().Add(time.Hour)}, time.Now()))
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write(record[:len(record)/2])
+	file.Close()
+
+	storage, err = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	for _, key := range []string{"key1", "key2"} {
+		if _, exist := storage.FetchData(key); !exist {
+			t.Errorf("Expected %s to be loaded from the log", key)
+		}
+	}
+	if _, exist := storage.FetchData("key3"); exist {
+		t.Error("Expected the torn record not to be loaded")
+	}
+	storage.Close()
+
+	// Test that a complete record with a bad checksum is discarded
+	info, _ := os.Stat(path)
+	size := info.Size()
+	record[len(record)-1] ^= 0xff
+	file, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write(record)
+	file.Close()
+
+	storage, err = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	defer storage.Close()
+	if _, exist := storage.FetchData("key3"); exist {
+		t.Error("Expected the record with a bad checksum not to be loaded")
+	}
+
+	// The damaged record is cut off so that new records follow the last good one
+	storage.StoreData("key4", []byte("data4"), time.Hour)
+	storage.Close()
+	storage, _ = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if _, exist := storage.FetchData("key4"); !exist {
+		t.Error("Expected a value stored after recovery to be loaded")
+	}
+	if info, _ := os.Stat(path); info.Size() <= size {
+		t.Error("Expected new records to be appended after the recovered log")
+	}
+	storage.Close()
+
+	// Test that a header with an impossible length is discarded without reading on
+	binary.BigEndian.PutUint32(record[0:4], math.MaxUint32)
+	file, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write(record)
+	file.Close()
+
+	storage, err = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	defer storage.Close()
+	if _, exist := storage.FetchData("key4"); !exist {
+		t.Error("Expected the records before the corrupt header to be loaded")
+	}
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kademlia.log")
+	storage, err := OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+
+	storage.StoreData("key", []byte("data"), time.Hour)
+	storage.StoreData("short", []byte("short lived"), time.Millisecond)
+	for i := 0; i < 10; i++ {
+		storage.FetchData("key")
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Test that the log is rewritten with one record for every unexpired value
+	if err := storage.compact(false); err != nil {
+		t.Fatalf("compact() returned an error: %v", err)
+	}
+	if storage.records != 1 {
+		t.Errorf("Expected 1 record after compaction, but there are %d", storage.records)
+	}
+
+	// Test that a log without outdated records is left alone
+	info, _ := os.Stat(path)
+	if err := storage.compact(false); err != nil {
+		t.Fatalf("compact() returned an error: %v", err)
+	}
+	if after, _ := os.Stat(path); !os.SameFile(info, after) {
+		t.Error("Expected a compact log not to be rewritten")
+	}
+
+	// Test that records appended after compaction go to the new log
+	storage.StoreData("key2", []byte("data2"), time.Hour)
+	storage.Close()
+	storage, err = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	defer storage.Close()
+	if storage.records != 2 {
+		t.Errorf("Expected 2 records in the log, but there are %d", storage.records)
+	}
+	for _, key := range []string{"key", "key2"} {
+		if _, exist := storage.FetchData(key); !exist {
+			t.Errorf("Expected %s to be loaded from the compacted log", key)
+		}
+	}
+}
+
+func TestOpenStorage(t *testing.T) {
+	storage, err := OpenStorage(MEMORY_STORAGE, "", time.Minute, StorageLimits{})
+	if _, ok := storage.(*MemoryStorage); err != nil || !ok {
+		t.Errorf("Expected a MemoryStorage, but got %T %v", storage, err)
+	}
+
+	storage, err = OpenStorage(FILE_STORAGE, filepath.Join(t.TempDir(), "kademlia.log"), time.Minute, StorageLimits{})
+	if _, ok := storage.(*FileStorage); err != nil || !ok {
+		t.Errorf("Expected a FileStorage, but got %T %v", storage, err)
+	}
+	storage.Close()
+
+	if _, err := OpenStorage("disk", "", time.Minute, StorageLimits{}); err == nil {
+		t.Error("OpenStorage() should return an error for an unknown backend")
+	}
+}
+
+func TestFileStorageCached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kademlia.log")
+	storage, err := OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	storage.CacheData("cached", []byte("data"), time.Hour)
+	storage.CacheData("replica", []byte("data"), time.Hour)
+	storage.StoreData("replica", []byte("data"), time.Hour)
+	storage.Close()
+
+	// Test that cached copies are still cached after a restart, and replicas stored over them are not
+	storage, err = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	defer storage.Close()
+	if value, _, _ := storage.memory.entry("cached"); !value.Cached {
+		t.Error("Expected the cached copy to be loaded as cached")
+	}
+	if value, _, _ := storage.memory.entry("replica"); value.Cached {
+		t.Error("Expected the replica stored over a cached copy to be loaded as a replica")
+	}
+}
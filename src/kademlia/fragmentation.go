@@ -0,0 +1,311 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	fragmentPayloadSize = 3072                   // Bytes of the serialized message carried by each fragment
+	fragmentWindow      = 32                     // Maximum number of unacknowledged fragments in flight
+	fragmentTimeout     = 500 * time.Millisecond // Time to wait for an ack before resending fragments
+	fragmentRetries     = 5                      // Number of times a fragment is resent before the transfer fails
+	maxTransferSize     = 64 * 1024 * 1024       // Largest message that can be reassembled
+	transferExpiry      = 30 * time.Second       // Time after which an incomplete incoming transfer is dropped
+	maxSenderTransfers  = 4                      // Incomplete incoming transfers kept per sender
+	maxIncomingBytes    = 2 * maxTransferSize    // Bytes held by all incomplete incoming transfers together
+)
+
+// An incoming transfer that is being reassembled
+type incomingTransfer struct {
+	address   string
+	fragments [][]byte
+	received  int
+	bytes     int
+	started   time.Time
+	lastSeen  time.Time
+}
+
+// fragmentBuffer keeps track of outgoing transfers waiting for acks and incoming transfers being reassembled
+type fragmentBuffer struct {
+	mu            sync.Mutex
+	outgoing      map[string]chan uint32
+	incoming      map[string]*incomingTransfer
+	incomingBytes int // Bytes held by the incoming transfers
+	completed     map[string]time.Time
+}
+
+// newFragmentBuffer returns a new instance of a fragmentBuffer
+func newFragmentBuffer() *fragmentBuffer {
+	return &fragmentBuffer{
+		outgoing:  make(map[string]chan uint32),
+		incoming:  make(map[string]*incomingTransfer),
+		completed: make(map[string]time.Time),
+	}
+}
+
+// Splits data into fragments and sends them to contact, resending fragments until each one is acknowledged
+// or ctx is done.
+func (network *Network) sendFragmentedMessage(ctx context.Context, contact *Contact, data []byte) error {
+	if len(data) > maxTransferSize {
+		return fmt.Errorf("sendFragmentedMessage: message of %d bytes exceeds the limit of %d bytes", len(data), maxTransferSize)
+	}
+
+	payloads := [][]byte{}
+	for start := 0; start < len(data); start += fragmentPayloadSize {
+		end := start + fragmentPayloadSize
+		if end > len(data) {
+			end = len(data)
+		}
+		payloads = append(payloads, data[start:end])
+	}
+
+	transferID := NewRandomKademliaID()
+	acks := network.fragments.registerOutgoing(transferID, len(payloads))
+	defer network.fragments.removeOutgoing(transferID)
+
+	utils.Log(1, "Sending %d bytes to %s in %d fragments", len(data), contact.Address, len(payloads))
+
+	// Map of fragments in flight to the number of times they have been resent
+	inFlight := make(map[uint32]int)
+	next, acked := 0, 0
+
+	for acked < len(payloads) {
+		// Fill the window with new fragments
+		for len(inFlight) < fragmentWindow && next < len(payloads) {
+			err := network.sendFragment(contact, transferID, uint32(next), payloads)
+			if err != nil {
+				return err
+			}
+			inFlight[uint32(next)] = 0
+			next++
+		}
+
+		select {
+		case index := <-acks:
+			if _, exist := inFlight[index]; exist {
+				delete(inFlight, index)
+				acked++
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(fragmentTimeout):
+			for index, retries := range inFlight {
+				if retries >= fragmentRetries {
+					return fmt.Errorf("sendFragmentedMessage: fragment %d of %d was not acknowledged by %s", index, len(payloads), contact.Address)
+				}
+				inFlight[index] = retries + 1
+
+				err := network.sendFragment(contact, transferID, index, payloads)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sends the fragment at index to contact.
+func (network *Network) sendFragment(contact *Contact, transferID *KademliaID, index uint32, payloads [][]byte) error {
+	message := network.newMessage(transferID)
+	message.Body = &protobuf.KademliaMessage_Fragment{Fragment: &protobuf.Fragment{
+		Index:   index,
+		Count:   uint32(len(payloads)),
+		Payload: payloads[index],
+	}}
+
+	data, err := network.serializeMessage(message)
+	if err != nil {
+		return fmt.Errorf("sendFragment: could not build message %w", err)
+	}
+
+	return network.sendMessage(contact, data)
+}
+
+// Acknowledges a received fragment and handles the reassembled message once all fragments have arrived.
+func (network *Network) handleFragment(contact *Contact, transferID *KademliaID, fragment *protobuf.Fragment) {
+	data, err := network.fragments.addIncoming(contact.Address, transferID, fragment)
+	if err != nil {
+		utils.LogError("handleFragment: dropped fragment from %s %s", contact.Address, err)
+		return
+	}
+
+	message := network.newMessage(transferID)
+	message.Body = &protobuf.KademliaMessage_FragmentAck{FragmentAck: &protobuf.FragmentAck{Index: fragment.Index}}
+	ack, err := network.serializeMessage(message)
+	if err != nil {
+		utils.LogError("handleFragment: could not build ack %s", err)
+		return
+	}
+	if err := network.sendMessage(contact, ack); err != nil {
+		utils.LogError("handleFragment: could not send ack to %s %s", contact.Address, err)
+	}
+
+	if data != nil {
+		utils.Log(1, "Reassembled %d bytes from %s", len(data), contact.Address)
+		network.handleMessage(contact.Address, contact.ID, data)
+	}
+}
+
+// Registers an outgoing transfer and returns the channel its acks are delivered on.
+func (fragments *fragmentBuffer) registerOutgoing(transferID *KademliaID, count int) chan uint32 {
+	fragments.mu.Lock()
+	defer fragments.mu.Unlock()
+
+	acks := make(chan uint32, count)
+	fragments.outgoing[transferID.String()] = acks
+	return acks
+}
+
+// Removes an outgoing transfer.
+func (fragments *fragmentBuffer) removeOutgoing(transferID *KademliaID) {
+	fragments.mu.Lock()
+	defer fragments.mu.Unlock()
+
+	delete(fragments.outgoing, transferID.String())
+}
+
+// Delivers an ack to the outgoing transfer it belongs to.
+func (fragments *fragmentBuffer) acknowledge(transferID *KademliaID, index uint32) {
+	fragments.mu.Lock()
+	defer fragments.mu.Unlock()
+
+	acks, exist := fragments.outgoing[transferID.String()]
+	if !exist {
+		return
+	}
+
+	select {
+	case acks <- index:
+	default:
+		// Duplicate acks can fill the channel, the fragment has already been acknowledged then
+	}
+}
+
+// Adds a fragment to its incoming transfer. Returns the reassembled message once the
+// last missing fragment arrives, and nil otherwise. When a sender has too many transfers
+// open, or all transfers together hold too many bytes, the oldest transfers are dropped.
+func (fragments *fragmentBuffer) addIncoming(address string, transferID *KademliaID, fragment *protobuf.Fragment) ([]byte, error) {
+	fragments.mu.Lock()
+	defer fragments.mu.Unlock()
+
+	now := time.Now()
+	fragments.removeExpired(now)
+
+	id := address + "/" + transferID.String()
+	if _, done := fragments.completed[id]; done {
+		// The sender missed our ack, so it will be sent again without handling the message twice
+		return nil, nil
+	}
+
+	if fragment.Count == 0 || fragment.Count > maxTransferSize/fragmentPayloadSize+1 {
+		return nil, fmt.Errorf("invalid fragment count %d", fragment.Count)
+	}
+	if fragment.Index >= fragment.Count {
+		return nil, fmt.Errorf("fragment index %d out of range", fragment.Index)
+	}
+	if len(fragment.Payload) > fragmentPayloadSize {
+		return nil, fmt.Errorf("fragment payload of %d bytes exceeds %d bytes", len(fragment.Payload), fragmentPayloadSize)
+	}
+
+	transfer, exist := fragments.incoming[id]
+	if !exist {
+		for fragments.numIncoming(address) >= maxSenderTransfers {
+			fragments.dropOldest(address)
+		}
+		transfer = &incomingTransfer{address: address, fragments: make([][]byte, fragment.Count), started: now}
+		fragments.incoming[id] = transfer
+	}
+	if len(transfer.fragments) != int(fragment.Count) {
+		return nil, fmt.Errorf("fragment count %d does not match transfer", fragment.Count)
+	}
+
+	transfer.lastSeen = now
+	if transfer.fragments[fragment.Index] == nil {
+		transfer.fragments[fragment.Index] = fragment.Payload
+		transfer.received++
+		transfer.bytes += len(fragment.Payload)
+		fragments.incomingBytes += len(fragment.Payload)
+	}
+	for fragments.incomingBytes > maxIncomingBytes {
+		fragments.dropOldest("")
+	}
+	if _, kept := fragments.incoming[id]; !kept {
+		return nil, fmt.Errorf("transfer dropped, too many bytes are being reassembled")
+	}
+
+	if transfer.received < len(transfer.fragments) {
+		return nil, nil
+	}
+
+	data := []byte{}
+	for _, payload := range transfer.fragments {
+		data = append(data, payload...)
+	}
+	fragments.removeIncoming(id)
+	fragments.completed[id] = now
+
+	return data, nil
+}
+
+// Drops incomplete transfers and completed transfer ids that have not been seen for a while.
+func (fragments *fragmentBuffer) removeExpired(now time.Time) {
+	for id, transfer := range fragments.incoming {
+		if now.Sub(transfer.lastSeen) > transferExpiry {
+			utils.Log(2, "Dropping incomplete transfer %s", id)
+			fragments.removeIncoming(id)
+		}
+	}
+
+	for id, completedAt := range fragments.completed {
+		if now.Sub(completedAt) > transferExpiry {
+			delete(fragments.completed, id)
+		}
+	}
+}
+
+// Returns the number of incomplete incoming transfers from address.
+func (fragments *fragmentBuffer) numIncoming(address string) int {
+	count := 0
+	for _, transfer := range fragments.incoming {
+		if transfer.address == address {
+			count++
+		}
+	}
+	return count
+}
+
+// Drops the incomplete incoming transfer that started first, only looking at transfers from
+// address unless it is empty.
+func (fragments *fragmentBuffer) dropOldest(address string) {
+	oldestID := ""
+	var oldest *incomingTransfer
+	for id, transfer := range fragments.incoming {
+		if address != "" && transfer.address != address {
+			continue
+		}
+		if oldest == nil || transfer.started.Before(oldest.started) {
+			oldestID, oldest = id, transfer
+		}
+	}
+	if oldest != nil {
+		utils.Log(2, "Dropping incomplete transfer %s to make room for another", oldestID)
+		fragments.removeIncoming(oldestID)
+	}
+}
+
+// Removes an incoming transfer and frees the bytes it held.
+func (fragments *fragmentBuffer) removeIncoming(id string) {
+	if transfer, exist := fragments.incoming[id]; exist {
+		fragments.incomingBytes -= transfer.bytes
+		delete(fragments.incoming, id)
+	}
+}
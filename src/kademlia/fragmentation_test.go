@@ -0,0 +1,260 @@
+package kademlia
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Drops every nth packet sent through it
+type lossyTransport struct {
+	Transport
+	mu   sync.Mutex
+	n    int
+	sent int
+}
+
+func (transport *lossyTransport) Send(address string, data []byte) error {
+	transport.mu.Lock()
+	transport.sent++
+	drop := transport.sent%transport.n == 0
+	transport.mu.Unlock()
+
+	if drop {
+		return nil
+	}
+	return transport.Transport.Send(address, data)
+}
+
+// Waits until storage holds key or the timeout passes.
+func waitForData(storage Storage, key string, timeout time.Duration) ([]byte, bool) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if data, exist := storage.FetchData(key); exist {
+			return data, true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil, false
+}
+
+func TestFragmentedStore(t *testing.T) {
+	memory := NewMemoryNetwork()
+	senderNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	key := NewKademliaID(utils.Hash(data))
+
+	err = sender.SendStoreMessage(context.Background(), key, CONTENT_RECORD, data, 0, &receiver.rt.me, NewRandomKademliaID())
+	if err != nil {
+		t.Fatalf("SendStoreMessage() returned an error: %v", err)
+	}
+
+	result, exist := waitForData(receiver.storage, key.String(), 5*time.Second)
+	if !exist {
+		t.Fatal("Large value was not stored on the receiver")
+	}
+	if !bytes.Equal(result, data) {
+		t.Error("Stored value does not match the sent value")
+	}
+}
+
+func TestFragmentedStoreWithLoss(t *testing.T) {
+	memory := NewMemoryNetwork()
+	lossy := &lossyTransport{Transport: memory.NewTransport(), n: 7}
+	senderNode, err := NewMemoryNode(lossy, NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+
+	data := make([]byte, 200*1024)
+	rand.Read(data)
+	key := NewKademliaID(utils.Hash(data))
+
+	err = sender.SendStoreMessage(context.Background(), key, CONTENT_RECORD, data, 0, &receiver.rt.me, NewRandomKademliaID())
+	if err != nil {
+		t.Fatalf("SendStoreMessage() returned an error: %v", err)
+	}
+
+	result, exist := waitForData(receiver.storage, key.String(), 5*time.Second)
+	if !exist || !bytes.Equal(result, data) {
+		t.Error("Large value was not stored correctly when packets were lost")
+	}
+}
+
+func TestFragmentedSendUnreachable(t *testing.T) {
+	memory := NewMemoryNetwork()
+	senderNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	nobody := NewContact(NewRandomKademliaID(), "10.0.0.2:80")
+
+	err = sender.SendStoreMessage(context.Background(), NewRandomKademliaID(), CONTENT_RECORD, make([]byte, 10*1024), 0, &nobody, NewRandomKademliaID())
+	if err == nil {
+		t.Error("SendStoreMessage() should return an error when no fragments are acknowledged")
+	}
+}
+
+func TestFragmentedSendCancelled(t *testing.T) {
+	memory := NewMemoryNetwork()
+	senderNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	nobody := NewContact(NewRandomKademliaID(), "10.0.0.2:80")
+
+	// Test that a transfer stops resending fragments once the caller gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = sender.SendStoreMessage(ctx, NewRandomKademliaID(), CONTENT_RECORD, make([]byte, 10*1024), 0, &nobody, NewRandomKademliaID())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected SendStoreMessage() to return the error of the context, but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > fragmentTimeout {
+		t.Errorf("Expected the transfer to stop when the context was done, but it took %s", elapsed)
+	}
+}
+
+func TestFragmentBufferAddIncoming(t *testing.T) {
+	fragments := newFragmentBuffer()
+	transferID := NewRandomKademliaID()
+
+	// Test invalid fragments
+	_, err := fragments.addIncoming("address", transferID, &protobuf.Fragment{Index: 3, Count: 3})
+	if err == nil {
+		t.Error("addIncoming() should return an error when the index is out of range")
+	}
+	_, err = fragments.addIncoming("address", transferID, &protobuf.Fragment{Index: 0, Count: 0})
+	if err == nil {
+		t.Error("addIncoming() should return an error when the count is zero")
+	}
+
+	// Test out of order and duplicate fragments
+	order := []uint32{2, 0, 2, 1}
+	var data []byte
+	for i, index := range order {
+		data, err = fragments.addIncoming("address", transferID, &protobuf.Fragment{Index: index, Count: 3, Payload: []byte(fmt.Sprint(index))})
+		if err != nil {
+			t.Fatalf("addIncoming() returned an error: %v", err)
+		}
+		if i < len(order)-1 && data != nil {
+			t.Errorf("addIncoming() returned data before all fragments were received")
+		}
+	}
+	if string(data) != "012" {
+		t.Errorf("Expected reassembled data to be %s, but got %s", "012", string(data))
+	}
+
+	// Test that a resent fragment of a completed transfer is not reassembled again
+	data, err = fragments.addIncoming("address", transferID, &protobuf.Fragment{Index: 1, Count: 3, Payload: []byte("1")})
+	if err != nil || data != nil {
+		t.Error("addIncoming() should ignore fragments of completed transfers")
+	}
+}
+
+func TestFragmentBufferLimits(t *testing.T) {
+	fragments := newFragmentBuffer()
+
+	// Test that a sender opening more transfers than allowed loses its oldest one
+	ids := []*KademliaID{}
+	for i := 0; i <= maxSenderTransfers; i++ {
+		ids = append(ids, NewRandomKademliaID())
+		if _, err := fragments.addIncoming("address", ids[i], &protobuf.Fragment{Index: 0, Count: 2, Payload: []byte("a")}); err != nil {
+			t.Fatalf("addIncoming() returned an error: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	fragments.addIncoming("other", NewRandomKademliaID(), &protobuf.Fragment{Index: 0, Count: 2, Payload: []byte("b")})
+	if fragments.numIncoming("address") != maxSenderTransfers || fragments.numIncoming("other") != 1 {
+		t.Errorf("Expected %d transfers from the sender and 1 from the other, but got %d and %d", maxSenderTransfers, fragments.numIncoming("address"), fragments.numIncoming("other"))
+	}
+	if _, exist := fragments.incoming["address/"+ids[0].String()]; exist {
+		t.Error("Expected the oldest transfer of the sender to be dropped")
+	}
+	if fragments.incomingBytes != maxSenderTransfers+1 {
+		t.Errorf("Expected %d bytes to be held, but got %d", maxSenderTransfers+1, fragments.incomingBytes)
+	}
+
+	// Test that the bytes of a reassembled transfer are freed
+	fragments.addIncoming("address", ids[1], &protobuf.Fragment{Index: 1, Count: 2, Payload: []byte("a")})
+	if fragments.incomingBytes != maxSenderTransfers {
+		t.Errorf("Expected %d bytes to be held, but got %d", maxSenderTransfers, fragments.incomingBytes)
+	}
+
+	// Test that an oversized payload is refused
+	if _, err := fragments.addIncoming("address", ids[2], &protobuf.Fragment{Index: 1, Count: 2, Payload: make([]byte, fragmentPayloadSize+1)}); err == nil {
+		t.Error("addIncoming() should return an error when the payload is too large")
+	}
+}
+
+func TestLargeValueEndToEnd(t *testing.T) {
+	memory := NewMemoryNetwork()
+
+	bootstrap, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	nodes := []*Kademlia{bootstrap}
+	var joined sync.WaitGroup
+	for i := 2; i <= 10; i++ {
+		node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), fmt.Sprintf("10.0.0.%d", i))
+		if err != nil {
+			t.Fatalf("NewMemoryNode() returned an error: %v", err)
+		}
+		nodes = append(nodes, node)
+
+		joined.Add(1)
+		go func() {
+			defer joined.Done()
+			if err := node.JoinNetwork(context.Background(), &bootstrap.network.rt.me); err != nil {
+				t.Errorf("JoinNetwork() returned an error: %v", err)
+			}
+		}()
+	}
+	joined.Wait()
+
+	data := make([]byte, 2*1024*1024)
+	rand.Read(data)
+	hash, replicas, err := nodes[3].Store(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Store() returned an error: %v", err)
+	}
+	if replicas != 9 {
+		t.Errorf("Expected data to be stored on 9 nodes, but it was stored on %d", replicas)
+	}
+
+	result, err := nodes[7].LookupData(context.Background(), hash)
+	if err != nil {
+		t.Fatalf("LookupData() returned an error: %v", err)
+	}
+	if !bytes.Equal(result, data) {
+		t.Errorf("Expected LookupData() to return the stored %d bytes, but got %d bytes", len(data), len(result))
+	}
+}
@@ -0,0 +1,77 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"errors"
+	"fmt"
+)
+
+// Returned when a message is not signed by the node it claims to be from
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Identity is the keypair of a node. The ID of the node is derived from the public key, so only
+// the holder of the private key can send messages as that node.
+type Identity struct {
+	ID             *KademliaID
+	PrivateKey     ed25519.PrivateKey
+	PuzzleSolution []byte // Solves the dynamic puzzle of the ID, see NewPuzzleIdentity
+}
+
+// Create a new Identity instance with a newly generated key. Panics if no key can be generated, since
+// the system then has no randomness to make any other key or nonce with either.
+func NewIdentity() *Identity {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		panic(fmt.Sprintf("NewIdentity: could not generate key %s", err))
+	}
+	return NewIdentityFromKey(privateKey)
+}
+
+// Create a new Identity instance for an existing key.
+func NewIdentityFromKey(privateKey ed25519.PrivateKey) *Identity {
+	return &Identity{ID: NodeID(privateKey.Public().(ed25519.PublicKey)), PrivateKey: privateKey}
+}
+
+// Returns the ID of the node with publicKey.
+func NodeID(publicKey ed25519.PublicKey) *KademliaID {
+	return NewKademliaID(utils.Hash(publicKey))
+}
+
+// Returns a contact for the node with this identity at address.
+func (identity *Identity) Contact(address string) Contact {
+	return NewContact(identity.ID, address)
+}
+
+// Adds the public key and puzzle solution of the identity to message and signs it.
+func (identity *Identity) sign(message *protobuf.KademliaMessage) error {
+	message.PublicKey = identity.PrivateKey.Public().(ed25519.PublicKey)
+	message.PuzzleSolution = identity.PuzzleSolution
+	data, err := protobuf.SigningData(message)
+	if err != nil {
+		return err
+	}
+	message.Signature = ed25519.Sign(identity.PrivateKey, data)
+	return nil
+}
+
+// Checks that message is signed with the key its sender ID is derived from. The error wraps ErrInvalidSignature.
+func verifyMessage(message *protobuf.KademliaMessage) error {
+	if len(message.PublicKey) != ed25519.PublicKeySize || len(message.Signature) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: message is not signed", ErrInvalidSignature)
+	}
+	senderID, err := NewKademliaIDFromBytes(message.Sender.GetId())
+	if err != nil || !NodeID(message.PublicKey).Equals(senderID) {
+		return fmt.Errorf("%w: sender ID %x is not derived from the public key", ErrInvalidSignature, message.Sender.Id)
+	}
+
+	data, err := protobuf.SigningData(message)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+	if !ed25519.Verify(message.PublicKey, data, message.Signature) {
+		return fmt.Errorf("%w: signature does not match the message", ErrInvalidSignature)
+	}
+	return nil
+}
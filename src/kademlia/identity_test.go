@@ -0,0 +1,109 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/protobuf"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyMessage(t *testing.T) {
+	identity := NewIdentity()
+	other := NewIdentity()
+	me := identity.Contact("10.0.0.1:80")
+	newPing := func() *protobuf.KademliaMessage {
+		return &protobuf.KademliaMessage{Sender: me.Node(), RpcId: NewRandomKademliaID()[:], Body: &protobuf.KademliaMessage_Ping{Ping: &protobuf.Ping{}}}
+	}
+
+	signed := newPing()
+	identity.sign(signed)
+	if err := verifyMessage(signed); err != nil {
+		t.Errorf("Expected a signed message to be valid, but got %v", err)
+	}
+
+	tampered := newPing()
+	identity.sign(tampered)
+	tampered.Sender.Address = "10.0.0.2:80"
+
+	// A message signed with another key, but sent with the ID of identity
+	impersonated := newPing()
+	other.sign(impersonated)
+
+	tests := map[string]*protobuf.KademliaMessage{
+		"unsigned":     newPing(),
+		"tampered":     tampered,
+		"impersonated": impersonated,
+	}
+	for name, message := range tests {
+		if err := verifyMessage(message); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected the %s message to be invalid, but got %v", name, err)
+		}
+	}
+}
+
+func TestNewNetworkIdentity(t *testing.T) {
+	identity := NewIdentity()
+
+	// Test that a routing table of another node is refused, since none of its messages would verify
+	other := NewRoutingTable(NewIdentity().Contact("10.0.0.1:80"))
+	if _, err := NewNetwork(identity, other, 20, 3, time.Minute, time.Minute); err == nil {
+		t.Error("Expected NewNetwork() to refuse a routing table that belongs to another identity")
+	}
+	if _, err := NewNetwork(nil, NewRoutingTable(identity.Contact("10.0.0.1:80")), 20, 3, time.Minute, time.Minute); err == nil {
+		t.Error("Expected NewNetwork() to refuse a network without an identity")
+	}
+	if _, err := NewNetwork(identity, NewRoutingTable(identity.Contact("10.0.0.1:80")), 20, 3, time.Minute, time.Minute); err != nil {
+		t.Errorf("NewNetwork() returned an error: %v", err)
+	}
+}
+
+func TestUnsignedMessageDropped(t *testing.T) {
+	memory := NewMemoryNetwork()
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+	sender := memory.NewTransport()
+	if err := sender.Start("10.0.0.1:80", func(string, []byte) {}); err != nil {
+		t.Fatalf("Start() returned an error: %v", err)
+	}
+
+	// Test that a ping that is not signed is dropped before the sender reaches the routing table
+	contact := NewContact(NewRandomKademliaID(), "10.0.0.1:80")
+	data, _ := protobuf.SerializeMessage(&protobuf.KademliaMessage{Sender: contact.Node(), RpcId: NewRandomKademliaID()[:], Body: &protobuf.KademliaMessage_Ping{Ping: &protobuf.Ping{}}})
+	sender.Send(receiver.rt.me.Address, data)
+
+	deadline := time.Now().Add(time.Second)
+	for receiver.InvalidMessages() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if receiver.InvalidMessages() != 1 {
+		t.Errorf("Expected 1 invalid message, but got %d", receiver.InvalidMessages())
+	}
+	if receiver.rt.NumContacts() != 0 {
+		t.Error("The sender of an unsigned message should not be added to the routing table")
+	}
+}
+
+func TestJoinByAddress(t *testing.T) {
+	memory := NewMemoryNetwork()
+	bootstrap, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+
+	// Test that a node can join through an address without knowing the ID behind it
+	address := NewContact(nil, bootstrap.network.rt.me.Address)
+	if err := node.JoinNetwork(context.Background(), &address); err != nil {
+		t.Fatalf("JoinNetwork() returned an error: %v", err)
+	}
+	if contacts := node.network.rt.FindClosestContacts(bootstrap.network.rt.me.ID, 1); len(contacts) != 1 || !contacts[0].ID.Equals(bootstrap.network.rt.me.ID) {
+		t.Errorf("Expected the bootstrap node to be added with its ID, but got %v", contacts)
+	}
+}
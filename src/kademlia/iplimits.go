@@ -0,0 +1,149 @@
+package kademlia
+
+import (
+	"net"
+)
+
+// Prefix lengths of the subnets contacts are grouped by
+const (
+	ipv4SubnetBits = 24
+	ipv6SubnetBits = 48
+)
+
+// Number of rejected contact IDs remembered, so that a contact that keeps sending messages is counted once
+const maxRejectedIDs = 1024
+
+// IPLimits caps the number of contacts in the routing table that share an IP or a subnet, a /24 for
+// IPv4 and a /48 for IPv6, so that a single host or network cannot fill the buckets with its own nodes.
+// Zero means no limit.
+type IPLimits struct {
+	BucketIP     int // Contacts with the same IP in a bucket
+	BucketSubnet int // Contacts in the same subnet in a bucket
+	TableIP      int // Contacts with the same IP in the whole routing table
+	TableSubnet  int // Contacts in the same subnet in the whole routing table
+}
+
+// ipDiversity counts the contacts in a routing table per IP and subnet. It is shared by all buckets
+// of the table and guarded by the lock of the table.
+type ipDiversity struct {
+	limits     IPLimits
+	ips        map[string]int
+	subnets    map[string]int
+	rejections int             // Contacts that were not added because they would exceed a limit
+	rejected   map[string]bool // IDs of the contacts counted in rejections, cleared when it holds maxRejectedIDs
+}
+
+// newIPDiversity returns a new instance of an ipDiversity
+func newIPDiversity(limits IPLimits) *ipDiversity {
+	return &ipDiversity{limits: limits, ips: make(map[string]int), subnets: make(map[string]int), rejected: make(map[string]bool)}
+}
+
+// Returns the IP and subnet of the address of the contact, or false if the address has no IP.
+func contactNetwork(contact Contact) (string, string, bool) {
+	host, _, err := net.SplitHostPort(contact.Address)
+	if err != nil {
+		return "", "", false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", "", false
+	}
+
+	var subnet *net.IPNet
+	if ip4 := ip.To4(); ip4 != nil {
+		subnet = &net.IPNet{IP: ip4.Mask(net.CIDRMask(ipv4SubnetBits, 32)), Mask: net.CIDRMask(ipv4SubnetBits, 32)}
+	} else {
+		subnet = &net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6SubnetBits, 128)), Mask: net.CIDRMask(ipv6SubnetBits, 128)}
+	}
+	return ip.String(), subnet.String(), true
+}
+
+// Returns whether the contact can be added to bucket without exceeding a limit.
+func (diversity *ipDiversity) allows(bucket *bucket, contact Contact) bool {
+	ip, subnet, ok := contactNetwork(contact)
+	if !ok {
+		return true
+	}
+	limits := diversity.limits
+	if exceeds(diversity.ips[ip], limits.TableIP) || exceeds(diversity.subnets[subnet], limits.TableSubnet) {
+		return false
+	}
+	if limits.BucketIP == 0 && limits.BucketSubnet == 0 {
+		return true
+	}
+
+	sameIP, sameSubnet := 0, 0
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		otherIP, otherSubnet, ok := contactNetwork(e.Value.(Contact))
+		if !ok {
+			continue
+		}
+		if otherIP == ip {
+			sameIP++
+		}
+		if otherSubnet == subnet {
+			sameSubnet++
+		}
+	}
+	return !exceeds(sameIP, limits.BucketIP) && !exceeds(sameSubnet, limits.BucketSubnet)
+}
+
+// Returns whether one more than count would exceed limit, where a limit of zero is no limit.
+func exceeds(count int, limit int) bool {
+	return limit > 0 && count >= limit
+}
+
+// Counts a contact that was not added because it would exceed a limit, unless it was counted recently.
+// The remembered IDs are forgotten once there are maxRejectedIDs of them, so that a peer that keeps
+// presenting new IDs cannot grow them without bound.
+func (diversity *ipDiversity) reject(contact Contact) {
+	id := contact.ID.String()
+	if diversity.rejected[id] {
+		return
+	}
+	if len(diversity.rejected) >= maxRejectedIDs {
+		diversity.rejected = make(map[string]bool)
+	}
+	diversity.rejected[id] = true
+	diversity.rejections++
+}
+
+// Counts a contact that was added to a bucket.
+func (diversity *ipDiversity) add(contact Contact) {
+	if ip, subnet, ok := contactNetwork(contact); ok {
+		diversity.ips[ip]++
+		diversity.subnets[subnet]++
+	}
+}
+
+// Stops counting a contact that was removed from a bucket.
+func (diversity *ipDiversity) remove(contact Contact) {
+	ip, subnet, ok := contactNetwork(contact)
+	if !ok {
+		return
+	}
+	if diversity.ips[ip]--; diversity.ips[ip] <= 0 {
+		delete(diversity.ips, ip)
+	}
+	if diversity.subnets[subnet]--; diversity.subnets[subnet] <= 0 {
+		delete(diversity.subnets, subnet)
+	}
+}
+
+// SetIPLimits sets the limits on contacts sharing an IP or subnet. Contacts already in the
+// RoutingTable are kept, the limits only apply to contacts added afterwards.
+func (routingTable *RoutingTable) SetIPLimits(limits IPLimits) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+
+	routingTable.diversity.limits = limits
+}
+
+// NumIPRejections returns the number of contacts that were not added because too many contacts share
+// their IP or subnet. A contact that is rejected again while it is still remembered is not counted again.
+func (routingTable *RoutingTable) NumIPRejections() int {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+
+	return routingTable.diversity.rejections
+}
@@ -0,0 +1,129 @@
+package kademlia
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestContactNetwork(t *testing.T) {
+	tests := map[string][2]string{
+		"10.0.0.2:80":             {"10.0.0.2", "10.0.0.0/24"},
+		"[::ffff:10.0.0.2]:80":    {"10.0.0.2", "10.0.0.0/24"},
+		"[2001:db8:1:2::3]:80":    {"2001:db8:1:2::3", "2001:db8:1::/48"},
+		"[2001:db8:1:ffff::1]:80": {"2001:db8:1:ffff::1", "2001:db8:1::/48"},
+	}
+	for address, expected := range tests {
+		ip, subnet, ok := contactNetwork(NewContact(NewRandomKademliaID(), address))
+		if !ok || ip != expected[0] || subnet != expected[1] {
+			t.Errorf("Expected %s to be %s in %s, but got %s in %s", address, expected[0], expected[1], ip, subnet)
+		}
+	}
+
+	if _, _, ok := contactNetwork(NewContact(NewRandomKademliaID(), "node:80")); ok {
+		t.Error("Expected an address without an IP to have no network")
+	}
+}
+
+func TestIPLimits(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewRandomKademliaID(), "10.0.9.1:80"))
+	rt.SetIPLimits(IPLimits{BucketIP: 2, TableSubnet: 4})
+	add := func(bucketIndex int, ip string) Contact {
+		contact := NewContact(rt.RandomIDInBucket(bucketIndex), ip+":80")
+		rt.AddContact(contact)
+		return contact
+	}
+
+	// Test that a bucket takes no more contacts with the same IP than its limit
+	first := add(0, "10.0.0.2")
+	add(0, "10.0.0.2")
+	rejected := add(0, "10.0.0.2")
+
+	// A contact that keeps sending messages is only counted once
+	rt.AddContact(rejected)
+	if rt.NumContacts() != 2 || rt.NumIPRejections() != 1 {
+		t.Errorf("Expected 2 contacts and 1 rejection, but got %d and %d", rt.NumContacts(), rt.NumIPRejections())
+	}
+
+	// Test that the same IP is taken by another bucket, until the subnet reaches its limit in the table
+	add(1, "10.0.0.2")
+	add(2, "10.0.0.3")
+	add(3, "10.0.0.4")
+	add(3, "10.0.1.4")
+	if rt.NumContacts() != 5 || rt.NumIPRejections() != 2 {
+		t.Errorf("Expected 5 contacts and 2 rejections, but got %d and %d", rt.NumContacts(), rt.NumIPRejections())
+	}
+
+	// Test that a removed contact makes room in its subnet again
+	rt.RemoveContact(first)
+	add(3, "10.0.0.4")
+	if rt.NumContacts() != 5 || rt.NumIPRejections() != 2 {
+		t.Errorf("Expected 5 contacts and 2 rejections, but got %d and %d", rt.NumContacts(), rt.NumIPRejections())
+	}
+}
+
+func TestIPLimitsReplacement(t *testing.T) {
+	b := newBucket()
+	b.diversity.limits = IPLimits{BucketIP: 1}
+	for i := 0; i < bucketSize; i++ {
+		b.AddContact(NewContact(NewRandomKademliaID(), fmt.Sprintf("10.0.0.%d:80", i)))
+	}
+
+	// Two replacements share an IP that is not in the bucket yet, so both are cached
+	b.AddContact(NewContact(NewRandomKademliaID(), "10.0.1.1:80"))
+	b.AddContact(NewContact(NewRandomKademliaID(), "10.0.1.1:80"))
+	if b.replacements.Len() != 2 {
+		t.Fatalf("Expected 2 contacts in the replacement cache, got %d", b.replacements.Len())
+	}
+
+	// Test that only the first of them replaces an evicted contact
+	b.RemoveContact(b.list.Back().Value.(Contact))
+	b.RemoveContact(b.list.Back().Value.(Contact))
+	if b.Len() != bucketSize-1 || b.replacements.Len() != 1 {
+		t.Errorf("Expected %d contacts and 1 replacement left, got %d and %d", bucketSize-1, b.Len(), b.replacements.Len())
+	}
+}
+
+func TestIPLimitsObservedAddress(t *testing.T) {
+	memory := NewMemoryNetwork()
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+	receiver.rt.SetIPLimits(IPLimits{TableIP: 1})
+	honest, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+
+	// A node on the same host that claims another address in its signed messages
+	identity := NewIdentity()
+	rt := NewRoutingTable(identity.Contact("10.0.7.7:80"))
+	liar, err := NewNetworkWithTransport(memory.NewTransport(), identity, rt, 20, 3, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("NewNetwork() returned an error: %v", err)
+	}
+	if err := liar.Start("10.0.0.2", 81); err != nil {
+		t.Fatalf("Start() returned an error: %v", err)
+	}
+
+	// Test that the sender is added under the address its packets came from, so the claim is not trusted
+	ping := func(network *Network) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		rpcID := NewRandomKademliaID()
+		if err := network.SendPingMessage(ctx, &receiver.rt.me, rpcID); err != nil {
+			t.Fatalf("SendPingMessage() returned an error: %v", err)
+		}
+		if _, err := network.ListenForResponse(ctx, rpcID); err != nil {
+			t.Fatalf("ListenForResponse() returned an error: %v", err)
+		}
+	}
+	ping(honest.network)
+	ping(liar)
+	if receiver.rt.NumContacts() != 1 || receiver.rt.NumIPRejections() != 1 {
+		t.Errorf("Expected 1 contact and 1 rejection, but got %d and %d", receiver.rt.NumContacts(), receiver.rt.NumIPRejections())
+	}
+}
@@ -1,382 +1,409 @@
 package kademlia
 
 import (
+	"context"
+	"crypto/ed25519"
+	"d7024e/erasure"
 	"d7024e/utils"
-	"strings"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 )
 
-var shortListMutex = &sync.RWMutex{}
-var respondedNodesMutex = &sync.RWMutex{}
+const (
+	rpcTimeout           = 10 * time.Second // Time to wait for a contact to respond to a lookup or ping
+	storeResponseTimeout = 5 * time.Second  // Time to wait for a contact to confirm a store
+)
+
+const (
+	defaultBucketRefreshInterval = time.Hour // Default time a bucket can go without a lookup before it is refreshed
+	defaultReplicateInterval     = time.Hour // Default time between republishes of the values a node stores
+)
+
+// Returned by Store when fewer than MinReplicas contacts confirmed storing the data
+var ErrInsufficientReplicas = errors.New("too few contacts stored the data")
 
 type Kademlia struct {
 	network       *Network
 	DataStore     map[string]string
-	ClosestPeers  map[string][]Contact
 	RefreshTicker *time.Ticker
+	MinReplicas   int
+
+	// Time a bucket can go without a lookup before it is refreshed
+	BucketRefreshInterval time.Duration
+	// Time between republishes of the values stored on this node
+	ReplicateInterval time.Duration
+	// Number of contacts each shard of an erasure coded object is stored on
+	ShardReplicas int
+	// Key the mutable records published by this node are signed with, generated by the first publish if not set
+	RecordKey ed25519.PrivateKey
+	// Number of disjoint paths every lookup takes, one for a plain Kademlia lookup
+	DisjointPaths int
+
+	mu        sync.Mutex                 // Guards published, sequence and a generated RecordKey
+	published map[string]*publishedValue // Values this node is the original publisher of
+	stats     *nodeStats
+	encoder   *erasure.Encoder // Erasure codes objects if set, see EnableErasureCoding
+	sequence  uint64           // Sequence number of the last mutable record published
+	repairs   chan struct{}    // Slots of the mutable record repairs running, see repairRecords
 }
 
 // Create a new Kademlia instance.
 func NewKademlia(network *Network) *Kademlia {
-	return &Kademlia{network, make(map[string]string), make(map[string][]Contact), time.NewTicker(network.refreshInterval)}
+	return &Kademlia{
+		network:               network,
+		DataStore:             make(map[string]string),
+		RefreshTicker:         time.NewTicker(republishCheckPeriod(network.refreshInterval)),
+		MinReplicas:           1,
+		BucketRefreshInterval: defaultBucketRefreshInterval,
+		ReplicateInterval:     defaultReplicateInterval,
+		ShardReplicas:         defaultShardReplicas,
+		DisjointPaths:         1,
+		published:             make(map[string]*publishedValue),
+		stats:                 &nodeStats{},
+		repairs:               make(chan struct{}, maxConcurrentRepairs),
+	}
 }
 
-// Join the network by pinging the contact node and then performing a node lookup.
-func (kademlia *Kademlia) JoinNetwork(contact *Contact) {
+// Join the network by pinging the contact node, performing a node lookup for our own ID and then
+// refreshing the buckets further away than our closest neighbor. Returns an error if the contact
+// does not respond or ctx is done before the network has been joined.
+func (kademlia *Kademlia) JoinNetwork(ctx context.Context, contact *Contact) error {
 	rpcID := NewRandomKademliaID()
-	kademlia.network.SendPingMessage(contact, rpcID)
-	<-kademlia.network.CreateChannel(rpcID) // TODO: Listen with timeout
+
+	if err := kademlia.network.SendPingMessage(ctx, contact, rpcID); err != nil {
+		return fmt.Errorf("JoinNetwork: could not ping %s %w", contact.Address, err)
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	defer cancel()
+	if _, err := kademlia.network.ListenForResponse(pingCtx, rpcID); err != nil {
+		return fmt.Errorf("JoinNetwork: no response from %s %w", contact.Address, err)
+	}
 
 	utils.Log(1, "My routing table before node lookup:")
 	for _, contact := range kademlia.network.rt.FindClosestContacts(kademlia.network.rt.me.ID, kademlia.network.k) {
 		utils.Log(1, "%v, %v", contact.Address, contact.ID)
 	}
 
-	kademlia.LookupContact(kademlia.network.rt.me.ID)
+	if _, err := kademlia.LookupContact(ctx, kademlia.network.rt.me.ID); err != nil {
+		return fmt.Errorf("JoinNetwork: %w", err)
+	}
+	kademlia.refreshBucketsAfterJoin(ctx)
 
 	utils.Log(1, "My routing table after node lookup:")
 	for _, contact := range kademlia.network.rt.FindClosestContacts(kademlia.network.rt.me.ID, kademlia.network.k) {
 		utils.Log(1, "%v, %v", contact.Address, contact.ID)
 	}
-}
-
-// Lookup a contact by performing a node lookup.
-func (kademlia *Kademlia) LookupContact(target *KademliaID) {
-	utils.Log(1, "Looking up contact %v", target)
-
-	closestContacts, _ := kademlia.nodeLookup(target, FIND_NODE)
 
-	utils.Log(1, "Closest contacts found to %v after node lookup:", target)
-	for _, contact := range closestContacts {
-		utils.Log(1, "%v, %v", contact.Address, contact.ID)
+	if ctx.Err() != nil {
+		return fmt.Errorf("JoinNetwork: %w", ctx.Err())
 	}
+	return nil
 }
 
-// Lookup data on the network by performing a node lookup. Returns the data.
-func (kademlia *Kademlia) LookupData(hash string) []byte {
-	utils.Log(1, "Looking up data for hash %v", hash)
-
-	closestContactsWithoutValue, dataResult := kademlia.nodeLookup(NewKademliaID(hash), FIND_VALUE)
-
-	if dataResult != nil && len(closestContactsWithoutValue) > 0 {
-		utils.Log(1, "Closest contacts found to %v after data lookup that didn't return value:", hash)
-		for _, contact := range closestContactsWithoutValue {
-			utils.Log(1, "%v, %v", contact.Address, contact.ID)
-		}
-
-		// Store data on closest contact that didn't return the value (cache it)
-		utils.Log(1, "Storing data %v on closest contact that didn't return the value", dataResult)
-		utils.Log(1, "%v, %v", closestContactsWithoutValue[0].Address, closestContactsWithoutValue[0].ID)
-		kademlia.network.SendStoreMessage(NewKademliaID(hash), dataResult, &closestContactsWithoutValue[0], NewRandomKademliaID())
+// Refresh all buckets further away than the closest neighbor by looking up a random ID in each of them.
+func (kademlia *Kademlia) refreshBucketsAfterJoin(ctx context.Context) {
+	rt := kademlia.network.rt
+	closest := rt.FindClosestContacts(rt.me.ID, 1)
+	if len(closest) == 0 {
+		return
 	}
 
-	return dataResult
+	var refreshed sync.WaitGroup
+	for bucketIndex := 0; bucketIndex < rt.getBucketIndex(closest[0].ID); bucketIndex++ {
+		refreshed.Add(1)
+		go func(bucketIndex int) {
+			defer refreshed.Done()
+			kademlia.LookupContact(ctx, rt.RandomIDInBucket(bucketIndex))
+		}(bucketIndex)
+	}
+	refreshed.Wait()
 }
 
-// Store data on the network by performing a node lookup and then storing the data on the closest contacts. Returns the hash of the data.
-func (kademlia *Kademlia) Store(data []byte) string {
-	utils.Log(1, "Storing data %v", data)
-
-	hash := utils.Hash(data)
-	key := NewKademliaID(hash)
-	closestContacts, _ := kademlia.nodeLookup(key, STORE)
-
-	// Store data on closest contacts
-	utils.Log(1, "Closest contacts found to %v to store data at:", key)
-	for _, contact := range closestContacts {
-		kademlia.network.SendStoreMessage(key, data, &contact, NewRandomKademliaID())
-		utils.Log(1, "%v, %v", contact.Address, contact.ID)
+// Start the bucket refresh routine, which looks up a random ID in every bucket that has not had a lookup
+// for longer than BucketRefreshInterval. Buckets are checked four times per interval. The routine stops
+// when ctx is done, and does not start if BucketRefreshInterval is too short to check it that often.
+func (kademlia *Kademlia) StartBucketRefreshRoutine(ctx context.Context) {
+	period := kademlia.BucketRefreshInterval / 4
+	if period <= 0 {
+		utils.LogError("StartBucketRefreshRoutine: bucket refresh interval %s is too short, buckets are not refreshed", kademlia.BucketRefreshInterval)
+		return
 	}
 
-	// Save closestContacts for this hash
-	kademlia.ClosestPeers[key.String()] = closestContacts
-
-	return hash
-}
-
-// Start refresh routine for refreshing the closest peers to stored values
-func (kademlia *Kademlia) StartRefreshRoutine() {
 	go func() {
-		for range kademlia.RefreshTicker.C {
-			kademlia.refreshClosestPeers()
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				kademlia.refreshIdleBuckets(ctx)
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 }
 
-// Refresh the closest peers to stored values.
-func (kademlia *Kademlia) refreshClosestPeers() {
-	for hash, contacts := range kademlia.ClosestPeers {
-		for _, contact := range contacts {
-			go func(hash string, contact Contact) {
-				// Use a goroutine to prevent blocking the loop
-				// Implement SendRefreshMessage asynchronously
-				kademlia.network.SendRefreshMessage(NewKademliaID(hash), &contact, NewRandomKademliaID())
-			}(hash, contact)
-		}
-	}
-}
-
-func (kademlia *Kademlia) Forget(hash string) {
-	key := NewKademliaID(hash)
-
-	if _, ok := kademlia.ClosestPeers[key.String()]; !ok {
-		utils.Log(1, "No closest peers found for hash %s", hash)
+// Refresh every bucket that has not had a lookup for longer than BucketRefreshInterval.
+func (kademlia *Kademlia) refreshIdleBuckets(ctx context.Context) {
+	rt := kademlia.network.rt
+	idle := rt.IdleBuckets(kademlia.BucketRefreshInterval)
+	if len(idle) == 0 {
 		return
 	}
 
-	utils.Log(1, "Forgetting hash %s", hash)
-	delete(kademlia.ClosestPeers, key.String())
+	utils.Log(1, "Refreshing %d buckets idle for longer than %s", len(idle), kademlia.BucketRefreshInterval)
+	var refreshed sync.WaitGroup
+	for _, bucketIndex := range idle {
+		refreshed.Add(1)
+		go func(bucketIndex int) {
+			defer refreshed.Done()
+
+			_, err := kademlia.LookupContact(ctx, rt.RandomIDInBucket(bucketIndex))
+			if err != nil {
+				utils.LogError("refreshIdleBuckets: bucket %d %s", bucketIndex, err)
+				return
+			}
+			utils.Log(1, "Refreshed bucket %d", bucketIndex)
+			kademlia.stats.bucketRefreshed()
+		}(bucketIndex)
+	}
+	refreshed.Wait()
 }
 
-// Perform a node lookup on the network.
-func (kademlia *Kademlia) nodeLookup(target *KademliaID, opType string) ([]Contact, []byte) {
-
-	var data []byte
-	var closerFound chan bool
-	dataFound := make(chan []byte, kademlia.network.alpha)
+// Lookup a contact by performing a node lookup. Returns the closest contacts found.
+func (kademlia *Kademlia) LookupContact(ctx context.Context, target *KademliaID) ([]Contact, error) {
+	utils.Log(1, "Looking up contact %v", target)
 
-	// Pick the alpha closest nodes to the target ID from the buckets and add to shortList.
-	shortList := ContactCandidates{kademlia.network.rt.FindClosestContacts(target, kademlia.network.alpha)}
+	closestContacts, _, err := kademlia.nodeLookup(ctx, target, FIND_NODE)
+	if err != nil {
+		return nil, err
+	}
 
-	utils.Log(1, "Shortlist at start of nodeLookup:")
-	for _, contact := range shortList.contacts {
+	utils.Log(1, "Closest contacts found to %v after node lookup:", target)
+	for _, contact := range closestContacts {
 		utils.Log(1, "%v, %v", contact.Address, contact.ID)
 	}
 
-	// Create a list of nodes that have already been contacted.
-	contactedNodes := ContactCandidates{make([]Contact, 0)}
-
-	// Create a list of nodes that have responded but not returned a value.
-	respondedNodesWithoutValue := ContactCandidates{make([]Contact, 0)}
-
-OUTER_LOOP:
-	for {
-		var iterativeSync sync.WaitGroup
+	return closestContacts, nil
+}
 
-		closerFound = make(chan bool, kademlia.network.alpha)
+// Lookup data on the network by performing a node lookup. Returns the data, or nil if it was not found.
+// With erasure coding the shards of the data are looked up first and the data is rebuilt from them.
+// Values are only accepted once they have been validated against the key, so with DisjointPaths the
+// lookup succeeds only if a verified value arrives on one of the paths, however the others are steered.
+func (kademlia *Kademlia) LookupData(ctx context.Context, hash string) ([]byte, error) {
+	utils.Log(1, "Looking up data for hash %v", hash)
 
-		// Filter out nodes that are already in contactedNodes
-		alphaNodes := ContactCandidates{make([]Contact, 0)}
-		allNodesContacted := true
-		shortListMutex.RLock()
-		for _, node := range shortList.contacts {
-			if !Contains(contactedNodes.contacts, node) && !node.ID.Equals(kademlia.network.rt.me.ID) {
-				alphaNodes.Append([]Contact{node})
-				allNodesContacted = false
-			}
+	if kademlia.encoder != nil {
+		data, err := kademlia.lookupErasureCoded(ctx, hash)
+		if err != nil || data != nil {
+			return data, err
 		}
-		shortListMutex.RUnlock()
+		// Data stored before erasure coding was turned on is still stored whole
+	}
 
-		// Terminate when all nodes have been contacted.
-		if allNodesContacted {
-			break
-		}
+	closestContactsWithoutValue, dataResult, err := kademlia.nodeLookup(ctx, NewKademliaID(hash), FIND_VALUE)
+	if err != nil {
+		return nil, err
+	}
 
-		// Limit the number of nodes in alphaNodes to alpha
-		if alphaNodes.Len() > kademlia.network.alpha {
-			// Sort inorder to prioritize messaging closer nodes
-			alphaNodes.Sort()
-			alphaNodes.contacts = alphaNodes.contacts[:kademlia.network.alpha]
+	if dataResult != nil && len(closestContactsWithoutValue) > 0 {
+		utils.Log(1, "Closest contacts found to %v after data lookup that didn't return value:", hash)
+		for _, contact := range closestContactsWithoutValue {
+			utils.Log(1, "%v, %v", contact.Address, contact.ID)
 		}
 
-		// Send RPCs to alphaNodes
-		for _, node := range alphaNodes.contacts {
-			rpcID := NewRandomKademliaID()
-
-			if opType == FIND_NODE || opType == STORE {
-				kademlia.network.SendFindContactMessage(target, &node, rpcID)
-			} else {
-				kademlia.network.SendFindDataMessage(target, &node, rpcID)
+		// Store data on closest contact that didn't return the value (cache it)
+		cacheContact := closestContactsWithoutValue[0]
+		ttl := kademlia.cacheTTL(NewKademliaID(hash), cacheContact)
+		utils.Log(1, "Caching %d bytes for %s on closest contact that didn't return the value", len(dataResult), ttl)
+		utils.Log(1, "%v, %v", cacheContact.Address, cacheContact.ID)
+
+		// The copy is sent in the background with its own timeout, so the value is returned without waiting
+		// for a large copy to be acknowledged and a caller close to its deadline does not cut the copy off
+		go func() {
+			cacheCtx, cancel := context.WithTimeout(context.Background(), storeResponseTimeout)
+			defer cancel()
+			err := kademlia.network.SendCacheMessage(cacheCtx, NewKademliaID(hash), dataResult, ttl, &cacheContact, NewRandomKademliaID())
+			if err != nil {
+				utils.LogError("LookupData: could not cache data %s", err)
 			}
+		}()
+	}
 
-			go kademlia.waitForResponse(&iterativeSync, closerFound, dataFound, rpcID, &shortList, &respondedNodesWithoutValue, &node)
-		}
-		contactedNodes.Append(alphaNodes.contacts)
-
-		// Loose parallelism
-		time.Sleep(500 * time.Millisecond)
-
-		// Wait for a response from the alpha closest nodes in state.
-		data = waitForFastest(&iterativeSync, dataFound)
-		if data != nil {
-			utils.Log(1, "Recieved FIND_VALUE_RESPONSE from node, Im done searching")
-			respondedNodesMutex.Lock()
-			respondedNodesWithoutValue.Sort()
-			shortListMutex.Lock()
-			shortList = ContactCandidates{respondedNodesWithoutValue.contacts}
-			shortListMutex.Unlock()
-			respondedNodesMutex.Unlock()
-			break OUTER_LOOP
-		}
+	return dataResult, nil
+}
 
-		// If a closer node was found, set a flag that tells us to keep iterating
-		closerFoundFlag := false
-		for value := range closerFound {
-			if value {
-				closerFoundFlag = true
-				break
-			}
+// Returns the TTL of a copy of the value with key cached at contact. The TTL is halved for every known
+// contact that is closer to key than contact, so copies cached far from the key expire quickly.
+func (kademlia *Kademlia) cacheTTL(key *KademliaID, contact Contact) time.Duration {
+	contact.CalcDistance(key)
+	closer := 0
+	for _, known := range kademlia.network.rt.FindClosestContacts(key, kademlia.network.k) {
+		if known.Less(&contact) {
+			closer++
 		}
+	}
 
-		// Otherwise, send a find node message to the k closest nodes in state that have not been contacted yet.
-		if !closerFoundFlag {
-			var iterativeSync sync.WaitGroup
-			closerFound = make(chan bool, kademlia.network.k)
-			for _, node := range shortList.contacts {
-				rpcID := NewRandomKademliaID()
-
-				if !Contains(contactedNodes.contacts, node) {
-					if opType == FIND_NODE || opType == STORE {
-						kademlia.network.SendFindContactMessage(target, &node, rpcID)
-					} else {
-						kademlia.network.SendFindDataMessage(target, &node, rpcID)
-					}
-					contactedNodes.Append([]Contact{node})
-					go kademlia.waitForResponse(&iterativeSync, closerFound, dataFound, rpcID, &shortList, &respondedNodesWithoutValue, &node)
-				}
+	// Never shorter than a millisecond, since a TTL of zero asks for the default TTL
+	ttl := kademlia.network.ttl >> closer
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	return ttl
+}
 
-			}
+// Store data on the network by performing a node lookup and then storing the data on the closest contacts.
+// Returns the hash of the data and the number of contacts that confirmed storing it. With erasure coding
+// the data is stored as shards instead, and the number of shards that were stored is returned.
+func (kademlia *Kademlia) Store(ctx context.Context, data []byte) (string, int, error) {
+	if kademlia.encoder != nil {
+		return kademlia.storeErasureCoded(ctx, data)
+	}
+	utils.Log(1, "Storing %d bytes", len(data))
 
-			// Wait for a response from the k closest nodes in state.
-			data = waitForFastest(&iterativeSync, dataFound)
-			if data != nil {
-				utils.Log(1, "Recieved FIND_VALUE_RESPONSE from node, Im done searching")
-				respondedNodesMutex.Lock()
-				respondedNodesWithoutValue.Sort()
-				shortListMutex.Lock()
-				shortList = ContactCandidates{respondedNodesWithoutValue.contacts}
-				shortListMutex.Unlock()
-				respondedNodesMutex.Unlock()
-				break OUTER_LOOP
-			}
-		}
+	hash := utils.Hash(data)
+	key := NewKademliaID(hash)
+	replicas, err := kademlia.storeOnClosest(ctx, key, CONTENT_RECORD, data, 0)
+	if err != nil {
+		return hash, 0, fmt.Errorf("Store: %w", err)
 	}
 
-	return shortList.contacts, data
-}
+	// Keep the data to republish it
+	kademlia.mu.Lock()
+	kademlia.published[key.String()] = &publishedValue{data, CONTENT_RECORD, time.Now()}
+	kademlia.mu.Unlock()
 
-// Wait for a response from a node. If no response is received within 10 seconds, remove the node from the short list.
-func (kademlia *Kademlia) waitForResponse(iterWait *sync.WaitGroup, status chan bool, data chan []byte, rpcID *KademliaID, shortList *ContactCandidates, respondedNodesWithoutValue *ContactCandidates, node *Contact) {
+	if ctx.Err() != nil {
+		return hash, len(replicas), fmt.Errorf("Store: %w", ctx.Err())
+	}
+	if len(replicas) < kademlia.MinReplicas {
+		return hash, len(replicas), fmt.Errorf("Store: %w (%d of %d required)", ErrInsufficientReplicas, len(replicas), kademlia.MinReplicas)
+	}
 
-	iterWait.Add(1)
-	// Wait for 10 sec if no response remove node from short list
-	response, err := kademlia.network.ListenWithTimeout(rpcID, 10)
+	return hash, len(replicas), nil
+}
+
+// Performs a node lookup for key and stores a record of kind with data on the closest contacts for ttl, or
+// their default TTL if ttl is zero. Returns the contacts that confirmed storing the data.
+func (kademlia *Kademlia) storeOnClosest(ctx context.Context, key *KademliaID, kind RecordKind, data []byte, ttl time.Duration) ([]Contact, error) {
+	closestContacts, _, err := kademlia.nodeLookup(ctx, key, STORE)
 	if err != nil {
-		shortListMutex.Lock()
-		shortList.RemoveContact(node)
-		shortListMutex.Unlock()
+		return nil, err
+	}
 
-		status <- false
-		iterWait.Done()
-		return
+	// Shards are stored on fewer contacts, since the other shards already make up for lost ones
+	if kind == SHARD_RECORD && len(closestContacts) > kademlia.ShardReplicas {
+		candidates := ContactCandidates{closestContacts}
+		candidates.Sort()
+		closestContacts = candidates.GetContacts(kademlia.ShardReplicas)
 	}
 
-	// If response contains stored data, terminate and return it to the caller
-	if response["type"] == FIND_VALUE_RESPONSE {
-		utils.Log(1, "waitForResponse: got value from node: %s", node.Address)
-		data <- []byte(response["data"])
-		iterWait.Done()
-		return
+	// Store data on closest contacts and wait for them to confirm
+	utils.Log(1, "Closest contacts found to %v to store data at:", key)
+	confirmed := make(chan Contact, len(closestContacts))
+	var replies sync.WaitGroup
+	for _, contact := range closestContacts {
+		utils.Log(1, "%v, %v", contact.Address, contact.ID)
+
+		replies.Add(1)
+		go func(contact Contact) {
+			defer replies.Done()
+			if kademlia.storeAt(ctx, key, kind, data, ttl, &contact) {
+				confirmed <- contact
+			}
+		}(contact)
 	}
+	replies.Wait()
+	close(confirmed)
 
-	// Node responded and it wasn't a FIND_VALUE_RESPONSE, add it to respondedNodesWithoutValue
-	respondedNodesMutex.Lock()
-	if !Contains(respondedNodesWithoutValue.contacts, *node) && response["type"] != FIND_VALUE_RESPONSE {
-		respondedNodesWithoutValue.Append([]Contact{*node})
+	replicas := []Contact{}
+	for contact := range confirmed {
+		replicas = append(replicas, contact)
 	}
-	respondedNodesMutex.Unlock()
 
-	// Extract nodes from message
-	responeContacts := []Contact{}
-	strs := strings.Split(response["data"], "\n")
-	conStrs := strs[:len(strs)-1] // remove last element (not a contact)
+	utils.Log(1, "Data with key %s was stored on %d of %d contacts", key.String(), len(replicas), len(closestContacts))
+	return replicas, nil
+}
+
+// Sends a store message to contact and waits for the response. Returns true if contact stored the data.
+func (kademlia *Kademlia) storeAt(ctx context.Context, key *KademliaID, kind RecordKind, data []byte, ttl time.Duration, contact *Contact) bool {
+	rpcID := NewRandomKademliaID()
 
-	for _, str := range conStrs {
-		contact, err := NewContactFromString(str)
-		if err != nil {
-			utils.LogError("nodeLookup: could not translate string to contact %s", err)
-			continue
-		}
-		if contact.ID.Equals(kademlia.network.rt.me.ID) {
-			utils.Log(1, "nodeLookup: contact %s is me, discard", contact.Address)
-			continue
-		}
-		responeContacts = append(responeContacts, contact)
+	if err := kademlia.network.SendStoreMessage(ctx, key, kind, data, ttl, contact, rpcID); err != nil {
+		utils.LogError("Store: %s", err)
+		return false
 	}
 
-	// Update shortList
-	shortListMutex.Lock()
-	nodesReplaced := updateShortList(shortList, responeContacts, kademlia.network.k)
-	shortListMutex.Unlock()
-
-	// If at least one node was replaced, send a new find node message to the alpha closest nodes in state.
-	if nodesReplaced {
-		utils.Log(1, "At least one node was replaced, looping again")
+	responseCtx, cancel := context.WithTimeout(ctx, storeResponseTimeout)
+	defer cancel()
+	response, err := kademlia.network.ListenForResponse(responseCtx, rpcID)
+	if err != nil {
+		utils.Log(2, "Store: no response from %s", contact.Address)
+		return false
+	}
 
-		status <- false
-		iterWait.Done()
-		return
+	storeResponse := response.GetStoreResponse()
+	if storeResponse == nil {
+		utils.LogError("Store: unexpected %s response from %s", messageType(response), contact.Address)
+		return false
+	}
+	if !storeResponse.Success {
+		utils.Log(2, "Store: %s rejected data with key %s: %s", contact.Address, key.String(), storeResponse.Reason)
+		return false
 	}
 
-	status <- true
-	iterWait.Done()
+	return true
 }
 
-// Update the short list with new contacts.
-func updateShortList(shortList *ContactCandidates, newContacts []Contact, k int) bool {
-	// Filter out nodes that are already in shortList
-	nodesReplaced := false
-	for _, newNode := range newContacts {
-		if Contains(shortList.contacts, newNode) {
-			continue
-		}
-
-		// If shortList contains less than k nodes, nodes are freely inserted into the state until it contains k nodes.
-		if shortList.Len() < k {
-			shortList.Append([]Contact{newNode})
-		} else {
-			// shortList already contains k elements, replace the node furthest away with the new node if it is closer than the closest node in shortList
-			shortList.Sort()
-			if newNode.Less(&shortList.contacts[0]) {
-				shortList.contacts[len(shortList.contacts)-1] = newNode
-				nodesReplaced = true
+// Start the republish routine. Values this node published are republished every refreshInterval and
+// values stored on this node are republished every ReplicateInterval. The routine stops when ctx is done.
+func (kademlia *Kademlia) StartRefreshRoutine(ctx context.Context) {
+	go func() {
+		replicateTicker := time.NewTicker(republishCheckPeriod(kademlia.ReplicateInterval))
+		defer replicateTicker.Stop()
+
+		for {
+			select {
+			case <-kademlia.RefreshTicker.C:
+				kademlia.republishOriginals(ctx)
+			case <-replicateTicker.C:
+				kademlia.republishReplicas(ctx)
+			case <-ctx.Done():
+				return
 			}
 		}
-	}
-	return nodesReplaced
+	}()
 }
 
-// Wait for the fastest response from a node.
-func waitForFastest(wg *sync.WaitGroup, ch chan []byte) (data []byte) {
-	done := make(chan struct{})
-	var lock = &sync.Mutex{}
-	closed := false
-
-	go func(group *sync.WaitGroup) {
-		group.Wait()
-		lock.Lock()
-		if closed {
-			lock.Unlock()
-			return
+// Stop republishing data this node published. The data expires from the network when its TTL runs out.
+func (kademlia *Kademlia) Forget(hash string) {
+	key := NewKademliaID(hash)
+
+	keys := []string{key.String()}
+	if kademlia.encoder != nil {
+		for index := 0; index < kademlia.encoder.Total(); index++ {
+			keys = append(keys, ShardKey(key.String(), index).String())
 		}
-		close(done)
-		closed = true
-		lock.Unlock()
-	}(wg)
-
-	go func(channel chan []byte) {
-		data = <-channel
-		lock.Lock()
-		if closed {
-			lock.Unlock()
-			return
+	}
+
+	kademlia.mu.Lock()
+	defer kademlia.mu.Unlock()
+	forgotten := false
+	for _, key := range keys {
+		if _, ok := kademlia.published[key]; ok {
+			delete(kademlia.published, key)
+			forgotten = true
 		}
-		close(done)
-		closed = true
-		lock.Unlock()
-	}(ch)
+	}
 
-	<-done
-	return data
+	if !forgotten {
+		utils.Log(1, "No published value found for hash %s", hash)
+		return
+	}
+	utils.Log(1, "Forgetting hash %s", hash)
 }
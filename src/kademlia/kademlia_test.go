@@ -1,14 +1,20 @@
 package kademlia
 
 import (
-	"fmt"
-	"sync"
+	"context"
+	"d7024e/utils"
+	"errors"
+	"sort"
 	"testing"
 	"time"
 )
 
 func TestNewKademlia(t *testing.T) {
-	network := NewNetwork(NewRoutingTable(NewContact(NewRandomKademliaID(), "172.20.0.10")), 20, 3, time.Second*60, time.Second*30)
+	identity := NewIdentity()
+	network, err := NewNetwork(identity, NewRoutingTable(identity.Contact("172.20.0.10")), 20, 3, time.Second*60, time.Second*30)
+	if err != nil {
+		t.Fatalf("NewNetwork() returned an error: %v", err)
+	}
 	kademlia := NewKademlia(network)
 	if kademlia == nil {
 		t.Fatal("NewKademlia returned nil")
@@ -23,59 +29,68 @@ func TestNewKademlia(t *testing.T) {
 
 func TestLookupContact(t *testing.T) {
 	// Create a Kademlia instance
-	me := NewContact(NewRandomKademliaID(), "172.20.0.10")
-	rt := NewRoutingTable(me)
-	net := NewNetwork(rt, 20, 3, time.Second*60, time.Second*30)
+	identity := NewIdentity()
+	rt := NewRoutingTable(identity.Contact("172.20.0.10"))
+	net, err := NewNetwork(identity, rt, 20, 3, time.Second*60, time.Second*30)
+	if err != nil {
+		t.Fatalf("NewNetwork() returned an error: %v", err)
+	}
 	kademlia := NewKademlia(net)
 
-	kademlia.LookupContact(NewRandomKademliaID())
+	kademlia.LookupContact(context.Background(), NewRandomKademliaID())
 }
 
 func TestLookupData(t *testing.T) {
 	// Create a Kademlia instance
-	me := NewContact(NewRandomKademliaID(), "172.20.0.10")
-	rt := NewRoutingTable(me)
-	net := NewNetwork(rt, 20, 3, time.Second*60, time.Second*30)
+	identity := NewIdentity()
+	rt := NewRoutingTable(identity.Contact("172.20.0.10"))
+	net, err := NewNetwork(identity, rt, 20, 3, time.Second*60, time.Second*30)
+	if err != nil {
+		t.Fatalf("NewNetwork() returned an error: %v", err)
+	}
 	kademlia := NewKademlia(net)
 
-	data := kademlia.LookupData("0123456789abcdef0123456789abcdef01234561")
-	if data != nil {
+	data, err := kademlia.LookupData(context.Background(), "0123456789abcdef0123456789abcdef01234561")
+	if data != nil || err != nil {
 		t.Error("LookupData() should return nil if the data does not exist")
 	}
 }
 
 func TestStore(t *testing.T) {
 	// Create a Kademlia instance
-	me := NewContact(NewRandomKademliaID(), "172.20.0.10")
-	rt := NewRoutingTable(me)
-	net := NewNetwork(rt, 20, 3, time.Second*60, time.Second*30)
+	identity := NewIdentity()
+	rt := NewRoutingTable(identity.Contact("172.20.0.10"))
+	net, err := NewNetwork(identity, rt, 20, 3, time.Second*60, time.Second*30)
+	if err != nil {
+		t.Fatalf("NewNetwork() returned an error: %v", err)
+	}
 	kademlia := NewKademlia(net)
 
-	hash := kademlia.Store([]byte("hello world"))
+	// No contacts are known so the data can not be replicated
+	hash, replicas, err := kademlia.Store(context.Background(), []byte("hello world"))
 	if hash == "" {
 		t.Error("Store() returned an empty hash")
 	}
+	if replicas != 0 || !errors.Is(err, ErrInsufficientReplicas) {
+		t.Errorf("Expected Store() to fail with 0 replicas, but got %d replicas and error %v", replicas, err)
+	}
 }
 
 func TestKademlia_Forget(t *testing.T) {
 	kad := &Kademlia{
-		ClosestPeers: make(map[string][]Contact),
+		published: make(map[string]*publishedValue),
 	}
 
-	// Add a sample hash to closest peers
+	// Add a sample hash to the published values
 	hash := "0000000000000000000000000000000000000000"
 	key := NewKademliaID(hash)
-	contacts := []Contact{
-		NewContact(NewRandomKademliaID(), "address1"),
-		NewContact(NewRandomKademliaID(), "address2"),
-	}
-	kad.ClosestPeers[key.String()] = contacts
+	kad.published[key.String()] = &publishedValue{[]byte("data"), CONTENT_RECORD, time.Now()}
 
 	// Call Forget method to remove the hash
 	kad.Forget(hash)
 
-	// Check if the hash is removed from closest peers
-	if _, ok := kad.ClosestPeers[key.String()]; ok {
+	// Check if the hash is removed from the published values
+	if _, ok := kad.published[key.String()]; ok {
 		t.Errorf("Expected hash %s to be forgotten, but it was not", hash)
 	}
 
@@ -87,108 +102,158 @@ func TestKademlia_Forget(t *testing.T) {
 	// The test passes if it reaches this point without panicking
 }
 
-func TestUpdateShortList(t *testing.T) {
-	target := NewKademliaID("0000000000000000000000000000000000000000")
-	id1 := NewKademliaID("1111111111111111111111111111111111111111")
-	id2 := NewKademliaID("2222222222222222222222222222222222222222")
-	id3 := NewKademliaID("3333333333333333333333333333333333333333")
-	id4 := NewKademliaID("4444444444444444444444444444444444444444")
+func TestJoinNetworkUnreachable(t *testing.T) {
+	memory := NewMemoryNetwork()
+	node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	nobody := NewContact(NewRandomKademliaID(), "10.0.0.2:80")
+
+	// Test that joining through a contact that never responds stops at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = node.JoinNetwork(ctx, &nobody)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected JoinNetwork() to return %v, but got %v", context.DeadlineExceeded, err)
+	}
+}
 
-	// Initialize shortList and new contacts
-	shortList := &ContactCandidates{contacts: []Contact{
-		{ID: id1, Address: "192.168.0.1", distance: id1.CalcDistance(target)},
-		{ID: id2, Address: "192.168.0.2", distance: id2.CalcDistance(target)},
-	}}
-	newContacts := []Contact{
-		{ID: id3, Address: "192.168.0.3", distance: id3.CalcDistance(target)},
-		{ID: id4, Address: "192.168.0.4", distance: id4.CalcDistance(target)},
+func TestLookupCancelled(t *testing.T) {
+	memory := NewMemoryNetwork()
+	node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
 	}
+	node.network.rt.AddContact(NewContact(NewRandomKademliaID(), "10.0.0.2:80"))
 
-	// Call the function
-	nodesReplaced := updateShortList(shortList, newContacts, 3)
+	// Test that a lookup waiting on a contact that never responds stops when cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
 
-	// Print distances for debugging
-	fmt.Println("Distances after update:")
-	for _, contact := range shortList.contacts {
-		fmt.Printf("Node ID: %s, Distance: %s\n", contact.ID.String(), contact.distance.String())
+	start := time.Now()
+	_, err = node.LookupData(ctx, NewRandomKademliaID().String())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected LookupData() to return %v, but got %v", context.Canceled, err)
 	}
+	if time.Since(start) > time.Second {
+		t.Errorf("LookupData() took %v to stop after being cancelled", time.Since(start))
+	}
+}
 
-	// Assert the results
-	expectedContacts := []Contact{
-		{ID: id1, Address: "192.168.0.1", distance: id1.CalcDistance(target)},
-		{ID: id2, Address: "192.168.0.2", distance: id2.CalcDistance(target)},
-		{ID: id3, Address: "192.168.0.3", distance: id3.CalcDistance(target)},
+func TestRefreshIdleBuckets(t *testing.T) {
+	memory := NewMemoryNetwork()
+	bootstrap, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	if err := node.JoinNetwork(context.Background(), &bootstrap.network.rt.me); err != nil {
+		t.Fatalf("JoinNetwork() returned an error: %v", err)
 	}
 
-	if !compareContactArrays(shortList.contacts, expectedContacts) {
-		t.Errorf("Short list not updated as expected.")
+	// Test that every idle bucket gets a lookup and that the refreshes show up in the stats
+	node.BucketRefreshInterval = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	idle := node.network.rt.IdleBuckets(node.BucketRefreshInterval)
+	node.refreshIdleBuckets(context.Background())
+
+	stats := node.Stats()
+	if stats.BucketRefreshes != len(idle) || stats.LastBucketRefresh.IsZero() {
+		t.Errorf("Expected %d bucket refreshes in the stats, but got %d", len(idle), stats.BucketRefreshes)
+	}
+	if remaining := node.network.rt.IdleBuckets(time.Second); len(remaining) != 0 {
+		t.Errorf("Expected no idle buckets after the refresh, but got %v", remaining)
 	}
+}
 
-	if nodesReplaced {
-		t.Errorf("Nodes should just be added, and not replaced.")
+func TestBucketRefreshRoutineInterval(t *testing.T) {
+	memory := NewMemoryNetwork()
+	node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Test that an interval too short to be checked four times does not start the routine
+	for _, interval := range []time.Duration{0, 3, -time.Hour} {
+		node.BucketRefreshInterval = interval
+		node.StartBucketRefreshRoutine(ctx)
 	}
 }
 
-func compareContactArrays(a, b []Contact) bool {
-	if len(a) != len(b) {
-		return false
+func TestCacheTTL(t *testing.T) {
+	identity := NewIdentity()
+	net, err := NewNetwork(identity, NewRoutingTable(identity.Contact("10.0.0.1:80")), 20, 3, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("NewNetwork() returned an error: %v", err)
+	}
+	kademlia := NewKademlia(net)
+	key := NewKademliaID("0000000000000000000000000000000000000000")
+	kademlia.network.rt.AddContact(NewContact(NewKademliaID("0000000000000000000000000000000000000001"), "10.0.0.2:80"))
+	kademlia.network.rt.AddContact(NewContact(NewKademliaID("0000000000000000000000000000000000000002"), "10.0.0.3:80"))
+
+	// Test that the TTL is halved for every known contact closer to the key
+	tests := []struct {
+		id  string
+		ttl time.Duration
+	}{
+		{"0000000000000000000000000000000000000001", time.Hour},
+		{"0000000000000000000000000000000000000003", time.Hour / 4},
 	}
-	for i := range a {
-		if a[i].ID.String() != b[i].ID.String() || a[i].Address != b[i].Address {
-			return false
+	for _, test := range tests {
+		if ttl := kademlia.cacheTTL(key, NewContact(NewKademliaID(test.id), "")); ttl != test.ttl {
+			t.Errorf("Expected a cache TTL of %s at %s, but got %s", test.ttl, test.id, ttl)
 		}
 	}
-	return true
 }
 
-func TestWaitForFastest(t *testing.T) {
-	// Prepare test data
-	testData := []byte("test data")
-	channel := make(chan []byte, 1)
-	channel <- testData
-
-	// Use a WaitGroup to simulate asynchronous tasks
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// Synchronization channel to ensure proper closure
-	done := make(chan struct{}, 2)
+func TestLookupDataCaches(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodes, err := memory.NewCluster(5)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
 
-	// Test the function
-	go func() {
-		defer wg.Done()
-		receivedData := waitForFastest(&wg, channel)
+	// Only the node farthest from the key holds the value, so the closer nodes answer the lookup
+	// without it first and the lookup leaves a cached copy on one of them
+	data := []byte("cache me")
+	key := utils.Hash(data)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].network.rt.me.ID.CalcDistance(NewKademliaID(key)).Less(nodes[j].network.rt.me.ID.CalcDistance(NewKademliaID(key)))
+	})
+	holder, looker := nodes[3], nodes[4]
+	holder.network.storage.StoreData(key, data, time.Hour)
+	if _, err := looker.LookupData(context.Background(), key); err != nil {
+		t.Fatalf("LookupData() returned an error: %v", err)
+	}
 
-		// Assert that the received data matches the sent data
-		if string(receivedData) != string(testData) {
-			t.Errorf("Expected %s, but got %s", string(testData), string(receivedData))
+	cached := 0
+	for i := 0; i < 50 && cached == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		for _, node := range nodes {
+			if value, _, exist := node.network.storage.(*MemoryStorage).entry(key); exist && value.Cached {
+				cached++
+				if value.Expires.After(time.Now().Add(node.network.ttl)) {
+					t.Errorf("Expected the cached copy to expire within %s", node.network.ttl)
+				}
+			}
 		}
-
-		// Signal that the goroutine is done
-		done <- struct{}{}
-	}()
-
-	// Simulate another asynchronous task
-	go func() {
-		defer wg.Done()
-		// Simulate some time-consuming task
-		time.Sleep(100 * time.Millisecond)
-		channel <- []byte("slow data")
-
-		// Signal that the goroutine is done
-		done <- struct{}{}
-	}()
-
-	// Wait for both goroutines to finish
-	wg.Wait()
-
-	// Close the synchronization channel after all goroutines are done
-	close(done)
-
-	// Ensure that the channel is closed properly
-	for range done {
-		// Channel is properly closed
+	}
+	if cached != 1 {
+		t.Fatalf("Expected the value to be cached on 1 node, but it was cached on %d", cached)
 	}
 
-	// Ensure that all goroutines have completed before exiting the test case
+	// Test that cached copies are not republished
+	for _, node := range nodes {
+		for _, value := range node.network.storage.ValuesStoredBefore(0) {
+			if value.Key == key && node != holder {
+				t.Errorf("Expected the cached copy on %s not to be republished", node.network.rt.me.Address)
+			}
+		}
+	}
 }
@@ -1,8 +1,9 @@
 package kademlia
 
 import (
+	"crypto/rand"
 	"encoding/hex"
-	"math/rand"
+	"fmt"
 )
 
 // the static number of bytes in a KademliaID
@@ -23,13 +24,22 @@ func NewKademliaID(data string) *KademliaID {
 	return &newKademliaID
 }
 
-// NewRandomKademliaID returns a new instance of a random KademliaID,
-// change this to a better version if you like
+// NewKademliaIDFromBytes returns a new instance of a KademliaID based on the byte input
+func NewKademliaIDFromBytes(data []byte) (*KademliaID, error) {
+	if len(data) != IDLength {
+		return nil, fmt.Errorf("NewKademliaIDFromBytes: expected %d bytes but got %d", IDLength, len(data))
+	}
+
+	newKademliaID := KademliaID{}
+	copy(newKademliaID[:], data)
+	return &newKademliaID, nil
+}
+
+// NewRandomKademliaID returns a new instance of a random KademliaID. It is read from crypto/rand,
+// since rpc ids must be impossible to guess for responses to be matched to requests safely.
 func NewRandomKademliaID() *KademliaID {
 	newKademliaID := KademliaID{}
-	for i := 0; i < IDLength; i++ {
-		newKademliaID[i] = uint8(rand.Intn(256))
-	}
+	rand.Read(newKademliaID[:])
 	return &newKademliaID
 }
 
@@ -0,0 +1,280 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"fmt"
+)
+
+// The state of a single path of a node lookup. Each lookup owns its state and it is only touched by the
+// goroutine running the lookup, so concurrent lookups never wait on each other.
+type lookup struct {
+	kademlia  *Kademlia
+	target    *KademliaID
+	opType    string
+	path      int               // Index of the path among the disjoint paths of the lookup
+	claimed   map[string]int    // The path every contact was queried by, shared by all paths of the lookup
+	shortList ContactCandidates // The k closest contacts found so far
+	contacted map[string]bool   // Contacts that have been sent an RPC
+	failed    map[string]bool   // Contacts that did not respond
+	responded ContactCandidates // Contacts that responded without a value
+	inFlight  int               // RPCs sent that have not been answered or timed out yet
+}
+
+// The outcome of a single lookup RPC. message is nil if the contact did not respond.
+type lookupResponse struct {
+	path    int
+	contact Contact
+	message *protobuf.KademliaMessage
+}
+
+// newLookup returns a new lookup path for target seeded with contacts
+func newLookup(kademlia *Kademlia, target *KademliaID, opType string, path int, claimed map[string]int, contacts []Contact) *lookup {
+	return &lookup{
+		kademlia:  kademlia,
+		target:    target,
+		opType:    opType,
+		path:      path,
+		claimed:   claimed,
+		shortList: ContactCandidates{contacts},
+		contacted: make(map[string]bool),
+		failed:    make(map[string]bool),
+		responded: ContactCandidates{make([]Contact, 0)},
+	}
+}
+
+// Returns the paths of a lookup for target. The k closest contacts in the routing table are dealt out
+// over DisjointPaths paths, so that each path starts from different contacts.
+func newLookupPaths(kademlia *Kademlia, target *KademliaID, opType string) []*lookup {
+	contacts := kademlia.network.rt.FindClosestContacts(target, kademlia.network.k)
+	count := kademlia.DisjointPaths
+	if count < 1 {
+		count = 1
+	}
+
+	seeds := make([][]Contact, count)
+	for index, contact := range contacts {
+		seeds[index%count] = append(seeds[index%count], contact)
+	}
+	claimed := make(map[string]int)
+	paths := make([]*lookup, count)
+	for path := range paths {
+		paths[path] = newLookup(kademlia, target, opType, path, claimed, seeds[path])
+	}
+	return paths
+}
+
+// Perform a node lookup on the network. Keeps alpha RPCs in flight, sending a new one to the closest
+// contact not yet contacted as soon as a response arrives, until the k closest contacts found have all
+// been contacted. Returns the closest contacts, and the data if a FIND_VALUE lookup found it. Returns an
+// error if ctx is done before the lookup finishes.
+//
+// With DisjointPaths above one, the lookup runs that many paths side by side, each with its own short
+// list and alpha RPCs in flight. No contact is queried by more than one path, so nodes that lie about
+// their neighbours can only steer the paths they are on. The contacts of all paths are merged. A value
+// ends the lookup on any path, since it is only accepted after it has been validated against the key.
+func (kademlia *Kademlia) nodeLookup(ctx context.Context, target *KademliaID, opType string) ([]Contact, []byte, error) {
+	paths := newLookupPaths(kademlia, target, opType)
+	kademlia.network.rt.MarkLookup(target)
+
+	utils.Log(1, "Shortlist at start of nodeLookup:")
+	for _, lookup := range paths {
+		for _, contact := range lookup.shortList.contacts {
+			utils.Log(1, "%v, %v (path %d)", contact.Address, contact.ID, lookup.path)
+		}
+	}
+
+	// Never more than alpha RPCs per path are in flight, so their goroutines never block even after we return
+	responses := make(chan lookupResponse, kademlia.network.alpha*len(paths))
+
+	for {
+		inFlight := 0
+		for _, lookup := range paths {
+			for lookup.inFlight < kademlia.network.alpha {
+				contact, exist := lookup.nextContact()
+				if !exist {
+					break
+				}
+				lookup.query(ctx, contact, responses)
+			}
+			inFlight += lookup.inFlight
+		}
+
+		// Terminate when the k closest contacts of every path have all been contacted and answered
+		if inFlight == 0 {
+			closest := mergePaths(paths, func(lookup *lookup) []Contact { return lookup.shortList.contacts })
+			if closest.Len() > kademlia.network.k {
+				return closest.GetContacts(kademlia.network.k), nil, nil
+			}
+			return closest.contacts, nil, nil
+		}
+
+		select {
+		case response := <-responses:
+			// A query cut short because the caller gave up says nothing about the contact
+			if response.message == nil && ctx.Err() != nil {
+				return nil, nil, fmt.Errorf("nodeLookup: %w", ctx.Err())
+			}
+			path := paths[response.path]
+			path.inFlight--
+			if data := path.handleResponse(response); data != nil {
+				utils.Log(1, "Recieved FIND_VALUE_RESPONSE from %s, Im done searching", response.contact.Address)
+				return mergePaths(paths, func(lookup *lookup) []Contact { return lookup.responded.contacts }).contacts, data, nil
+			}
+
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("nodeLookup: %w", ctx.Err())
+		}
+	}
+}
+
+// Returns the contacts of all paths that contacts picks, closest first.
+func mergePaths(paths []*lookup, contacts func(lookup *lookup) []Contact) ContactCandidates {
+	merged := ContactCandidates{make([]Contact, 0)}
+	for _, lookup := range paths {
+		for _, contact := range contacts(lookup) {
+			if !Contains(merged.contacts, contact) {
+				merged.Append([]Contact{contact})
+			}
+		}
+	}
+	merged.Sort()
+	return merged
+}
+
+// Returns the closest contact in the short list that has not been contacted yet. Contacts that were
+// queried by another path are dropped from the short list, so that the paths stay disjoint.
+func (lookup *lookup) nextContact() (Contact, bool) {
+	lookup.shortList.Sort()
+	for index := 0; index < lookup.shortList.Len(); index++ {
+		contact := lookup.shortList.contacts[index]
+		if path, exist := lookup.claimed[contact.ID.String()]; exist && path != lookup.path {
+			lookup.shortList.RemoveContact(&contact)
+			index--
+			continue
+		}
+		if !lookup.contacted[contact.ID.String()] {
+			return contact, true
+		}
+	}
+	return Contact{}, false
+}
+
+// Sends the lookup RPC to contact and delivers the outcome on responses once it is answered or times out.
+func (lookup *lookup) query(ctx context.Context, contact Contact, responses chan lookupResponse) {
+	lookup.contacted[contact.ID.String()] = true
+	lookup.claimed[contact.ID.String()] = lookup.path
+	lookup.inFlight++
+
+	go func() {
+		network := lookup.kademlia.network
+		rpcID := NewRandomKademliaID()
+
+		err := lookup.kademlia.sendLookupMessage(ctx, lookup.target, &contact, rpcID, lookup.opType)
+		if err != nil {
+			utils.LogError("nodeLookup: %s", err)
+			responses <- lookupResponse{lookup.path, contact, nil}
+			return
+		}
+
+		responseCtx, cancel := context.WithTimeout(ctx, rpcTimeout)
+		defer cancel()
+		response, err := network.ListenForResponse(responseCtx, rpcID)
+		if err != nil {
+			utils.Log(1, "nodeLookup: no response from %s", contact.Address)
+		}
+		responses <- lookupResponse{lookup.path, contact, response}
+	}()
+}
+
+// Updates the lookup with a response. Returns the data if the response contains the value looked up.
+func (lookup *lookup) handleResponse(response lookupResponse) []byte {
+	contact := response.contact
+
+	// Contacts that do not respond are removed from the short list and marked stale in the routing table.
+	// Responses missing because the lookup was cancelled never get here.
+	if response.message == nil {
+		lookup.failed[contact.ID.String()] = true
+		lookup.shortList.RemoveContact(&contact)
+		lookup.kademlia.network.rt.MarkStale(contact)
+		return nil
+	}
+
+	// If response contains stored data, terminate and return it to the caller
+	if valueResponse := response.message.GetFindValueResponse(); valueResponse != nil {
+		_, err := validateMessageRecord(valueResponse.Kind, lookup.target, valueResponse.Data)
+		if lookup.opType == FIND_VALUE && err == nil {
+			return valueResponse.Data
+		}
+
+		// A value that does not match the key counts as a failure and the lookup goes on without the contact
+		count := lookup.kademlia.network.rt.ReportMisbehavior(contact)
+		lookup.kademlia.stats.invalidValue()
+		utils.LogError("nodeLookup: %s returned an invalid value for key %s (%d times): %v", contact.Address, lookup.target.String(), count, err)
+		lookup.failed[contact.ID.String()] = true
+		lookup.shortList.RemoveContact(&contact)
+		return nil
+	}
+
+	nodesResponse := response.message.GetFindNodeResponse()
+	if nodesResponse == nil {
+		utils.LogError("nodeLookup: unexpected %s response from %s", messageType(response.message), contact.Address)
+		lookup.failed[contact.ID.String()] = true
+		lookup.shortList.RemoveContact(&contact)
+		return nil
+	}
+
+	// Contact responded and it wasn't a FIND_VALUE_RESPONSE
+	lookup.responded.Append([]Contact{contact})
+
+	// Extract contacts from message
+	responseContacts := []Contact{}
+	for _, node := range nodesResponse.GetNodes() {
+		newContact, err := NewContactFromNode(node)
+		if err != nil {
+			utils.LogError("nodeLookup: could not translate node to contact %s", err)
+			continue
+		}
+		if newContact.ID.Equals(lookup.kademlia.network.rt.me.ID) || lookup.failed[newContact.ID.String()] {
+			continue
+		}
+		newContact.CalcDistance(lookup.target)
+		responseContacts = append(responseContacts, newContact)
+	}
+
+	updateShortList(&lookup.shortList, responseContacts, lookup.kademlia.network.k)
+	return nil
+}
+
+// Send the lookup RPC that matches opType to node.
+func (kademlia *Kademlia) sendLookupMessage(ctx context.Context, target *KademliaID, node *Contact, rpcID *KademliaID, opType string) error {
+	if opType == FIND_NODE || opType == STORE {
+		return kademlia.network.SendFindContactMessage(ctx, target, node, rpcID)
+	}
+	return kademlia.network.SendFindDataMessage(ctx, target, node, rpcID)
+}
+
+// Update the short list with new contacts.
+func updateShortList(shortList *ContactCandidates, newContacts []Contact, k int) bool {
+	// Filter out nodes that are already in shortList
+	nodesReplaced := false
+	for _, newNode := range newContacts {
+		if Contains(shortList.contacts, newNode) {
+			continue
+		}
+
+		// If shortList contains less than k nodes, nodes are freely inserted into the state until it contains k nodes.
+		if shortList.Len() < k {
+			shortList.Append([]Contact{newNode})
+		} else {
+			// shortList already contains k elements, replace the node furthest away with the new node if it is closer than that node
+			shortList.Sort()
+			if newNode.Less(&shortList.contacts[len(shortList.contacts)-1]) {
+				shortList.contacts[len(shortList.contacts)-1] = newNode
+				nodesReplaced = true
+			}
+		}
+	}
+	return nodesReplaced
+}
@@ -0,0 +1,344 @@
+package kademlia
+
+import (
+	"bytes"
+	"context"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Counts the packets sent through it
+type countingTransport struct {
+	Transport
+	mu   sync.Mutex
+	sent int
+}
+
+func (transport *countingTransport) Send(address string, data []byte) error {
+	transport.mu.Lock()
+	transport.sent++
+	transport.mu.Unlock()
+
+	return transport.Transport.Send(address, data)
+}
+
+// Keeps the peak number of FIND_NODE requests sent through it that were not answered yet. Requests are
+// held for hold before they are sent, so that the lookups that send them overlap.
+type concurrencyTransport struct {
+	Transport
+	mu       sync.Mutex
+	hold     time.Duration
+	inFlight map[string]bool // RPC IDs of the requests that were not answered yet
+	peak     int
+}
+
+func (transport *concurrencyTransport) Start(address string, handler func(source string, data []byte)) error {
+	return transport.Transport.Start(address, func(source string, data []byte) {
+		if message, err := protobuf.DeserializeMessage(data); err == nil && message.GetFindNodeResponse() != nil {
+			transport.mu.Lock()
+			delete(transport.inFlight, string(message.RpcId))
+			transport.mu.Unlock()
+		}
+		handler(source, data)
+	})
+}
+
+func (transport *concurrencyTransport) Send(address string, data []byte) error {
+	message, err := protobuf.DeserializeMessage(data)
+	if err != nil || message.GetFindNode() == nil {
+		return transport.Transport.Send(address, data)
+	}
+
+	transport.mu.Lock()
+	transport.inFlight[string(message.RpcId)] = true
+	if len(transport.inFlight) > transport.peak {
+		transport.peak = len(transport.inFlight)
+	}
+	hold := transport.hold
+	transport.mu.Unlock()
+
+	time.Sleep(hold)
+	return transport.Transport.Send(address, data)
+}
+
+func TestUpdateShortList(t *testing.T) {
+	target := NewKademliaID("0000000000000000000000000000000000000000")
+	id1 := NewKademliaID("1111111111111111111111111111111111111111")
+	id2 := NewKademliaID("2222222222222222222222222222222222222222")
+	id3 := NewKademliaID("3333333333333333333333333333333333333333")
+	id4 := NewKademliaID("4444444444444444444444444444444444444444")
+
+	// Initialize shortList and new contacts
+	shortList := &ContactCandidates{contacts: []Contact{
+		{ID: id1, Address: "192.168.0.1", distance: id1.CalcDistance(target)},
+		{ID: id2, Address: "192.168.0.2", distance: id2.CalcDistance(target)},
+	}}
+	newContacts := []Contact{
+		{ID: id3, Address: "192.168.0.3", distance: id3.CalcDistance(target)},
+		{ID: id4, Address: "192.168.0.4", distance: id4.CalcDistance(target)},
+	}
+
+	// Call the function
+	nodesReplaced := updateShortList(shortList, newContacts, 3)
+
+	// Print distances for debugging
+	fmt.Println("Distances after update:")
+	for _, contact := range shortList.contacts {
+		fmt.Printf("Node ID: %s, Distance: %s\n", contact.ID.String(), contact.distance.String())
+	}
+
+	// Assert the results
+	expectedContacts := []Contact{
+		{ID: id1, Address: "192.168.0.1", distance: id1.CalcDistance(target)},
+		{ID: id2, Address: "192.168.0.2", distance: id2.CalcDistance(target)},
+		{ID: id3, Address: "192.168.0.3", distance: id3.CalcDistance(target)},
+	}
+
+	if !compareContactArrays(shortList.contacts, expectedContacts) {
+		t.Errorf("Short list not updated as expected.")
+	}
+
+	if nodesReplaced {
+		t.Errorf("Nodes should just be added, and not replaced.")
+	}
+}
+
+func compareContactArrays(a, b []Contact) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID.String() != b[i].ID.String() || a[i].Address != b[i].Address {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLookupAlphaInFlight(t *testing.T) {
+	memory := NewMemoryNetwork()
+	counter := &countingTransport{Transport: memory.NewTransport()}
+	kademlia, err := NewMemoryNode(counter, NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+
+	// None of the contacts exist, so their RPCs stay in flight until the lookup is cancelled
+	for i := 2; i < 12; i++ {
+		kademlia.network.rt.AddContact(NewContact(NewRandomKademliaID(), fmt.Sprintf("10.0.0.%d:80", i)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	kademlia.LookupContact(ctx, NewRandomKademliaID())
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	if counter.sent != 3 {
+		t.Errorf("Expected %d RPCs in flight, but %d were sent", 3, counter.sent)
+	}
+}
+
+func TestConcurrentLookups(t *testing.T) {
+	memory := NewMemoryNetwork()
+	transport := &concurrencyTransport{Transport: memory.NewTransport(), inFlight: make(map[string]bool)}
+	bootstrap, err := NewMemoryNode(transport, NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	nodes := []*Kademlia{bootstrap}
+	for i := 2; i <= 30; i++ {
+		node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), fmt.Sprintf("10.0.0.%d", i))
+		if err != nil {
+			t.Fatalf("NewMemoryNode() returned an error: %v", err)
+		}
+		if err := node.JoinNetwork(context.Background(), &bootstrap.network.rt.me); err != nil {
+			t.Fatalf("JoinNetwork() returned an error: %v", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	// Test that many lookups from the same node run in parallel and each finds the node it looks for
+	transport.mu.Lock()
+	transport.hold = 20 * time.Millisecond
+	transport.inFlight = make(map[string]bool)
+	transport.peak = 0
+	transport.mu.Unlock()
+	var lookups sync.WaitGroup
+	for _, node := range nodes {
+		lookups.Add(1)
+		go func(target Contact) {
+			defer lookups.Done()
+			contacts, err := nodes[0].LookupContact(context.Background(), target.ID)
+			if err != nil {
+				t.Errorf("LookupContact() returned an error: %v", err)
+				return
+			}
+			if len(contacts) == 0 || (!contacts[0].ID.Equals(target.ID) && !target.ID.Equals(nodes[0].network.rt.me.ID)) {
+				t.Errorf("LookupContact() did not find %s", target.Address)
+			}
+		}(node.network.rt.me)
+	}
+	lookups.Wait()
+
+	// A single lookup never has more than alpha FIND_NODE requests waiting for a response, so more than
+	// alpha of them at once means that the lookups overlapped
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if transport.peak <= bootstrap.network.alpha {
+		t.Errorf("Expected more than %d unanswered FIND_NODE requests at once, but the peak was %d", bootstrap.network.alpha, transport.peak)
+	}
+}
+
+func TestLookupInvalidValue(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodes, err := memory.NewCluster(5)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+
+	data := []byte("the real value")
+	key := utils.Hash(data)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].network.rt.me.ID.CalcDistance(NewKademliaID(key)).Less(nodes[j].network.rt.me.ID.CalcDistance(NewKademliaID(key)))
+	})
+
+	// The node closest to the key returns garbage, so it answers before the honest node
+	liar, honest, looker := nodes[0], nodes[3], nodes[4]
+	liar.network.storage.StoreData(key, []byte("garbage"), time.Hour)
+
+	// Test that the lookup returns nothing when only a misbehaving node has a value
+	result, err := looker.LookupData(context.Background(), key)
+	if err != nil || result != nil {
+		t.Errorf("Expected LookupData() to find nothing, but got %s %v", string(result), err)
+	}
+	stats := looker.Stats()
+	if stats.InvalidValues != 1 || stats.MisbehavingNodes != 1 {
+		t.Errorf("Expected 1 invalid value from 1 node, but got %d from %d", stats.InvalidValues, stats.MisbehavingNodes)
+	}
+
+	// Test that the lookup goes on past the misbehaving node and finds the real value
+	honest.network.storage.StoreData(key, data, time.Hour)
+	result, err = looker.LookupData(context.Background(), key)
+	if err != nil || string(result) != string(data) {
+		t.Errorf("Expected LookupData() to return %s, but got %s %v", string(data), string(result), err)
+	}
+
+	// Test that the misbehaving node stays stale even after it is seen again
+	looker.network.rt.AddContact(liar.network.rt.me)
+	looker.network.rt.mu.RLock()
+	defer looker.network.rt.mu.RUnlock()
+	bucket := looker.network.rt.buckets[looker.network.rt.getBucketIndex(liar.network.rt.me.ID)]
+	if !bucket.stale[liar.network.rt.me.ID.String()] {
+		t.Error("Expected the misbehaving node to be marked stale")
+	}
+}
+
+func TestDisjointPaths(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodes, err := memory.NewCluster(20)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+	recorder := &recordingTransport{Transport: memory.NewTransport()}
+	looker, err := NewMemoryNode(recorder, NewIdentity(), "10.0.1.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	if err := looker.JoinNetwork(context.Background(), &nodes[0].network.rt.me); err != nil {
+		t.Fatalf("JoinNetwork() returned an error: %v", err)
+	}
+
+	// Test that a lookup over disjoint paths finds the target without querying any contact twice
+	looker.DisjointPaths = 3
+	recorder.mu.Lock()
+	recorder.sent, recorder.addresses = nil, nil
+	recorder.mu.Unlock()
+	target := nodes[7].network.rt.me
+	contacts, err := looker.LookupContact(context.Background(), target.ID)
+	if err != nil || len(contacts) == 0 || !contacts[0].ID.Equals(target.ID) {
+		t.Fatalf("Expected LookupContact() to find %s, but got %v %v", target.Address, contacts, err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	queried := make(map[string]int)
+	for index, packet := range recorder.sent {
+		if message, err := protobuf.DeserializeMessage(packet); err == nil && message.GetFindNode() != nil {
+			queried[recorder.addresses[index]]++
+		}
+	}
+	if len(queried) < 3 {
+		t.Errorf("Expected every path to query contacts, but only %d were queried", len(queried))
+	}
+	for address, count := range queried {
+		if count > 1 {
+			t.Errorf("Expected %s to be queried by one path, but it was queried %d times", address, count)
+		}
+	}
+}
+
+func TestDisjointPathsEclipse(t *testing.T) {
+	memory := NewMemoryNetwork()
+	honest, err := memory.NewCluster(5)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+
+	// The value is stored on the honest nodes
+	data := []byte("the value the liars hide")
+	key := NewKademliaID(utils.Hash(data))
+	for _, node := range honest {
+		node.network.storage.StoreData(key.String(), data, time.Hour)
+	}
+
+	// Liars are closer to the key than the honest nodes and only know each other, so a path that reaches
+	// one of them never leaves them again
+	liars := []*Kademlia{}
+	for i := 1; i <= 3; i++ {
+		liar, err := NewMemoryNode(memory.NewTransport(), closerIdentity(key, honest), fmt.Sprintf("10.0.2.%d", i))
+		if err != nil {
+			t.Fatalf("NewMemoryNode() returned an error: %v", err)
+		}
+		liars = append(liars, liar)
+	}
+	for _, liar := range liars {
+		for _, other := range liars {
+			if liar != other {
+				liar.network.rt.AddContact(other.network.rt.me)
+			}
+		}
+	}
+
+	// Lookers know one liar and the honest nodes, and query one contact at a time
+	newLooker := func(ip string) *Kademlia {
+		looker, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), ip)
+		if err != nil {
+			t.Fatalf("NewMemoryNode() returned an error: %v", err)
+		}
+		looker.network.k, looker.network.alpha = 3, 1
+		looker.network.rt.AddContact(liars[0].network.rt.me)
+		for _, node := range honest {
+			looker.network.rt.AddContact(node.network.rt.me)
+		}
+		return looker
+	}
+
+	// Test that a single path is steered to the liars and finds nothing
+	if result, err := newLooker("10.0.1.1").LookupData(context.Background(), key.String()); err != nil || result != nil {
+		t.Fatalf("Expected a single path to be eclipsed, but got %q %v", result, err)
+	}
+
+	// Test that with disjoint paths, the path that starts at an honest node finds the value
+	looker := newLooker("10.0.1.2")
+	looker.DisjointPaths = 2
+	result, err := looker.LookupData(context.Background(), key.String())
+	if err != nil || !bytes.Equal(result, data) {
+		t.Errorf("Expected LookupData() to find the value over disjoint paths, but got %q %v", result, err)
+	}
+}
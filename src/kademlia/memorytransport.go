@@ -0,0 +1,162 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/utils"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Number of packets that can be queued for a memory transport before new packets are dropped
+const memoryInboxSize = 1024
+
+// MemoryNetwork routes packets between in-process transports by address,
+// which makes it possible to run many nodes inside a single process
+type MemoryNetwork struct {
+	mu        sync.RWMutex
+	endpoints map[string]chan memoryPacket
+}
+
+// A packet queued for a memory transport, with the address of the transport that sent it
+type memoryPacket struct {
+	source string
+	data   []byte
+}
+
+// Create a new MemoryNetwork instance.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{endpoints: make(map[string]chan memoryPacket)}
+}
+
+// Create a new transport attached to the memory network.
+func (memory *MemoryNetwork) NewTransport() *MemoryTransport {
+	return &MemoryTransport{memory: memory}
+}
+
+// Create a new node that listens on ip, port 80, and sends through transport, which is usually a transport of the
+// memory network. The setup functions are called before the node starts listening, e.g. to turn on encryption.
+func NewMemoryNode(transport Transport, identity *Identity, ip string, setup ...func(*Network) error) (*Kademlia, error) {
+	rt := NewRoutingTable(identity.Contact(fmt.Sprintf("%s:%d", ip, 80)))
+	net, err := NewNetworkWithTransport(transport, identity, rt, 20, 3, time.Minute, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	for _, setup := range setup {
+		if err := setup(net); err != nil {
+			return nil, err
+		}
+	}
+	if err := net.Start(ip, 80); err != nil {
+		return nil, err
+	}
+	return NewKademlia(net), nil
+}
+
+// Create count new nodes on the memory network, at 10.0.0.1 and up, that have joined the network through the first one.
+func (memory *MemoryNetwork) NewCluster(count int) ([]*Kademlia, error) {
+	nodes := make([]*Kademlia, 0, count)
+	for i := 1; i <= count; i++ {
+		node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) > 0 {
+			if err := node.JoinNetwork(context.Background(), &nodes[0].network.rt.me); err != nil {
+				return nil, fmt.Errorf("NewCluster: node %d could not join %w", i, err)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Returns true if a transport is listening on address.
+func (memory *MemoryNetwork) IsListening(address string) bool {
+	memory.mu.RLock()
+	defer memory.mu.RUnlock()
+
+	_, exist := memory.endpoints[address]
+	return exist
+}
+
+// MemoryTransport sends and receives packets through a MemoryNetwork
+type MemoryTransport struct {
+	memory  *MemoryNetwork
+	address string
+	done    chan struct{}
+}
+
+// Registers address on the memory network and starts passing every packet sent to it to handler.
+func (transport *MemoryTransport) Start(address string, handler func(source string, data []byte)) error {
+	inbox := make(chan memoryPacket, memoryInboxSize)
+
+	transport.memory.mu.Lock()
+	defer transport.memory.mu.Unlock()
+
+	if transport.address != "" {
+		return fmt.Errorf("MemoryTransport.Start: already listening on %s", transport.address)
+	}
+	if _, exist := transport.memory.endpoints[address]; exist {
+		return fmt.Errorf("MemoryTransport.Start: address %s is already in use", address)
+	}
+	transport.memory.endpoints[address] = inbox
+	transport.address = address
+	transport.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+		for packet := range inbox {
+			handler(packet.source, packet.data)
+		}
+	}(transport.done)
+
+	return nil
+}
+
+// Delivers a copy of data to the transport listening on address. Like UDP, packets
+// sent to unknown addresses or full inboxes are lost without an error.
+func (transport *MemoryTransport) Send(address string, data []byte) error {
+	transport.memory.mu.RLock()
+	defer transport.memory.mu.RUnlock()
+
+	// Packets are sent from the address the transport listens on, so it must be started
+	if transport.address == "" {
+		return fmt.Errorf("MemoryTransport.Send: %w", ErrTransportNotStarted)
+	}
+
+	inbox, exist := transport.memory.endpoints[address]
+	if !exist {
+		utils.Log(1, "MemoryTransport.Send: no transport listening on %s, packet dropped", address)
+		return nil
+	}
+
+	packet := memoryPacket{source: transport.address, data: make([]byte, len(data))}
+	copy(packet.data, data)
+
+	select {
+	case inbox <- packet:
+	default:
+		utils.Log(2, "MemoryTransport.Send: inbox of %s is full, packet dropped", address)
+	}
+
+	return nil
+}
+
+// Removes the transport from the memory network and waits for queued packets to be handled.
+func (transport *MemoryTransport) Stop() error {
+	transport.memory.mu.Lock()
+	if transport.address == "" {
+		transport.memory.mu.Unlock()
+		return fmt.Errorf("MemoryTransport.Stop: %w", ErrTransportNotStarted)
+	}
+	inbox := transport.memory.endpoints[transport.address]
+	delete(transport.memory.endpoints, transport.address)
+	close(inbox)
+	transport.address = ""
+	done := transport.done
+	transport.memory.mu.Unlock()
+
+	<-done
+	return nil
+}
@@ -0,0 +1,122 @@
+package kademlia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	memory := NewMemoryNetwork()
+	received := make(chan []byte, 1)
+	sources := make(chan string, 1)
+
+	receiver := memory.NewTransport()
+	err := receiver.Start("receiver", func(source string, data []byte) {
+		sources <- source
+		received <- data
+	})
+	if err != nil {
+		t.Fatalf("Start() returned an error: %v", err)
+	}
+
+	// Test that a second transport cannot take the same address
+	err = memory.NewTransport().Start("receiver", func(source string, data []byte) {})
+	if err == nil {
+		t.Error("Start() should return an error when the address is already in use")
+	}
+
+	// Test that a transport cannot send before it is started, since packets come from its address
+	sender := memory.NewTransport()
+	if err := sender.Send("receiver", []byte("hello world")); !errors.Is(err, ErrTransportNotStarted) {
+		t.Errorf("Expected Send() to return ErrTransportNotStarted, but got %v", err)
+	}
+	if err := sender.Start("sender", func(source string, data []byte) {}); err != nil {
+		t.Fatalf("Start() returned an error: %v", err)
+	}
+
+	// Test that packets are delivered by address, with the address of their sender
+	if err := sender.Send("receiver", []byte("hello world")); err != nil {
+		t.Errorf("Send() returned an error: %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if string(data) != "hello world" {
+			t.Errorf("Expected to receive %s, but got %s", "hello world", string(data))
+		}
+		if source := <-sources; source != "sender" {
+			t.Errorf("Expected packet to come from %s, but got %s", "sender", source)
+		}
+	case <-time.After(time.Second):
+		t.Error("Packet was not delivered to the receiver")
+	}
+
+	// Test that packets to unknown addresses are dropped silently
+	if err := sender.Send("nobody", []byte("hello world")); err != nil {
+		t.Errorf("Send() to an unknown address returned an error: %v", err)
+	}
+
+	// Test that a stopped transport no longer receives packets
+	if err := receiver.Stop(); err != nil {
+		t.Errorf("Stop() returned an error: %v", err)
+	}
+	if memory.IsListening("receiver") {
+		t.Error("Stop() did not remove the transport from the memory network")
+	}
+	if err := receiver.Stop(); err == nil {
+		t.Error("Stop() should return an error when the transport is not started")
+	}
+}
+
+func TestMemoryNetworkEndToEnd(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodeCount := 100
+
+	bootstrap, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	nodes := []*Kademlia{bootstrap}
+	for i := 1; i < nodeCount; i++ {
+		node, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), fmt.Sprintf("10.0.%d.%d", i/256, i%256+1))
+		if err != nil {
+			t.Fatalf("NewMemoryNode() returned an error: %v", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	// Join all nodes through the bootstrap node
+	var joined sync.WaitGroup
+	for _, node := range nodes[1:] {
+		joined.Add(1)
+		go func(node *Kademlia) {
+			defer joined.Done()
+			if err := node.JoinNetwork(context.Background(), &bootstrap.network.rt.me); err != nil {
+				t.Errorf("JoinNetwork() returned an error: %v", err)
+			}
+		}(node)
+	}
+	joined.Wait()
+
+	// Store data from one node and look it up from another
+	data := []byte("hello memory network")
+	hash, replicas, err := nodes[5].Store(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Store() returned an error: %v", err)
+	}
+	if replicas != 20 {
+		t.Errorf("Expected data to be stored on 20 nodes, but it was stored on %d", replicas)
+	}
+
+	result, err := nodes[nodeCount-1].LookupData(context.Background(), hash)
+	if err != nil {
+		t.Fatalf("LookupData() returned an error: %v", err)
+	}
+	if string(result) != string(data) {
+		t.Errorf("Expected LookupData() to return %s, but got %s", string(data), string(result))
+	}
+}
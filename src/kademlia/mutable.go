@@ -0,0 +1,252 @@
+package kademlia
+
+import (
+	"context"
+	"crypto/ed25519"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Signed together with the sequence number and value, so that a signature made for something else is never a valid record
+const mutableSignaturePrefix = "d7024e mutable record\n"
+
+// Stale replicas of mutable records a node repairs at once, over all resolves
+const maxConcurrentRepairs = 8
+
+// MutableValue is the latest value published under a public key
+type MutableValue struct {
+	PublicKey ed25519.PublicKey
+	Sequence  uint64
+	Value     []byte
+}
+
+// Returns the key the mutable records signed with publicKey are stored under.
+func MutableKey(publicKey ed25519.PublicKey) *KademliaID {
+	return NewKademliaID(utils.Hash(publicKey))
+}
+
+// Returns a serialized mutable record with value and sequence, signed with privateKey.
+func NewMutableRecord(privateKey ed25519.PrivateKey, sequence uint64, value []byte) ([]byte, error) {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	return protobuf.SerializeMutableRecord(&protobuf.MutableRecord{
+		PublicKey: publicKey,
+		Sequence:  sequence,
+		Value:     value,
+		Signature: ed25519.Sign(privateKey, mutableSignedData(sequence, value)),
+	})
+}
+
+// Returns the data the signature of a mutable record is made over.
+func mutableSignedData(sequence uint64, value []byte) []byte {
+	signed := make([]byte, 0, len(mutableSignaturePrefix)+8+len(value))
+	signed = append(signed, mutableSignaturePrefix...)
+	signed = binary.BigEndian.AppendUint64(signed, sequence)
+	return append(signed, value...)
+}
+
+// Decodes the mutable record stored under key and checks its signature.
+func decodeMutableRecord(key *KademliaID, data []byte) (*protobuf.MutableRecord, error) {
+	record, err := protobuf.DeserializeMutableRecord(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	if len(record.PublicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: public key of %d bytes", ErrInvalidRecord, len(record.PublicKey))
+	}
+	if !MutableKey(record.PublicKey).Equals(key) {
+		return nil, fmt.Errorf("%w: key %s is not the hash of the public key", ErrInvalidRecord, key.String())
+	}
+	if !ed25519.Verify(record.PublicKey, mutableSignedData(record.Sequence, record.Value), record.Signature) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidRecord)
+	}
+	return record, nil
+}
+
+// Mutable records must be signed with the public key whose hash they are stored under.
+func validateMutableRecord(key *KademliaID, data []byte) error {
+	_, err := decodeMutableRecord(key, data)
+	return err
+}
+
+// A mutable record is only replaced by a record with a higher sequence number. Both records have been
+// validated under the same key, so they are signed with the same key.
+func replaceMutableRecord(stored []byte, data []byte) error {
+	storedRecord, err := protobuf.DeserializeMutableRecord(stored)
+	if err != nil {
+		return nil
+	}
+	record, err := protobuf.DeserializeMutableRecord(data)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	if record.Sequence <= storedRecord.Sequence {
+		return fmt.Errorf("%w: sequence number %d is not higher than %d", ErrRecordExists, record.Sequence, storedRecord.Sequence)
+	}
+	return nil
+}
+
+// Publishes value as the latest mutable record signed with RecordKey, with a sequence number higher than
+// any published before. Returns the key the record is stored under, its sequence number and the number
+// of contacts that confirmed storing it.
+func (kademlia *Kademlia) Publish(ctx context.Context, value []byte) (string, uint64, int, error) {
+	recordKey, err := kademlia.recordKey()
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("Publish: %w", err)
+	}
+	publicKey := recordKey.Public().(ed25519.PublicKey)
+	key := MutableKey(publicKey)
+
+	// The network may know a higher sequence number than this node, for example after a restart
+	latest, err := kademlia.Resolve(ctx, key.String())
+	if err != nil {
+		return key.String(), 0, 0, fmt.Errorf("Publish: %w", err)
+	}
+	kademlia.mu.Lock()
+	sequence := kademlia.sequence + 1
+	if latest != nil && latest.Sequence >= sequence {
+		sequence = latest.Sequence + 1
+	}
+	kademlia.sequence = sequence
+	kademlia.mu.Unlock()
+
+	record, err := NewMutableRecord(recordKey, sequence, value)
+	if err != nil {
+		return key.String(), sequence, 0, fmt.Errorf("Publish: %w", err)
+	}
+	replicas, err := kademlia.storeOnClosest(ctx, key, MUTABLE_RECORD, record, 0)
+	if err != nil {
+		return key.String(), sequence, 0, fmt.Errorf("Publish: %w", err)
+	}
+
+	// The latest record replaces the one republished before
+	kademlia.mu.Lock()
+	kademlia.published[key.String()] = &publishedValue{record, MUTABLE_RECORD, time.Now()}
+	kademlia.mu.Unlock()
+
+	if len(replicas) < kademlia.MinReplicas {
+		return key.String(), sequence, len(replicas), fmt.Errorf("Publish: %w (%d of %d required)", ErrInsufficientReplicas, len(replicas), kademlia.MinReplicas)
+	}
+	utils.Log(1, "Published record %s with sequence number %d on %d contacts", key.String(), sequence, len(replicas))
+	return key.String(), sequence, len(replicas), nil
+}
+
+// Resolves the mutable record stored under key by asking all of the k closest contacts for it, since
+// they may hold different versions. Contacts that hold an older version or none are sent the latest one.
+// Returns nil if no contact holds a valid record.
+func (kademlia *Kademlia) Resolve(ctx context.Context, key string) (*MutableValue, error) {
+	target := NewKademliaID(key)
+	contacts, _, err := kademlia.nodeLookup(ctx, target, FIND_NODE)
+	if err != nil {
+		return nil, fmt.Errorf("Resolve: %w", err)
+	}
+
+	records := make([]*protobuf.MutableRecord, len(contacts))
+	responded := make([]bool, len(contacts))
+	var queries sync.WaitGroup
+	for index := range contacts {
+		queries.Add(1)
+		go func(index int) {
+			defer queries.Done()
+			records[index], responded[index] = kademlia.findMutableRecordAt(ctx, target, &contacts[index])
+		}(index)
+	}
+	queries.Wait()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("Resolve: %w", ctx.Err())
+	}
+
+	// A record held by this node counts as well
+	var latest *protobuf.MutableRecord
+	if data, exist := kademlia.network.storage.FetchData(key); exist && kademlia.network.storage.Kind(key) == MUTABLE_RECORD {
+		latest, _ = decodeMutableRecord(target, data)
+	}
+	for _, record := range records {
+		if record != nil && (latest == nil || record.Sequence > latest.Sequence) {
+			latest = record
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+
+	kademlia.repairRecords(ctx, target, latest, records, responded, contacts)
+	return &MutableValue{latest.PublicKey, latest.Sequence, latest.Value}, nil
+}
+
+// Stores latest on the contacts that responded without it. The repairs are done before returning, so that
+// they never outlive the caller, and no more than maxConcurrentRepairs run at once on the node.
+func (kademlia *Kademlia) repairRecords(ctx context.Context, key *KademliaID, latest *protobuf.MutableRecord, records []*protobuf.MutableRecord, responded []bool, contacts []Contact) {
+	repairCtx, cancel := context.WithTimeout(ctx, storeResponseTimeout)
+	defer cancel()
+
+	latestData, _ := protobuf.SerializeMutableRecord(latest)
+	var repairs sync.WaitGroup
+	for index, record := range records {
+		if !responded[index] || (record != nil && record.Sequence >= latest.Sequence) {
+			continue
+		}
+		select {
+		case kademlia.repairs <- struct{}{}:
+		case <-repairCtx.Done():
+			repairs.Wait()
+			return
+		}
+		repairs.Add(1)
+		go func(contact *Contact) {
+			defer repairs.Done()
+			defer func() { <-kademlia.repairs }()
+			kademlia.storeAt(repairCtx, key, MUTABLE_RECORD, latestData, 0, contact)
+		}(&contacts[index])
+	}
+	repairs.Wait()
+}
+
+// Asks contact for the mutable record stored under key. Returns nil if contact does not respond or does
+// not hold a valid record, and whether contact responded.
+func (kademlia *Kademlia) findMutableRecordAt(ctx context.Context, key *KademliaID, contact *Contact) (*protobuf.MutableRecord, bool) {
+	rpcID := NewRandomKademliaID()
+	if err := kademlia.network.SendFindDataMessage(ctx, key, contact, rpcID); err != nil {
+		utils.LogError("Resolve: %s", err)
+		return nil, false
+	}
+
+	responseCtx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	defer cancel()
+	response, err := kademlia.network.ListenForResponse(responseCtx, rpcID)
+	if err != nil {
+		utils.Log(1, "Resolve: no response from %s", contact.Address)
+		return nil, false
+	}
+	valueResponse := response.GetFindValueResponse()
+	if valueResponse == nil {
+		return nil, true
+	}
+
+	record, err := decodeMutableRecord(key, valueResponse.Data)
+	if err != nil || valueResponse.Kind != protobuf.RecordKind(MUTABLE_RECORD) {
+		count := kademlia.network.rt.ReportMisbehavior(*contact)
+		kademlia.stats.invalidValue()
+		utils.LogError("Resolve: %s returned an invalid record for key %s (%d times): %v", contact.Address, key.String(), count, err)
+		return nil, true
+	}
+	return record, true
+}
+
+// Returns RecordKey, generating it if it has not been set.
+func (kademlia *Kademlia) recordKey() (ed25519.PrivateKey, error) {
+	kademlia.mu.Lock()
+	defer kademlia.mu.Unlock()
+
+	if kademlia.RecordKey == nil {
+		_, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate record key %w", err)
+		}
+		kademlia.RecordKey = key
+	}
+	return kademlia.RecordKey, nil
+}
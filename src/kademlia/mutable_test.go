@@ -0,0 +1,134 @@
+package kademlia
+
+import (
+	"context"
+	"crypto/ed25519"
+	"d7024e/protobuf"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateMutableRecord(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	key := MutableKey(publicKey)
+	record, _ := NewMutableRecord(privateKey, 1, []byte("version 1"))
+	if err := ValidateRecord(MUTABLE_RECORD, key, record); err != nil {
+		t.Errorf("Expected a signed record under the hash of its key to be valid, but got %v", err)
+	}
+
+	// Test that the record is rejected under another key, with another value or with another signer
+	decoded, _ := protobuf.DeserializeMutableRecord(record)
+	decoded.Value = []byte("version 2")
+	tampered, _ := protobuf.SerializeMutableRecord(decoded)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	otherSigner, _ := NewMutableRecord(otherKey, 1, []byte("version 1"))
+
+	tests := map[string]struct {
+		key    *KademliaID
+		record []byte
+	}{
+		"another key":    {NewRandomKademliaID(), record},
+		"tampered value": {key, tampered},
+		"another signer": {key, otherSigner},
+		"not a record":   {key, []byte("version 1")},
+	}
+	for name, test := range tests {
+		if err := ValidateRecord(MUTABLE_RECORD, test.key, test.record); !errors.Is(err, ErrInvalidRecord) {
+			t.Errorf("%s: expected ErrInvalidRecord, but got %v", name, err)
+		}
+	}
+}
+
+func TestStoreMutableRecord(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	key := MutableKey(publicKey).String()
+	version1, _ := NewMutableRecord(privateKey, 1, []byte("version 1"))
+	version2, _ := NewMutableRecord(privateKey, 2, []byte("version 2"))
+	conflict, _ := NewMutableRecord(privateKey, 2, []byte("another version 2"))
+
+	path := filepath.Join(t.TempDir(), "kademlia.log")
+	storage, err := OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	if err := storage.StoreRecord(key, MUTABLE_RECORD, version1, time.Hour); err != nil {
+		t.Fatalf("StoreRecord() returned an error: %v", err)
+	}
+
+	// Test that only a higher sequence number replaces the stored record
+	if err := storage.StoreRecord(key, MUTABLE_RECORD, version2, time.Hour); err != nil {
+		t.Errorf("Expected a higher sequence number to replace the record, but got %v", err)
+	}
+	for _, record := range [][]byte{version1, conflict} {
+		if err := storage.StoreRecord(key, MUTABLE_RECORD, record, time.Hour); !errors.Is(err, ErrRecordExists) {
+			t.Errorf("Expected ErrRecordExists, but got %v", err)
+		}
+	}
+	if err := storage.StoreRecord(key, MUTABLE_RECORD, version2, time.Hour); err != nil {
+		t.Errorf("Expected storing the same record again to succeed, but got %v", err)
+	}
+	if err := storage.StoreData(key, []byte("content"), time.Hour); !errors.Is(err, ErrRecordExists) {
+		t.Errorf("Expected a record of another kind to be rejected, but got %v", err)
+	}
+	storage.Close()
+
+	// Test that the latest record is loaded from the log
+	storage, err = OpenFileStorage(path, time.Minute, StorageLimits{})
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	defer storage.Close()
+	if data, _ := storage.FetchData(key); string(data) != string(version2) {
+		t.Error("Expected the record with the highest sequence number to be loaded")
+	}
+	if storage.Usage().Bytes != int64(len(version2)) {
+		t.Errorf("Expected only the latest record to take up space, but %d bytes are used", storage.Usage().Bytes)
+	}
+}
+
+func TestPublishResolve(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodes, err := memory.NewCluster(6)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+	publisher := nodes[1]
+
+	key, sequence, _, err := publisher.Publish(context.Background(), []byte("version 1"))
+	if err != nil || sequence != 1 {
+		t.Fatalf("Expected the first record to have sequence number 1, but got %d %v", sequence, err)
+	}
+	if _, sequence, _, _ = publisher.Publish(context.Background(), []byte("version 2")); sequence != 2 {
+		t.Errorf("Expected the second record to have sequence number 2, but got %d", sequence)
+	}
+
+	record, err := nodes[5].Resolve(context.Background(), key)
+	if err != nil || record == nil || string(record.Value) != "version 2" || record.Sequence != 2 {
+		t.Fatalf("Expected to resolve version 2, but got %v %v", record, err)
+	}
+
+	// Test that a node that publishes with the same key continues from the sequence number on the network
+	nodes[2].RecordKey = publisher.RecordKey
+	if _, sequence, _, _ = nodes[2].Publish(context.Background(), []byte("version 3")); sequence != 3 {
+		t.Errorf("Expected the sequence number to continue at 3, but got %d", sequence)
+	}
+	record, _ = nodes[4].Resolve(context.Background(), key)
+	if record == nil || string(record.Value) != "version 3" {
+		t.Errorf("Expected to resolve version 3, but got %v", record)
+	}
+
+	// Test that a node that lost the record has it again once a resolve returns
+	nodes[3].network.storage = NewMemoryStorage(time.Minute, StorageLimits{})
+	nodes[5].Resolve(context.Background(), key)
+	if data, exist := nodes[3].network.storage.FetchData(key); !exist {
+		t.Error("Expected the record to be repaired on the node that lost it")
+	} else if repaired, err := decodeMutableRecord(NewKademliaID(key), data); err != nil || repaired.Sequence != 3 {
+		t.Errorf("Expected the repaired record to have sequence number 3, but got %v %v", repaired, err)
+	}
+
+	if record, err := nodes[4].Resolve(context.Background(), NewRandomKademliaID().String()); err != nil || record != nil {
+		t.Errorf("Expected no record for an unknown key, but got %v %v", record, err)
+	}
+}
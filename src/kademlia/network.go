@@ -1,18 +1,15 @@
 package kademlia
 
 import (
+	"context"
 	"d7024e/protobuf"
 	"d7024e/utils"
+	"errors"
 	"fmt"
-	"net"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Mutex
-var mComs sync.RWMutex
-var mRoutingtable sync.RWMutex
-
 // Defines the different message types sent over the network.
 const (
 	PING                string = "ping"
@@ -22,322 +19,429 @@ const (
 	FIND_NODE_RESPONSE  string = "find_node_response"
 	FIND_VALUE_RESPONSE string = "find_value_response"
 	STORE               string = "store"
-	REFRESH             string = "refresh"
+	STORE_RESPONSE      string = "store_response"
+	FRAGMENT            string = "fragment"
+	FRAGMENT_ACK        string = "fragment_ack"
 )
 
+// Longest address a node can listen on, which bounds the size of the sealed packets it sends
+const maxAddressLength = 255
+
 type Network struct {
-	rt      *RoutingTable
-	storage *Storage
-	coms    map[string]chan map[string]string
+	transport Transport
+	identity  *Identity
+	channel   *secureChannel   // Encrypts messages if not nil
+	puzzle    PuzzleDifficulty // Puzzles the IDs of other nodes must solve
+	rt        *RoutingTable
+	storage   Storage
+	fragments *fragmentBuffer
+	pending   *pendingRequests
 
 	k               int
 	alpha           int
 	ttl             time.Duration
 	refreshInterval time.Duration
+
+	invalidMessages atomic.Int64 // Messages dropped because they were not signed by their sender
+	invalidPuzzles  atomic.Int64 // Messages dropped because the ID of their sender did not solve the puzzles
 }
 
-// Create a new Network instance.
-func NewNetwork(rt *RoutingTable, k int, alpha int, ttl time.Duration, refreshInterval time.Duration) *Network {
-	return &Network{rt, NewStorage(ttl), make(map[string]chan map[string]string), k, alpha, ttl, refreshInterval}
+// Create a new Network instance that communicates over UDP. Messages are signed with identity, which
+// must be the identity of the contact rt belongs to.
+func NewNetwork(identity *Identity, rt *RoutingTable, k int, alpha int, ttl time.Duration, refreshInterval time.Duration) (*Network, error) {
+	return NewNetworkWithTransport(NewUDPTransport(), identity, rt, k, alpha, ttl, refreshInterval)
 }
 
-// Listens for incoming messages on a specified port.
-func (network *Network) Listen(ip string, port int) {
+// Create a new Network instance that communicates over the given transport.
+func NewNetworkWithTransport(transport Transport, identity *Identity, rt *RoutingTable, k int, alpha int, ttl time.Duration, refreshInterval time.Duration) (*Network, error) {
+	return NewNetworkWithStorage(transport, NewMemoryStorage(ttl, StorageLimits{}), identity, rt, k, alpha, ttl, refreshInterval)
+}
 
-	// Resolve the UDP address to bind to
+// Create a new Network instance that communicates over the given transport and keeps values in the given storage.
+// Returns an error if rt does not belong to the contact of identity, since every message the network sent
+// would then fail the signature check of its receiver, or if its address is longer than maxAddressLength.
+func NewNetworkWithStorage(transport Transport, storage Storage, identity *Identity, rt *RoutingTable, k int, alpha int, ttl time.Duration, refreshInterval time.Duration) (*Network, error) {
+	if identity == nil {
+		return nil, errors.New("NewNetwork: no identity to sign messages with")
+	}
+	if rt.me.ID == nil || !rt.me.ID.Equals(identity.ID) {
+		return nil, fmt.Errorf("NewNetwork: the routing table belongs to %v, not to the identity %s", rt.me.ID, identity.ID.String())
+	}
+	if len(rt.me.Address) > maxAddressLength {
+		return nil, fmt.Errorf("NewNetwork: address of %d bytes is longer than %d bytes", len(rt.me.Address), maxAddressLength)
+	}
+
+	return &Network{
+		transport:       transport,
+		identity:        identity,
+		rt:              rt,
+		storage:         storage,
+		fragments:       newFragmentBuffer(),
+		pending:         newPendingRequests(),
+		k:               k,
+		alpha:           alpha,
+		ttl:             ttl,
+		refreshInterval: refreshInterval,
+	}, nil
+}
+
+// Starts listening for incoming messages on a specified port. Outgoing messages are sent from the same socket.
+func (network *Network) Start(ip string, port int) error {
 	address := fmt.Sprintf("%s:%d", ip, port)
-	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	err := network.transport.Start(address, func(source string, data []byte) {
+		// Handle incoming message in a separate goroutine
+		go network.handlePacket(source, data)
+	})
+	if err != nil {
+		return fmt.Errorf("Network.Start: %w", err)
+	}
+	network.pending.start()
+
+	return nil
+}
+
+// Stops listening for incoming messages.
+func (network *Network) Stop() error {
+	network.pending.stop()
+	err := network.transport.Stop()
 	if err != nil {
-		utils.LogError("Network.Listen net resolve %w", err)
+		return fmt.Errorf("Network.Stop: %w", err)
 	}
 
-	// Create a UDP connection to listen on the specified address
-	conn, err := net.ListenUDP("udp", udpAddr)
+	return nil
+}
+
+// Handles a single incoming message that was sent from the address source. If from is not nil, the message
+// is dropped unless it was sent by the node with that ID, such as the node a session was agreed with.
+func (network *Network) handleMessage(source string, from *KademliaID, buffer []byte) {
+	message, err := protobuf.DeserializeMessage(buffer)
 	if err != nil {
-		utils.LogError("Network.Listen listen on udp %w", err)
+		utils.LogError("Listen failed to deserialize message %s", err)
+		return
 	}
-	defer conn.Close()
+	utils.Log(1, "Recieved %s message from %s", messageType(message), message.GetSender().GetAddress())
 
-	for {
-		buffer := make([]byte, 4096) // Adjust buffer size as needed
-		n, _, err := conn.ReadFromUDP(buffer)
+	// Nothing in a message is trusted, and its sender does not go into the routing table, unless it is signed by its sender
+	if err := verifyMessage(message); err != nil {
+		network.invalidMessages.Add(1)
+		utils.LogError("Listen dropped %s message from %s: %s", messageType(message), message.GetSender().GetAddress(), err)
+		return
+	}
+	// IDs that took no work to make could be placed next to any key
+	if err := network.puzzle.Verify(message.PublicKey, message.PuzzleSolution); err != nil {
+		network.invalidPuzzles.Add(1)
+		utils.LogError("Listen dropped %s message from %s: %s", messageType(message), message.Sender.Address, err)
+		return
+	}
+
+	contact, err := NewContactFromNode(message.Sender)
+	if err != nil {
+		utils.LogError("Listen dropped message with invalid sender %s", err)
+		return
+	}
+	// The sender is replied to and added under the address its packet came from, so that the IP limits
+	// are checked against the host that sent it and not against an address it claims
+	contact.Address = source
+	if from != nil && !contact.ID.Equals(from) {
+		network.invalidMessages.Add(1)
+		utils.LogError("Listen dropped %s message from %s: sent by %s, not by %s", messageType(message), contact.Address, contact.ID.String(), from.String())
+		return
+	}
+	rpcID, err := NewKademliaIDFromBytes(message.RpcId)
+	if err != nil {
+		utils.LogError("Listen dropped message with invalid rpc id %s", err)
+		return
+	}
+
+	// Replies are not tied to any caller, fragmented replies are bounded by their retries
+	ctx := context.Background()
+
+	var replyErr error
+	switch body := message.Body.(type) {
+	case *protobuf.KademliaMessage_Ping:
+		replyErr = network.SendPongMessage(ctx, &contact, rpcID)
+
+	case *protobuf.KademliaMessage_FindNode:
+		target, err := NewKademliaIDFromBytes(body.FindNode.Target)
 		if err != nil {
-			utils.LogError("Network.Listen reading from udp %w", err)
-			continue
+			utils.LogError("Listen dropped %s message with invalid target %s", FIND_NODE, err)
+			return
 		}
+		replyErr = network.sendFindContactResponseMessage(ctx, target, &contact, rpcID)
 
-		// Handle incoming message in a separate goroutine
-		go func() {
-			values, err := protobuf.DeserializeMessage(buffer[:n])
-			if err != nil {
-				utils.LogError("Listen failed to deserialize message %s", err)
-				return
-			}
-			utils.Log(1, "Recieved %s message from %s", values["type"], values["sender_address"])
-			contact := NewContact(NewKademliaID(values["sender_id"]), values["sender_address"])
-
-			switch values["type"] {
-			case PING:
-				network.SendPongMessage(&contact, NewKademliaID(values["rpc_id"]))
-
-			case FIND_NODE:
-				network.sendFindContactResponseMessage(values, &contact)
-
-			case FIND_VALUE:
-				// Similar to FIND_NODE, but return the value if found instead of contacts
-				data, exist := network.storage.FetchData(values["key"])
-				if !exist {
-					network.sendFindContactResponseMessage(values, &contact)
-					break
-				}
-
-				response := make(map[string]string)
-				response["rpc_id"] = values["rpc_id"]
-				response["sender_id"] = network.rt.me.ID.String()
-				response["sender_address"] = network.rt.me.Address
-				response["key"] = values["key"]
-				response["data"] = string(data)
-				response["type"] = FIND_VALUE_RESPONSE
-
-				data, err := protobuf.SerializeMessage(response)
-				if err != nil {
-					utils.LogError("Listen FIND_VALUE could not serialize data %s", err)
-					return
-				}
-
-				utils.Log(1, "Sending %s message to %s", response["type"], values["sender_address"])
-				network.sendMessage(contact.Address, data)
-
-			case STORE:
-				network.storage.StoreData(values["key"], []byte(values["data"]), network.ttl)
-
-			case REFRESH:
-				// Refresh the TTL of the data object
-				wasRefreshed := network.storage.RefreshDataTTL(values["key"], network.ttl)
-
-				if wasRefreshed {
-					utils.Log(3, "Data was refreshed with key %s", values["key"])
-				}
-
-			default:
-				network.TransmitResponse(NewKademliaID(values["rpc_id"]), values)
-			}
-
-			// Update routing table with sender
-			mRoutingtable.Lock()
-			network.rt.AddContact(contact)
-			mRoutingtable.Unlock()
-		}()
+	case *protobuf.KademliaMessage_FindValue:
+		// Similar to FIND_NODE, but return the value if found instead of contacts
+		key, err := NewKademliaIDFromBytes(body.FindValue.Key)
+		if err != nil {
+			utils.LogError("Listen dropped %s message with invalid key %s", FIND_VALUE, err)
+			return
+		}
+
+		data, exist := network.storage.FetchData(key.String())
+		if !exist {
+			replyErr = network.sendFindContactResponseMessage(ctx, key, &contact, rpcID)
+			break
+		}
+		replyErr = network.sendFindDataResponseMessage(ctx, key, network.storage.Kind(key.String()), data, &contact, rpcID)
+
+	case *protobuf.KademliaMessage_Store:
+		key, err := NewKademliaIDFromBytes(body.Store.Key)
+		if err != nil {
+			replyErr = network.sendStoreResponseMessage(ctx, fmt.Errorf("invalid key"), &contact, rpcID)
+			break
+		}
+		// Senders can ask for a shorter TTL, e.g. when republishing a value that expires soon
+		ttl := network.ttl
+		if requested := time.Duration(body.Store.TtlMs) * time.Millisecond; requested > 0 && requested < ttl {
+			ttl = requested
+		}
+
+		// Records are only stored if they pass the validation of their kind, content records under the hash of their data
+		kind, err := validateMessageRecord(body.Store.Kind, key, body.Store.Data)
+		switch {
+		case err != nil:
+			utils.Log(2, "Rejected %s from %s: %s", STORE, contact.Address, err)
+		case body.Store.Cached && kind != CONTENT_RECORD:
+			err = fmt.Errorf("%w: only content records can be cached", ErrInvalidRecord)
+		case body.Store.Cached:
+			err = network.storage.CacheData(key.String(), body.Store.Data, ttl)
+		default:
+			err = network.storage.StoreRecord(key.String(), kind, body.Store.Data, ttl)
+		}
+		replyErr = network.sendStoreResponseMessage(ctx, err, &contact, rpcID)
+
+	case *protobuf.KademliaMessage_Fragment:
+		network.handleFragment(&contact, rpcID, body.Fragment)
+
+	case *protobuf.KademliaMessage_FragmentAck:
+		network.fragments.acknowledge(rpcID, body.FragmentAck.Index)
+
+	default:
+		err := network.pending.deliver(rpcID, contact.ID, message)
+		if err != nil {
+			utils.Log(2, "Listen dropped %s message from %s: %s", messageType(message), contact.Address, err)
+			return
+		}
+	}
+
+	if replyErr != nil {
+		utils.LogError("Listen could not reply to %s message from %s: %s", messageType(message), contact.Address, replyErr)
+	}
+
+	// Update routing table with sender
+	if leastRecentlySeen := network.rt.AddContact(contact); leastRecentlySeen != nil {
+		go network.pingLeastRecentlySeen(*leastRecentlySeen)
 	}
 }
 
-// Sends a ping message to contact.
-func (network *Network) SendPingMessage(contact *Contact, rpcID *KademliaID) {
-	// Create a map to hold the values for the Ping message
-	values := make(map[string]string)
-	values["rpc_id"] = rpcID.String()
-	values["sender_id"] = network.rt.me.ID.String()
-	values["sender_address"] = network.rt.me.Address
-	values["type"] = PING
-
-	data, err := protobuf.SerializeMessage(values)
+// Pings the least recently seen contact of a full bucket and evicts it if it does not respond.
+// A response moves the contact to the front of its bucket when the pong is handled.
+func (network *Network) pingLeastRecentlySeen(contact Contact) {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	rpcID := NewRandomKademliaID()
+	err := network.SendPingMessage(ctx, &contact, rpcID)
+	if err == nil {
+		_, err = network.ListenForResponse(ctx, rpcID)
+	}
 	if err != nil {
-		utils.LogError("SendPingPongMessage: could not build message (%s)", err)
-		return
+		utils.Log(2, "Evicting %s from the routing table, it did not respond to ping %s", contact.Address, err)
+		network.rt.RemoveContact(contact)
 	}
+}
+
+// Sends a ping message to contact.
+func (network *Network) SendPingMessage(ctx context.Context, contact *Contact, rpcID *KademliaID) error {
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_Ping{Ping: &protobuf.Ping{}}
 
-	utils.Log(1, "Sending %s message to %s", PING, contact.Address)
-	network.sendMessage(contact.Address, data)
+	return network.sendRequest(ctx, contact, rpcID, message)
 }
 
 // Sends a pong message to contact.
-func (network *Network) SendPongMessage(contact *Contact, rpcID *KademliaID) {
-	// Create a map to hold the values for the Ping message
-	values := make(map[string]string)
-	values["rpc_id"] = rpcID.String()
-	values["sender_id"] = network.rt.me.ID.String()
-	values["sender_address"] = network.rt.me.Address
-	values["type"] = PONG
-
-	data, err := protobuf.SerializeMessage(values)
-	if err != nil {
-		utils.LogError("SendPingPongMessage: could not build message (%s)", err)
-		return
-	}
+func (network *Network) SendPongMessage(ctx context.Context, contact *Contact, rpcID *KademliaID) error {
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_Pong{Pong: &protobuf.Pong{}}
 
-	utils.Log(1, "Sending %s message to %s", PONG, contact.Address)
-	network.sendMessage(contact.Address, data)
+	return network.sendKademliaMessage(ctx, contact, message)
 }
 
 // Sends a find node message to contact.
-func (network *Network) SendFindContactMessage(id *KademliaID, contact *Contact, rpcID *KademliaID) {
-	// Create a map to hold the values for the FindContact message
-	values := make(map[string]string)
-	values["rpc_id"] = rpcID.String()
-	values["sender_id"] = network.rt.me.ID.String()
-	values["sender_address"] = network.rt.me.Address
-	values["key"] = id.String()
-	values["type"] = FIND_NODE
-
-	// Build message
-	data, err := protobuf.SerializeMessage(values)
-	if err != nil {
-		utils.LogError("SendFindContactMessage: could not build message (%s)", err)
-		return
-	}
+func (network *Network) SendFindContactMessage(ctx context.Context, id *KademliaID, contact *Contact, rpcID *KademliaID) error {
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_FindNode{FindNode: &protobuf.FindNode{Target: id[:]}}
 
-	// Send message
-	utils.Log(1, "Sending %s message to %s", FIND_NODE, contact.Address)
-	network.sendMessage(contact.Address, data)
+	return network.sendRequest(ctx, contact, rpcID, message)
 }
 
 // Sends a find data message to contact.
-func (network *Network) SendFindDataMessage(key *KademliaID, contact *Contact, rpcID *KademliaID) {
-	// Create a map to hold the values for the FindData message
-	values := make(map[string]string)
-	values["rpc_id"] = rpcID.String()
-	values["sender_id"] = network.rt.me.ID.String()
-	values["sender_address"] = network.rt.me.Address
-	values["key"] = key.String()
-	values["type"] = FIND_VALUE
-
-	// Build message
-	data, err := protobuf.SerializeMessage(values)
-	if err != nil {
-		utils.LogError("SendFindDataMessage: could not build message (%s)", err)
-		return
-	}
+func (network *Network) SendFindDataMessage(ctx context.Context, key *KademliaID, contact *Contact, rpcID *KademliaID) error {
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_FindValue{FindValue: &protobuf.FindValue{Key: key[:]}}
 
-	// Send message
-	utils.Log(1, "Sending %s message to %s", FIND_VALUE, contact.Address)
-	network.sendMessage(contact.Address, data)
+	return network.sendRequest(ctx, contact, rpcID, message)
 }
 
-// Sends a store message to contact.
-func (network *Network) SendStoreMessage(key *KademliaID, data []byte, contact *Contact, rpcID *KademliaID) {
-	// Create a map to hold the values for the Store message
-	values := make(map[string]string)
-	values["rpc_id"] = rpcID.String()
-	values["sender_id"] = network.rt.me.ID.String()
-	values["sender_address"] = network.rt.me.Address
-	values["key"] = key.String()
-	values["data"] = string(data)
-	values["type"] = STORE
-
-	// Build message
-	data, err := protobuf.SerializeMessage(values)
-	if err != nil {
-		utils.LogError("SendStoreMessage: could not build message (%s)", err)
-		return
-	}
+// Sends a store message to contact asking it to keep a record of kind with data for ttl, or its default TTL if ttl is zero.
+func (network *Network) SendStoreMessage(ctx context.Context, key *KademliaID, kind RecordKind, data []byte, ttl time.Duration, contact *Contact, rpcID *KademliaID) error {
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_Store{Store: &protobuf.Store{Key: key[:], Data: data, TtlMs: uint64(ttl.Milliseconds()), Kind: protobuf.RecordKind(kind)}}
 
-	// Send message
-	utils.Log(1, "Sending %s message to %s", STORE, contact.Address)
-	network.sendMessage(contact.Address, data)
+	return network.sendRequest(ctx, contact, rpcID, message)
 }
 
-// Sends a refresh message to contact.
-func (network *Network) SendRefreshMessage(key *KademliaID, contact *Contact, rpcID *KademliaID) {
-	// Create a map to hold the values for the Refresh message
-	values := make(map[string]string)
-	values["rpc_id"] = rpcID.String()
-	values["sender_id"] = network.rt.me.ID.String()
-	values["sender_address"] = network.rt.me.Address
-	values["key"] = key.String()
-	values["type"] = REFRESH
-
-	// Build message
-	data, err := protobuf.SerializeMessage(values)
-	if err != nil {
-		utils.LogError("SendRefreshMessage: could not build message (%s)", err)
-		return
+// Sends a store message to contact asking it to keep a cached copy of data for ttl.
+func (network *Network) SendCacheMessage(ctx context.Context, key *KademliaID, data []byte, ttl time.Duration, contact *Contact, rpcID *KademliaID) error {
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_Store{Store: &protobuf.Store{Key: key[:], Data: data, TtlMs: uint64(ttl.Milliseconds()), Cached: true}}
+
+	return network.sendRequest(ctx, contact, rpcID, message)
+}
+
+// Sends a store response message to contact telling if the data was stored, or why it was rejected.
+func (network *Network) sendStoreResponseMessage(ctx context.Context, storeErr error, contact *Contact, rpcID *KademliaID) error {
+	response := &protobuf.StoreResponse{Success: storeErr == nil}
+	if storeErr != nil {
+		response.Reason = storeErr.Error()
 	}
 
-	// Send message
-	utils.Log(1, "Sending %s message to %s", REFRESH, contact.Address)
-	network.sendMessage(contact.Address, data)
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_StoreResponse{StoreResponse: response}
+
+	return network.sendKademliaMessage(ctx, contact, message)
 }
 
-// Sends a find node response message to contact.
-func (network *Network) sendFindContactResponseMessage(values map[string]string, contact *Contact) {
-	contacts := ""
-	for _, node := range network.rt.FindClosestContacts(NewKademliaID(values["key"]), network.k) {
-		contacts += node.String() + "\n"
+// Sends a find node response message with the k closest contacts to target to contact.
+func (network *Network) sendFindContactResponseMessage(ctx context.Context, target *KademliaID, contact *Contact, rpcID *KademliaID) error {
+	nodes := []*protobuf.Node{}
+	for _, node := range network.rt.FindClosestContacts(target, network.k) {
+		nodes = append(nodes, node.Node())
 	}
 
-	response := make(map[string]string)
-	response["rpc_id"] = values["rpc_id"]
-	response["sender_id"] = network.rt.me.ID.String()
-	response["sender_address"] = network.rt.me.Address
-	response["key"] = values["key"]
-	response["data"] = contacts
-	response["type"] = FIND_NODE_RESPONSE
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_FindNodeResponse{FindNodeResponse: &protobuf.FindNodeResponse{Nodes: nodes}}
+
+	return network.sendKademliaMessage(ctx, contact, message)
+}
+
+// Sends a find data response message with the stored data and the kind of its record to contact.
+func (network *Network) sendFindDataResponseMessage(ctx context.Context, key *KademliaID, kind RecordKind, data []byte, contact *Contact, rpcID *KademliaID) error {
+	message := network.newMessage(rpcID)
+	message.Body = &protobuf.KademliaMessage_FindValueResponse{FindValueResponse: &protobuf.FindValueResponse{Key: key[:], Data: data, Kind: protobuf.RecordKind(kind)}}
 
-	data, err := protobuf.SerializeMessage(response)
+	return network.sendKademliaMessage(ctx, contact, message)
+}
+
+// Creates a message with me as sender.
+func (network *Network) newMessage(rpcID *KademliaID) *protobuf.KademliaMessage {
+	return &protobuf.KademliaMessage{Sender: network.rt.me.Node(), RpcId: rpcID[:]}
+}
+
+// Sends a request to contact and registers it so the response can be matched to it.
+func (network *Network) sendRequest(ctx context.Context, contact *Contact, rpcID *KademliaID, message *protobuf.KademliaMessage) error {
+	network.pending.add(rpcID, contact.ID)
+
+	err := network.sendKademliaMessage(ctx, contact, message)
 	if err != nil {
-		utils.LogError("Listen %s could not serialize data %s", values["type"], err)
-		return
+		network.pending.remove(rpcID)
+		return err
 	}
 
-	utils.Log(1, "Sending %s message to %s", response["type"], values["sender_address"])
-	network.sendMessage(contact.Address, data)
+	network.pending.sent(rpcID)
+	return nil
 }
 
-// Sends a message to address.
-func (network *Network) sendMessage(address string, data []byte) {
-	// Create UDP connection
-	conn, err := net.Dial("udp", address)
-	if err != nil {
-		fmt.Println("SendMessage: ", err)
+// Serializes and sends a message to contact.
+func (network *Network) sendKademliaMessage(ctx context.Context, contact *Contact, message *protobuf.KademliaMessage) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("could not send %s message %w", messageType(message), ctx.Err())
 	}
 
-	// Write data to address
-	_, err = conn.Write(data)
+	data, err := network.serializeMessage(message)
 	if err != nil {
-		fmt.Println("SendMessage: ", err)
+		return fmt.Errorf("could not build %s message %w", messageType(message), err)
 	}
 
-	// Close connection
-	conn.Close()
+	utils.Log(1, "Sending %s message to %s", messageType(message), contact.Address)
+	if len(data) > network.maxMessageSize() {
+		return network.sendFragmentedMessage(ctx, contact, data)
+	}
+	return network.sendMessage(contact, data)
 }
 
-// Listens on a specified channel for set amount of time before timing out.
-func (network *Network) ListenWithTimeout(rpcID *KademliaID, sec int) (map[string]string, error) {
-	network.CreateChannel(rpcID) // makes sure it exist
+// Signs message with the identity of the network and serializes it.
+func (network *Network) serializeMessage(message *protobuf.KademliaMessage) ([]byte, error) {
+	if err := network.identity.sign(message); err != nil {
+		return nil, fmt.Errorf("serializeMessage: %w", err)
+	}
+	return protobuf.SerializeMessage(message)
+}
 
-	select {
-	case res := <-network.coms[rpcID.String()]:
-		return res, nil
+// Sends a serialized message to contact, encrypted if encryption is turned on.
+func (network *Network) sendMessage(contact *Contact, data []byte) error {
+	var err error
+	if network.channel != nil {
+		err = network.seal(contact, data)
+	} else {
+		err = network.transport.Send(contact.Address, data)
+	}
+	if err != nil {
+		return fmt.Errorf("sendMessage: %w", err)
+	}
 
-	case <-time.After(time.Duration(sec) * time.Second):
-		return nil, fmt.Errorf("timeout occured")
+	return nil
+}
 
+// Waits for the response to the request sent with rpc id until one arrives or ctx is done.
+func (network *Network) ListenForResponse(ctx context.Context, rpcID *KademliaID) (*protobuf.KademliaMessage, error) {
+	response, err := network.pending.wait(ctx, rpcID)
+	if err != nil {
+		return nil, fmt.Errorf("ListenForResponse: %w", err)
 	}
+
+	return response, nil
 }
 
-// Sends data on a specified channel.
-func (network *Network) TransmitResponse(rpcID *KademliaID, response map[string]string) {
-	network.CreateChannel(rpcID) <- response
+// Returns the counts of requests waiting for responses and of responses that matched no request.
+func (network *Network) PendingStats() PendingStats {
+	return network.pending.stats()
 }
 
-// Creates channel for rpc id if a channel does not already exist.
-func (network *Network) CreateChannel(rpcID *KademliaID) chan map[string]string {
-	mComs.Lock()
-	_, exist := network.coms[rpcID.String()]
-	if !exist {
-		network.coms[rpcID.String()] = make(chan map[string]string, 50)
-	}
-	mComs.Unlock()
+// Returns the number of messages dropped because they were not signed by their sender.
+func (network *Network) InvalidMessages() int {
+	return int(network.invalidMessages.Load())
+}
 
-	return network.coms[rpcID.String()]
+// Returns the number of messages dropped because the ID of their sender did not solve the puzzles.
+func (network *Network) InvalidPuzzles() int {
+	return int(network.invalidPuzzles.Load())
 }
 
-// Deletes channel for rpc id if it exists.
-func (network *Network) RemoveChannel(rpcID *KademliaID) {
-	mComs.Lock()
-	_, exist := network.coms[rpcID.String()]
-	if exist {
-		delete(network.coms, rpcID.String())
+// Returns the type of a message.
+func messageType(message *protobuf.KademliaMessage) string {
+	switch message.Body.(type) {
+	case *protobuf.KademliaMessage_Ping:
+		return PING
+	case *protobuf.KademliaMessage_Pong:
+		return PONG
+	case *protobuf.KademliaMessage_FindNode:
+		return FIND_NODE
+	case *protobuf.KademliaMessage_FindNodeResponse:
+		return FIND_NODE_RESPONSE
+	case *protobuf.KademliaMessage_FindValue:
+		return FIND_VALUE
+	case *protobuf.KademliaMessage_FindValueResponse:
+		return FIND_VALUE_RESPONSE
+	case *protobuf.KademliaMessage_Store:
+		return STORE
+	case *protobuf.KademliaMessage_StoreResponse:
+		return STORE_RESPONSE
+	case *protobuf.KademliaMessage_Fragment:
+		return FRAGMENT
+	case *protobuf.KademliaMessage_FragmentAck:
+		return FRAGMENT_ACK
+	default:
+		return "unknown"
 	}
-	mComs.Unlock()
 }
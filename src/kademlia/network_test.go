@@ -1,48 +1,166 @@
 package kademlia
 
 import (
+	"context"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestSendMessage(t *testing.T) {
-	me := NewContact(NewRandomKademliaID(), "172.20.0.10:80")
-	rt := NewRoutingTable(me)
-	net := NewNetwork(rt, 20, 3, time.Second*60, time.Second*30)
+	identity := NewIdentity()
+	rt := NewRoutingTable(identity.Contact("172.20.0.10:80"))
+	net, err := NewNetwork(identity, rt, 20, 3, time.Second*60, time.Second*30)
+	if err != nil {
+		t.Fatalf("NewNetwork() returned an error: %v", err)
+	}
 	contact := NewContact(NewRandomKademliaID(), "172.20.0.10:80")
-	values := make(map[string]string)
-	values["rpc_id"] = NewRandomKademliaID().String()
-	values["sender_id"] = me.ID.String()
-	values["sender_address"] = me.Address
-	values["key"] = NewRandomKademliaID().String()
-	values["data"] = "hello world!"
-	values["type"] = STORE
-
-	net.SendPingMessage(&contact, NewRandomKademliaID())
-	net.SendPongMessage(&contact, NewRandomKademliaID())
-	net.SendFindContactMessage(NewRandomKademliaID(), &contact, NewRandomKademliaID())
-	net.SendFindDataMessage(NewRandomKademliaID(), &contact, NewRandomKademliaID())
-	net.SendStoreMessage(NewRandomKademliaID(), []byte("hello world"), &contact, NewRandomKademliaID())
-	net.SendRefreshMessage(NewRandomKademliaID(), &contact, NewRandomKademliaID())
-	net.sendFindContactResponseMessage(values, &contact)
+
+	net.SendPingMessage(context.Background(), &contact, NewRandomKademliaID())
+	net.SendPongMessage(context.Background(), &contact, NewRandomKademliaID())
+	net.SendFindContactMessage(context.Background(), NewRandomKademliaID(), &contact, NewRandomKademliaID())
+	net.SendFindDataMessage(context.Background(), NewRandomKademliaID(), &contact, NewRandomKademliaID())
+	net.SendStoreMessage(context.Background(), NewRandomKademliaID(), CONTENT_RECORD, []byte("hello world"), 0, &contact, NewRandomKademliaID())
+	net.sendFindContactResponseMessage(context.Background(), NewRandomKademliaID(), &contact, NewRandomKademliaID())
+	net.sendFindDataResponseMessage(context.Background(), NewRandomKademliaID(), CONTENT_RECORD, []byte("hello world"), &contact, NewRandomKademliaID())
+}
+
+func TestUnsolicitedResponse(t *testing.T) {
+	memory := NewMemoryNetwork()
+	senderNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+
+	// Test that a response nobody asked for is dropped before the sender reaches the routing table
+	sender.SendPongMessage(context.Background(), &receiver.rt.me, NewRandomKademliaID())
+	deadline := time.Now().Add(time.Second)
+	for receiver.PendingStats().Orphaned == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if receiver.PendingStats().Orphaned != 1 {
+		t.Errorf("Expected 1 orphaned response, but got %d", receiver.PendingStats().Orphaned)
+	}
+	if len(receiver.rt.FindClosestContacts(sender.rt.me.ID, 1)) != 0 {
+		t.Error("The sender of an unsolicited response should not be added to the routing table")
+	}
+}
+
+func TestMessageType(t *testing.T) {
+	tests := []struct {
+		body     *protobuf.KademliaMessage
+		expected string
+	}{
+		{&protobuf.KademliaMessage{Body: &protobuf.KademliaMessage_Ping{Ping: &protobuf.Ping{}}}, PING},
+		{&protobuf.KademliaMessage{Body: &protobuf.KademliaMessage_FindNodeResponse{FindNodeResponse: &protobuf.FindNodeResponse{}}}, FIND_NODE_RESPONSE},
+		{&protobuf.KademliaMessage{Body: &protobuf.KademliaMessage_Store{Store: &protobuf.Store{}}}, STORE},
+		{&protobuf.KademliaMessage{}, "unknown"},
+	}
+
+	for _, test := range tests {
+		if result := messageType(test.body); result != test.expected {
+			t.Errorf("Expected messageType() to return %s, but got %s", test.expected, result)
+		}
+	}
 }
 
-func TestComs(t *testing.T) {
-	me := NewContact(NewRandomKademliaID(), "172.20.0.10:80")
-	rt := NewRoutingTable(me)
-	net := NewNetwork(rt, 20, 3, time.Second*60, time.Second*30)
+func TestStoreResponse(t *testing.T) {
+	memory := NewMemoryNetwork()
+	senderNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+	key := NewKademliaID(utils.Hash([]byte("hello world")))
+
+	// Test that the first store is confirmed
 	rpc := NewRandomKademliaID()
+	sender.SendStoreMessage(context.Background(), key, CONTENT_RECORD, []byte("hello world"), 0, &receiver.rt.me, rpc)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	response, err := sender.ListenForResponse(ctx, rpc)
+	if err != nil || !response.GetStoreResponse().GetSuccess() {
+		t.Errorf("Expected the store to be confirmed, but got %v %v", response, err)
+	}
+
+	// Test that storing data under a key that is not its hash is rejected with a reason
+	rpc = NewRandomKademliaID()
+	sender.SendStoreMessage(context.Background(), key, CONTENT_RECORD, []byte("goodbye world"), 0, &receiver.rt.me, rpc)
+	response, err = sender.ListenForResponse(ctx, rpc)
+	if err != nil || response.GetStoreResponse().GetSuccess() || !strings.HasPrefix(response.GetStoreResponse().GetReason(), ErrInvalidRecord.Error()) {
+		t.Errorf("Expected the store to be rejected as an invalid record, but got %v %v", response, err)
+	}
+	if data, _ := receiver.storage.FetchData(key.String()); string(data) != "hello world" {
+		t.Errorf("Expected the stored data to be left as it was, but got %s", string(data))
+	}
+
+	// Test that a store over the storage limits is rejected with the limit as the reason
+	receiver.storage = NewMemoryStorage(time.Minute, StorageLimits{MaxValueSize: 5})
+	rpc = NewRandomKademliaID()
+	sender.SendStoreMessage(context.Background(), key, CONTENT_RECORD, []byte("hello world"), 0, &receiver.rt.me, rpc)
+	response, err = sender.ListenForResponse(ctx, rpc)
+	if err != nil || !strings.HasPrefix(response.GetStoreResponse().GetReason(), ErrValueTooLarge.Error()) {
+		t.Errorf("Expected the store to be rejected as too large, but got %v %v", response, err)
+	}
+}
+
+func TestPingLeastRecentlySeen(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodeIdentity := NewIdentity()
+	nodeNode, err := NewMemoryNode(memory.NewTransport(), nodeIdentity, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	node := nodeNode.network
+
+	// IDs in the furthest bucket differ from the node ID in the first bit
+	furthest := func(id *KademliaID) *KademliaID {
+		id[0] = id[0]&0x7f | ^nodeIdentity.ID[0]&0x80
+		return id
+	}
+	aliveIdentity := NewIdentity()
+	for (aliveIdentity.ID[0]^nodeIdentity.ID[0])&0x80 == 0 {
+		aliveIdentity = NewIdentity()
+	}
+	aliveNode, err := NewMemoryNode(memory.NewTransport(), aliveIdentity, "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	alive := aliveNode.network
+
+	// Fill the furthest bucket with the live contact as the least recently seen one
+	node.rt.AddContact(alive.rt.me)
+	for i := 1; i < bucketSize; i++ {
+		node.rt.AddContact(NewContact(furthest(NewRandomKademliaID()), fmt.Sprintf("10.0.1.%d:80", i)))
+	}
+
+	// Test that a contact that responds to the ping is kept and the newcomer is cached
+	newcomerID := furthest(NewRandomKademliaID())
+	leastRecentlySeen := node.rt.AddContact(NewContact(newcomerID, "10.0.2.1:80"))
+	if leastRecentlySeen == nil || !leastRecentlySeen.ID.Equals(alive.rt.me.ID) {
+		t.Fatalf("Expected AddContact() to return %s, but got %v", alive.rt.me.Address, leastRecentlySeen)
+	}
+	node.pingLeastRecentlySeen(*leastRecentlySeen)
 
-	values := make(map[string]string)
-	values["rpc_id"] = NewRandomKademliaID().String()
-	values["sender_id"] = me.ID.String()
-	values["sender_address"] = me.Address
-	values["key"] = NewRandomKademliaID().String()
-	values["data"] = "hello world!"
-	values["type"] = STORE
-
-	net.ListenWithTimeout(rpc, 1)
-	net.CreateChannel(rpc) <- values
-	net.ListenWithTimeout(rpc, 1)
-	net.RemoveChannel(rpc)
+	node.rt.mu.RLock()
+	defer node.rt.mu.RUnlock()
+	bucket := node.rt.buckets[0]
+	if bucket.find(alive.rt.me.ID) == nil || bucket.find(newcomerID) != nil {
+		t.Error("Expected the responding contact to be kept and the newcomer to wait in the replacement cache")
+	}
 }
@@ -0,0 +1,196 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Time a request is kept after it was sent if nobody is waiting for its response
+const requestExpiry = 30 * time.Second
+
+// A request waiting for its response
+type pendingRequest struct {
+	recipient *KademliaID                    // The only contact allowed to respond, anyone may if nil
+	response  chan *protobuf.KademliaMessage // Holds the response until it is read
+	answered  bool
+	waiting   bool      // A listener is waiting for the response, so the request does not expire
+	expires   time.Time // Zero while the request is still being sent
+}
+
+// PendingStats counts the requests waiting for responses and the responses that never found a request
+type PendingStats struct {
+	InFlight int `json:"inFlight"` // Requests waiting for a response
+	TimedOut int `json:"timedOut"` // Requests that expired or stopped waiting without a response
+	Orphaned int `json:"orphaned"` // Responses that were unsolicited, duplicates, late or from the wrong sender
+}
+
+// pendingRequests matches incoming responses to the requests that are waiting for them
+type pendingRequests struct {
+	mu       sync.Mutex
+	requests map[string]*pendingRequest
+	timedOut int
+	orphaned int
+	done     chan struct{} // Closed to stop the cleanup task, nil while it is not running
+}
+
+// newPendingRequests returns a new instance of pendingRequests
+func newPendingRequests() *pendingRequests {
+	return &pendingRequests{requests: make(map[string]*pendingRequest)}
+}
+
+// Starts dropping expired requests periodically, until stop is called.
+func (pending *pendingRequests) start() {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	if pending.done == nil {
+		pending.done = make(chan struct{})
+		go pending.startCleanupTask(pending.done)
+	}
+}
+
+// Stops the cleanup task started by start.
+func (pending *pendingRequests) stop() {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	if pending.done != nil {
+		close(pending.done)
+		pending.done = nil
+	}
+}
+
+// Registers a request to recipient so its response can be matched. A nil recipient accepts a response from
+// any contact, for requests to an address whose ID is not known yet. Must be called before the request is sent.
+func (pending *pendingRequests) add(rpcID *KademliaID, recipient *KademliaID) {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	pending.removeExpired(time.Now())
+	pending.requests[rpcID.String()] = &pendingRequest{
+		recipient: recipient,
+		response:  make(chan *protobuf.KademliaMessage, 1),
+	}
+}
+
+// Starts the expiry of a request once it has been sent.
+func (pending *pendingRequests) sent(rpcID *KademliaID) {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	if request, exist := pending.requests[rpcID.String()]; exist {
+		request.expires = time.Now().Add(requestExpiry)
+	}
+}
+
+// Removes a request without counting it as timed out.
+func (pending *pendingRequests) remove(rpcID *KademliaID) {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	delete(pending.requests, rpcID.String())
+}
+
+// Delivers a response from sender to the request with rpc id. Returns an error, and counts the response
+// as orphaned, if no such request is pending, it was sent to someone else or it has already been answered.
+func (pending *pendingRequests) deliver(rpcID *KademliaID, sender *KademliaID, response *protobuf.KademliaMessage) error {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	request, exist := pending.requests[rpcID.String()]
+	switch {
+	case !exist:
+		pending.orphaned++
+		return fmt.Errorf("no pending request with rpc id %s", rpcID.String())
+	case request.recipient != nil && !request.recipient.Equals(sender):
+		pending.orphaned++
+		return fmt.Errorf("request %s was sent to %s", rpcID.String(), request.recipient.String())
+	case request.answered:
+		pending.orphaned++
+		return fmt.Errorf("request %s has already been answered", rpcID.String())
+	}
+
+	request.answered = true
+	request.response <- response
+	return nil
+}
+
+// Waits for the response to the request with rpc id until it arrives or ctx is done. The request is
+// removed either way.
+func (pending *pendingRequests) wait(ctx context.Context, rpcID *KademliaID) (*protobuf.KademliaMessage, error) {
+	pending.mu.Lock()
+	request, exist := pending.requests[rpcID.String()]
+	if exist {
+		request.waiting = true
+	}
+	pending.mu.Unlock()
+
+	if !exist {
+		return nil, fmt.Errorf("no pending request with rpc id %s", rpcID.String())
+	}
+	defer pending.remove(rpcID)
+
+	select {
+	case response := <-request.response:
+		return response, nil
+
+	case <-ctx.Done():
+		pending.mu.Lock()
+		pending.timedOut++
+		pending.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Returns the current counts of the registry.
+func (pending *pendingRequests) stats() PendingStats {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	pending.removeExpired(time.Now())
+	inFlight := 0
+	for _, request := range pending.requests {
+		if !request.answered {
+			inFlight++
+		}
+	}
+
+	return PendingStats{InFlight: inFlight, TimedOut: pending.timedOut, Orphaned: pending.orphaned}
+}
+
+// Periodically drops expired requests until done is closed, so that requests nobody waits for, like the
+// STORE that caches a value, do not stay in flight when no other request is added.
+func (pending *pendingRequests) startCleanupTask(done chan struct{}) {
+	ticker := time.NewTicker(requestExpiry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			pending.mu.Lock()
+			pending.removeExpired(now)
+			pending.mu.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Drops sent requests that nobody is waiting for once they expire.
+func (pending *pendingRequests) removeExpired(now time.Time) {
+	for id, request := range pending.requests {
+		if request.waiting || request.expires.IsZero() || now.Before(request.expires) {
+			continue
+		}
+
+		if !request.answered {
+			utils.Log(1, "Request %s expired without a response", id)
+			pending.timedOut++
+		}
+		delete(pending.requests, id)
+	}
+}
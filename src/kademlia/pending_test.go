@@ -0,0 +1,115 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/protobuf"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPendingRequests(t *testing.T) {
+	pending := newPendingRequests()
+	rpcID := NewRandomKademliaID()
+	recipient := NewRandomKademliaID()
+	response := &protobuf.KademliaMessage{Body: &protobuf.KademliaMessage_Pong{Pong: &protobuf.Pong{}}}
+
+	pending.add(rpcID, recipient)
+	pending.sent(rpcID)
+	if stats := pending.stats(); stats.InFlight != 1 {
+		t.Errorf("Expected 1 request in flight, but got %d", stats.InFlight)
+	}
+
+	// Test that responses that are unsolicited or from someone else are dropped
+	if err := pending.deliver(NewRandomKademliaID(), recipient, response); err == nil {
+		t.Error("deliver() should return an error for an unsolicited response")
+	}
+	if err := pending.deliver(rpcID, NewRandomKademliaID(), response); err == nil {
+		t.Error("deliver() should return an error for a response from the wrong sender")
+	}
+
+	// Test that only the first response is delivered
+	if err := pending.deliver(rpcID, recipient, response); err != nil {
+		t.Errorf("deliver() returned an error: %v", err)
+	}
+	if err := pending.deliver(rpcID, recipient, response); err == nil {
+		t.Error("deliver() should return an error for a duplicate response")
+	}
+
+	result, err := pending.wait(context.Background(), rpcID)
+	if err != nil || result != response {
+		t.Errorf("wait() did not return the delivered response, got error %v", err)
+	}
+
+	// Test that a late response is dropped once the request has been answered and removed
+	if err := pending.deliver(rpcID, recipient, response); err == nil {
+		t.Error("deliver() should return an error for a late response")
+	}
+
+	stats := pending.stats()
+	if stats.InFlight != 0 || stats.Orphaned != 4 || stats.TimedOut != 0 {
+		t.Errorf("Expected 0 in flight, 4 orphaned and 0 timed out, but got %+v", stats)
+	}
+}
+
+func TestPendingRequestsTimeout(t *testing.T) {
+	pending := newPendingRequests()
+
+	// Test that a listener that gives up counts the request as timed out
+	rpcID := NewRandomKademliaID()
+	pending.add(rpcID, NewRandomKademliaID())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pending.wait(ctx, rpcID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected wait() to return %v, but got %v", context.DeadlineExceeded, err)
+	}
+
+	// Test that requests nobody waits for expire after they have been sent
+	sent := NewRandomKademliaID()
+	sending := NewRandomKademliaID()
+	pending.add(sent, NewRandomKademliaID())
+	pending.add(sending, NewRandomKademliaID())
+	pending.sent(sent)
+	pending.mu.Lock()
+	pending.removeExpired(time.Now().Add(requestExpiry + time.Second))
+	pending.mu.Unlock()
+
+	stats := pending.stats()
+	if stats.InFlight != 1 || stats.TimedOut != 2 {
+		t.Errorf("Expected 1 in flight and 2 timed out, but got %+v", stats)
+	}
+
+	// Test that an expired request is not counted in flight even if no request was added since
+	pending.sent(sending)
+	pending.mu.Lock()
+	pending.requests[sending.String()].expires = time.Now().Add(-time.Second)
+	pending.mu.Unlock()
+	if stats := pending.stats(); stats.InFlight != 0 || stats.TimedOut != 3 {
+		t.Errorf("Expected 0 in flight and 3 timed out, but got %+v", stats)
+	}
+}
+
+func TestPendingCleanupTask(t *testing.T) {
+	memory := NewMemoryNetwork()
+	networkNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	network := networkNode.network
+
+	// Test that the cleanup task runs while the network is started and stops with it
+	network.pending.mu.Lock()
+	done := network.pending.done
+	network.pending.mu.Unlock()
+	if done == nil {
+		t.Fatal("Expected the cleanup task to run once the network is started")
+	}
+	if err := network.Stop(); err != nil {
+		t.Fatalf("Stop() returned an error: %v", err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Error("Expected the cleanup task to stop with the network")
+	}
+}
@@ -0,0 +1,122 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Returned when the ID of a node does not solve the crypto puzzles
+var ErrInvalidPuzzle = errors.New("invalid puzzle")
+
+// PuzzleDifficulty sets how much work a node ID takes, as in S/Kademlia. Each puzzle is solved by a hash
+// with at least that many leading zero bits, so every extra bit doubles the work.
+type PuzzleDifficulty struct {
+	Static  int // Leading zero bits of the hash of the ID, the key has to be generated again until it holds
+	Dynamic int // Leading zero bits of the hash of the ID XOR the puzzle solution of the node
+}
+
+// Returns whether the ID of the node with publicKey solves the static puzzle.
+func (difficulty PuzzleDifficulty) solvesStatic(publicKey ed25519.PublicKey) bool {
+	id := NodeID(publicKey)
+	hash := sha1.Sum(id[:])
+	return leadingZeroBits(hash[:]) >= difficulty.Static
+}
+
+// Returns whether solution solves the dynamic puzzle of id.
+func (difficulty PuzzleDifficulty) solvesDynamic(id *KademliaID, solution []byte) bool {
+	if difficulty.Dynamic == 0 {
+		return true
+	}
+	if len(solution) != IDLength {
+		return false
+	}
+	var mixed KademliaID
+	for i := range mixed {
+		mixed[i] = id[i] ^ solution[i]
+	}
+	hash := sha1.Sum(mixed[:])
+	return leadingZeroBits(hash[:]) >= difficulty.Dynamic
+}
+
+// Returns the number of leading zero bits of hash.
+func leadingZeroBits(hash []byte) int {
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// Checks that the ID of the node with publicKey solves both puzzles with solution. The error wraps ErrInvalidPuzzle.
+func (difficulty PuzzleDifficulty) Verify(publicKey ed25519.PublicKey, solution []byte) error {
+	if !difficulty.solvesStatic(publicKey) {
+		return fmt.Errorf("%w: ID does not solve the static puzzle of difficulty %d", ErrInvalidPuzzle, difficulty.Static)
+	}
+	if !difficulty.solvesDynamic(NodeID(publicKey), solution) {
+		return fmt.Errorf("%w: solution does not solve the dynamic puzzle of difficulty %d", ErrInvalidPuzzle, difficulty.Dynamic)
+	}
+	return nil
+}
+
+// Generates ed25519 keys until one gives an ID that solves the static puzzle.
+func (difficulty PuzzleDifficulty) GenerateKey() (ed25519.PrivateKey, error) {
+	for {
+		publicKey, privateKey, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, fmt.Errorf("GenerateKey: %w", err)
+		}
+		if difficulty.solvesStatic(publicKey) {
+			return privateKey, nil
+		}
+	}
+}
+
+// Create a new Identity instance with a newly generated key that solves both puzzles.
+func NewPuzzleIdentity(difficulty PuzzleDifficulty) (*Identity, error) {
+	privateKey, err := difficulty.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("NewPuzzleIdentity: %w", err)
+	}
+	return NewPuzzleIdentityFromKey(privateKey, difficulty)
+}
+
+// Create a new Identity instance for an existing key, solving the dynamic puzzle for it. Returns an error
+// if the key does not solve the static puzzle, which happens when the difficulty has been raised.
+func NewPuzzleIdentityFromKey(privateKey ed25519.PrivateKey, difficulty PuzzleDifficulty) (*Identity, error) {
+	identity := NewIdentityFromKey(privateKey)
+	if !difficulty.solvesStatic(privateKey.Public().(ed25519.PublicKey)) {
+		return nil, fmt.Errorf("NewPuzzleIdentityFromKey: %w: ID %s does not solve the static puzzle of difficulty %d", ErrInvalidPuzzle, identity.ID.String(), difficulty.Static)
+	}
+	if difficulty.Dynamic == 0 {
+		return identity, nil
+	}
+
+	solution := make([]byte, IDLength)
+	if _, err := rand.Read(solution); err != nil {
+		return nil, fmt.Errorf("NewPuzzleIdentityFromKey: could not generate solution %w", err)
+	}
+	for !difficulty.solvesDynamic(identity.ID, solution) {
+		// Count the solution up as a big-endian number
+		for i := len(solution) - 1; i >= 0; i-- {
+			solution[i]++
+			if solution[i] != 0 {
+				break
+			}
+		}
+	}
+	identity.PuzzleSolution = solution
+	return identity, nil
+}
+
+// Sets the difficulty of the puzzles the IDs of other nodes must solve. Messages from nodes whose ID
+// does not solve them are dropped before the sender reaches the routing table. Zero accepts every ID.
+func (network *Network) RequirePuzzles(difficulty PuzzleDifficulty) {
+	network.puzzle = difficulty
+}
@@ -0,0 +1,106 @@
+package kademlia
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPuzzleIdentity(t *testing.T) {
+	difficulty := PuzzleDifficulty{Static: 4, Dynamic: 8}
+	identity, err := NewPuzzleIdentity(difficulty)
+	if err != nil {
+		t.Fatalf("NewPuzzleIdentity() returned an error: %v", err)
+	}
+	publicKey := identity.PrivateKey.Public().(ed25519.PublicKey)
+	if err := difficulty.Verify(publicKey, identity.PuzzleSolution); err != nil {
+		t.Errorf("Expected the identity to solve the puzzles, but got %v", err)
+	}
+
+	// Test that a missing solution does not solve the dynamic puzzle
+	if err := difficulty.Verify(publicKey, nil); !errors.Is(err, ErrInvalidPuzzle) {
+		t.Errorf("Expected a missing solution to be invalid, but got %v", err)
+	}
+
+	// Test that a key whose ID does not solve the static puzzle is refused
+	var key ed25519.PrivateKey
+	for key == nil || difficulty.solvesStatic(key.Public().(ed25519.PublicKey)) {
+		_, key, _ = ed25519.GenerateKey(nil)
+	}
+	if _, err := NewPuzzleIdentityFromKey(key, difficulty); !errors.Is(err, ErrInvalidPuzzle) {
+		t.Errorf("Expected a key that does not solve the static puzzle to be refused, but got %v", err)
+	}
+	if err := difficulty.Verify(key.Public().(ed25519.PublicKey), identity.PuzzleSolution); !errors.Is(err, ErrInvalidPuzzle) {
+		t.Errorf("Expected an ID that does not solve the static puzzle to be invalid, but got %v", err)
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := map[int][]byte{
+		0:  {0x80, 0x00},
+		3:  {0x10, 0xff},
+		8:  {0x00, 0xff},
+		15: {0x00, 0x01},
+		16: {0x00, 0x00},
+	}
+	for expected, hash := range tests {
+		if zeros := leadingZeroBits(hash); zeros != expected {
+			t.Errorf("Expected %d leading zero bits in %x, but got %d", expected, hash, zeros)
+		}
+	}
+}
+
+func TestPuzzleRequired(t *testing.T) {
+	memory := NewMemoryNetwork()
+	difficulty := PuzzleDifficulty{Static: 4, Dynamic: 4}
+	receiverNode, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver := receiverNode.network
+	receiver.RequirePuzzles(difficulty)
+
+	// Test that a message from an ID that took no work is dropped before the sender reaches the routing table
+	var cheap *Identity
+	for cheap == nil || difficulty.solvesStatic(cheap.PrivateKey.Public().(ed25519.PublicKey)) {
+		cheap = NewIdentity()
+	}
+	senderNode, err := NewMemoryNode(memory.NewTransport(), cheap, "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender := senderNode.network
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	rpcID := NewRandomKademliaID()
+	sender.SendPingMessage(ctx, &receiver.rt.me, rpcID)
+	if _, err := sender.ListenForResponse(ctx, rpcID); err == nil {
+		t.Error("Expected no response to a node whose ID does not solve the puzzles")
+	}
+	if receiver.InvalidPuzzles() != 1 || receiver.rt.NumContacts() != 0 {
+		t.Errorf("Expected the message to be counted and the sender not to be added, but got %d and %d contacts", receiver.InvalidPuzzles(), receiver.rt.NumContacts())
+	}
+
+	// Test that a node whose ID solves the puzzles is answered and added
+	solved, err := NewPuzzleIdentity(difficulty)
+	if err != nil {
+		t.Fatalf("NewPuzzleIdentity() returned an error: %v", err)
+	}
+	senderNode, err = NewMemoryNode(memory.NewTransport(), solved, "10.0.0.3")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender = senderNode.network
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rpcID = NewRandomKademliaID()
+	sender.SendPingMessage(ctx, &receiver.rt.me, rpcID)
+	if _, err := sender.ListenForResponse(ctx, rpcID); err != nil {
+		t.Errorf("Expected a response to a node whose ID solves the puzzles, but got %v", err)
+	}
+	if contacts := receiver.rt.FindClosestContacts(solved.ID, 1); len(contacts) != 1 || !contacts[0].ID.Equals(solved.ID) {
+		t.Error("Expected the node whose ID solves the puzzles to be added to the routing table")
+	}
+}
@@ -0,0 +1,88 @@
+package kademlia
+
+import (
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// RecordKind tells how the key of a stored record relates to its data, and so how the record is validated
+type RecordKind uint8
+
+// Defines the kinds of records that can be stored.
+const (
+	CONTENT_RECORD RecordKind = 0 // The key is the hash of the data
+	SHARD_RECORD   RecordKind = 1 // The data is an erasure coded shard and the key is derived from its object and index
+	MUTABLE_RECORD RecordKind = 2 // The data is a signed record and the key is the hash of its public key
+)
+
+// Returned when a record does not pass the validation of its kind
+var ErrInvalidRecord = errors.New("invalid record")
+
+// Returned when a record may not replace the record already stored under its key
+var ErrRecordExists = errors.New("record exists")
+
+// RecordValidator returns an error if data may not be stored under key
+type RecordValidator func(key *KademliaID, data []byte) error
+
+// RecordReplacer returns an error if data may not replace stored, both valid records under the same key
+type RecordReplacer func(stored []byte, data []byte) error
+
+// The validation rules of every kind of record. Records of kinds that are not in here are rejected.
+var recordValidators = map[RecordKind]RecordValidator{
+	CONTENT_RECORD: validateContentRecord,
+	SHARD_RECORD:   validateShardRecord,
+	MUTABLE_RECORD: validateMutableRecord,
+}
+
+// The rules for replacing the records of every mutable kind. Records of kinds that are not in here never change.
+var recordReplacers = map[RecordKind]RecordReplacer{
+	MUTABLE_RECORD: replaceMutableRecord,
+}
+
+// Validates a record of kind stored under key. The error wraps ErrInvalidRecord.
+func ValidateRecord(kind RecordKind, key *KademliaID, data []byte) error {
+	validator, exist := recordValidators[kind]
+	if !exist {
+		return fmt.Errorf("%w: unknown record kind %d", ErrInvalidRecord, kind)
+	}
+	return validator(key, data)
+}
+
+// Returns the kind of a record received in a message. Kinds that do not fit in a RecordKind are invalid,
+// instead of wrapping around to a kind that exists. The error wraps ErrInvalidRecord.
+func recordKindFromMessage(kind protobuf.RecordKind) (RecordKind, error) {
+	if kind < 0 || kind > math.MaxUint8 {
+		return 0, fmt.Errorf("%w: unknown record kind %d", ErrInvalidRecord, kind)
+	}
+	return RecordKind(kind), nil
+}
+
+// Validates a record of kind, as received in a message, stored under key. The error wraps ErrInvalidRecord.
+func validateMessageRecord(kind protobuf.RecordKind, key *KademliaID, data []byte) (RecordKind, error) {
+	recordKind, err := recordKindFromMessage(kind)
+	if err != nil {
+		return 0, err
+	}
+	return recordKind, ValidateRecord(recordKind, key, data)
+}
+
+// Checks whether a record of kind with data may replace the different data stored under the same key.
+// The error wraps ErrRecordExists.
+func ReplaceRecord(kind RecordKind, stored []byte, data []byte) error {
+	replacer, exist := recordReplacers[kind]
+	if !exist {
+		return fmt.Errorf("%w: the key already stores different data", ErrRecordExists)
+	}
+	return replacer(stored, data)
+}
+
+// Content records must be stored under the hash of their data.
+func validateContentRecord(key *KademliaID, data []byte) error {
+	if utils.Hash(data) != key.String() {
+		return fmt.Errorf("%w: key %s is not the hash of the data", ErrInvalidRecord, key.String())
+	}
+	return nil
+}
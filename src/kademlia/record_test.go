@@ -0,0 +1,81 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestValidateRecord(t *testing.T) {
+	data := []byte("hello world")
+	key := NewKademliaID(utils.Hash(data))
+
+	if err := ValidateRecord(CONTENT_RECORD, key, data); err != nil {
+		t.Errorf("Expected a content record under its hash to be valid, but got %v", err)
+	}
+	if err := ValidateRecord(CONTENT_RECORD, NewRandomKademliaID(), data); !errors.Is(err, ErrInvalidRecord) {
+		t.Errorf("Expected ErrInvalidRecord for a content record under another key, but got %v", err)
+	}
+	if err := ValidateRecord(RecordKind(200), key, data); !errors.Is(err, ErrInvalidRecord) {
+		t.Errorf("Expected ErrInvalidRecord for an unknown kind, but got %v", err)
+	}
+
+	// Test that kinds in messages that do not fit in a RecordKind do not wrap around to a kind that exists
+	for _, kind := range []protobuf.RecordKind{256, 258, -1} {
+		if _, err := validateMessageRecord(kind, key, data); !errors.Is(err, ErrInvalidRecord) {
+			t.Errorf("Expected ErrInvalidRecord for kind %d, but got %v", kind, err)
+		}
+	}
+}
+
+func TestTypedRecord(t *testing.T) {
+	// A kind whose records may be stored under any key as long as the data is not empty
+	const testKind RecordKind = 100
+	recordValidators[testKind] = func(key *KademliaID, data []byte) error {
+		if len(data) == 0 {
+			return fmt.Errorf("%w: empty", ErrInvalidRecord)
+		}
+		return nil
+	}
+	defer delete(recordValidators, testKind)
+
+	memory := NewMemoryNetwork()
+	sender, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	receiver, err := NewMemoryNode(memory.NewTransport(), NewIdentity(), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	sender.network.rt.AddContact(receiver.network.rt.me)
+	key := NewRandomKademliaID()
+
+	// Test that a record of another kind is stored under a key that is not its hash
+	if !sender.storeAt(context.Background(), key, testKind, []byte("not content"), 0, &receiver.network.rt.me) {
+		t.Fatal("Expected the typed record to be stored")
+	}
+	if kind := receiver.network.storage.Kind(key.String()); kind != testKind {
+		t.Errorf("Expected the record to be stored as kind %d, but got %d", testKind, kind)
+	}
+
+	// Test that the kind is returned with the value and a lookup accepts it
+	_, data, err := sender.nodeLookup(context.Background(), key, FIND_VALUE)
+	if err != nil || string(data) != "not content" {
+		t.Errorf("Expected the lookup to return the typed record, but got %s %v", string(data), err)
+	}
+
+	// Test that the rules of the kind are applied
+	if sender.storeAt(context.Background(), NewRandomKademliaID(), testKind, []byte{}, 0, &receiver.network.rt.me) {
+		t.Error("Expected a record that breaks the rules of its kind to be rejected")
+	}
+
+	// Test that republished replicas keep their kind
+	values := receiver.network.storage.ValuesStoredBefore(0)
+	if len(values) != 1 || values[0].Kind != testKind {
+		t.Errorf("Expected the replica to keep its kind, but got %v", values)
+	}
+}
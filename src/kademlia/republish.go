@@ -0,0 +1,81 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/utils"
+	"time"
+)
+
+// Number of times per interval values are checked for republishing
+const republishChecks = 4
+
+// A value this node is the original publisher of
+type publishedValue struct {
+	data          []byte
+	kind          RecordKind
+	lastPublished time.Time
+}
+
+// Returns how often values are checked for republishing. Values are republished at the last check
+// before their interval has passed, so they are never republished late.
+func republishCheckPeriod(interval time.Duration) time.Duration {
+	if interval < republishChecks {
+		return interval
+	}
+	return interval / republishChecks
+}
+
+// Republishes the values this node published to the contacts that are currently closest to them.
+// Values published within the last refreshInterval are skipped, unless they would be late by the next check.
+func (kademlia *Kademlia) republishOriginals(ctx context.Context) {
+	interval := kademlia.network.refreshInterval
+	skipNewerThan := interval - republishCheckPeriod(interval)
+
+	kademlia.mu.Lock()
+	due := make(map[string]publishedValue)
+	for hash, value := range kademlia.published {
+		if time.Since(value.lastPublished) >= skipNewerThan {
+			due[hash] = *value
+		}
+	}
+	kademlia.mu.Unlock()
+
+	for hash, value := range due {
+		replicas, err := kademlia.storeOnClosest(ctx, NewKademliaID(hash), value.kind, value.data, 0)
+		if err != nil {
+			utils.LogError("republishOriginals: %s %s", hash, err)
+			continue
+		}
+		utils.Log(1, "Republished data with hash %s to %d contacts", hash, len(replicas))
+
+		kademlia.mu.Lock()
+		if value, exist := kademlia.published[hash]; exist {
+			value.lastPublished = time.Now()
+		}
+		kademlia.mu.Unlock()
+	}
+}
+
+// Republishes the values stored on this node to the contacts that are currently closest to them, keeping
+// the time they expire. Values that were stored or republished within the last ReplicateInterval are skipped,
+// since the node that did so has already sent them to the other closest contacts.
+func (kademlia *Kademlia) republishReplicas(ctx context.Context) {
+	interval := kademlia.ReplicateInterval
+	due := kademlia.network.storage.ValuesStoredBefore(interval - republishCheckPeriod(interval))
+
+	for _, value := range due {
+		// The TTL is sent in milliseconds, and zero would give the value a new default TTL
+		ttl := time.Until(value.Expires)
+		if ttl < time.Millisecond {
+			continue
+		}
+
+		replicas, err := kademlia.storeOnClosest(ctx, NewKademliaID(value.Key), value.Kind, value.Data, ttl)
+		if err != nil {
+			utils.LogError("republishReplicas: %s %s", value.Key, err)
+			continue
+		}
+		kademlia.network.storage.MarkRepublished(value.Key)
+		utils.Log(1, "Republished replica with key %s to %d contacts", value.Key, len(replicas))
+	}
+}
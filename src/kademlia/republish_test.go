@@ -0,0 +1,110 @@
+package kademlia
+
+import (
+	"context"
+	"d7024e/utils"
+	"testing"
+	"time"
+)
+
+// Returns an identity with an ID closer to target than the IDs of all nodes.
+func closerIdentity(target *KademliaID, nodes []*Kademlia) *Identity {
+	for {
+		identity := NewIdentity()
+		closer := true
+		for _, node := range nodes {
+			if !identity.ID.CalcDistance(target).Less(node.network.rt.me.ID.CalcDistance(target)) {
+				closer = false
+			}
+		}
+		if closer {
+			return identity
+		}
+	}
+}
+
+func TestRepublishOriginals(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodes, err := memory.NewCluster(5)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+
+	data := []byte("republish me")
+	hash, _, err := nodes[1].Store(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Store() returned an error: %v", err)
+	}
+
+	// A node joins that is closer to the value than anyone else
+	closest, err := NewMemoryNode(memory.NewTransport(), closerIdentity(NewKademliaID(hash), nodes), "10.0.1.1")
+	if err != nil {
+		t.Fatalf("NewMemoryNode() returned an error: %v", err)
+	}
+	if err := closest.JoinNetwork(context.Background(), &nodes[0].network.rt.me); err != nil {
+		t.Fatalf("JoinNetwork() returned an error: %v", err)
+	}
+
+	// Test that a value that was published recently is skipped
+	nodes[1].republishOriginals(context.Background())
+	if _, exist := closest.network.storage.FetchData(hash); exist {
+		t.Fatal("A value published recently should not be republished")
+	}
+
+	// Test that a value that is due is republished to the contacts that are closest now
+	nodes[1].published[hash].lastPublished = time.Now().Add(-nodes[1].network.refreshInterval)
+	nodes[1].republishOriginals(context.Background())
+	if _, exist := closest.network.storage.FetchData(hash); !exist {
+		t.Error("Expected the value to be republished to the new closest node")
+	}
+
+	// Test that forgotten values are no longer republished
+	nodes[1].Forget(hash)
+	if _, exist := nodes[1].published[hash]; exist {
+		t.Error("Expected Forget() to stop republishing the value")
+	}
+}
+
+func TestRepublishReplicas(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodes, err := memory.NewCluster(5)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+
+	// Only one node holds the value, as if the others had left and been replaced
+	data := []byte("replicate me")
+	key := utils.Hash(data)
+	nodes[2].network.storage.StoreData(key, data, time.Minute)
+	expires := time.Now().Add(time.Minute)
+
+	// Test that a value that was stored recently is skipped
+	nodes[2].republishReplicas(context.Background())
+	if _, exist := nodes[3].network.storage.FetchData(key); exist {
+		t.Fatal("A value stored recently should not be republished")
+	}
+
+	// Test that a value that is due is republished and keeps its expiry time
+	nodes[2].ReplicateInterval = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	nodes[2].republishReplicas(context.Background())
+	for _, node := range nodes {
+		if node == nodes[2] {
+			continue
+		}
+
+		stored, _, exist := node.network.storage.(*MemoryStorage).entry(key)
+		if !exist {
+			t.Errorf("Expected the value to be republished to %s", node.network.rt.me.Address)
+			continue
+		}
+		if stored.Expires.After(expires.Add(time.Second)) {
+			t.Errorf("Expected the republished value to expire at %v, but it expires at %v", expires, stored.Expires)
+		}
+
+		// The receivers skip the value since it was just republished to them
+		if values := node.network.storage.ValuesStoredBefore(time.Second); len(values) != 0 {
+			t.Errorf("Expected %s to skip the value it just received", node.network.rt.me.Address)
+		}
+	}
+}
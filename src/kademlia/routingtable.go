@@ -1,33 +1,94 @@
 package kademlia
 
+import (
+	"sync"
+	"time"
+)
+
 const bucketSize = 20
 
 // RoutingTable definition
 // keeps a refrence contact of me and an array of buckets
 type RoutingTable struct {
-	me      Contact
-	buckets [IDLength * 8]*bucket
+	mu          sync.RWMutex
+	me          Contact
+	buckets     [IDLength * 8]*bucket
+	misbehaving map[string]int // Times each contact misbehaved, kept after it leaves the table
+	diversity   *ipDiversity   // Contacts per IP and subnet, shared by the buckets
 }
 
 // NewRoutingTable returns a new instance of a RoutingTable
 func NewRoutingTable(me Contact) *RoutingTable {
 	routingTable := &RoutingTable{}
+	routingTable.diversity = newIPDiversity(IPLimits{})
 	for i := 0; i < IDLength*8; i++ {
 		routingTable.buckets[i] = newBucket()
+		routingTable.buckets[i].diversity = routingTable.diversity
 	}
 	routingTable.me = me
+	routingTable.misbehaving = make(map[string]int)
 	return routingTable
 }
 
-// AddContact add a new contact to the correct Bucket
-func (routingTable *RoutingTable) AddContact(contact Contact) {
+// AddContact add a new contact to the correct Bucket. If the Bucket is full, the least
+// recently seen contact in it is returned so it can be pinged, otherwise nil is returned.
+func (routingTable *RoutingTable) AddContact(contact Contact) *Contact {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+
 	bucketIndex := routingTable.getBucketIndex(contact.ID)
 	bucket := routingTable.buckets[bucketIndex]
-	bucket.AddContact(contact)
+	leastRecentlySeen := bucket.AddContact(contact)
+
+	// Misbehaving contacts stay first in line to be replaced, however recently they were seen
+	if routingTable.misbehaving[contact.ID.String()] > 0 {
+		bucket.MarkStale(contact)
+	}
+	return leastRecentlySeen
+}
+
+// RemoveContact removes a contact that did not respond from its Bucket and
+// replaces it with a contact from the replacement cache
+func (routingTable *RoutingTable) RemoveContact(contact Contact) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+
+	routingTable.buckets[routingTable.getBucketIndex(contact.ID)].RemoveContact(contact)
+}
+
+// MarkStale marks a contact that failed to respond to an RPC as stale
+func (routingTable *RoutingTable) MarkStale(contact Contact) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+
+	routingTable.buckets[routingTable.getBucketIndex(contact.ID)].MarkStale(contact)
+}
+
+// ReportMisbehavior counts a contact that answered with invalid data and marks it as stale,
+// so it is replaced as soon as there is a contact to replace it with. Returns the number of
+// times the contact has misbehaved.
+func (routingTable *RoutingTable) ReportMisbehavior(contact Contact) int {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+
+	routingTable.misbehaving[contact.ID.String()]++
+	routingTable.buckets[routingTable.getBucketIndex(contact.ID)].MarkStale(contact)
+	return routingTable.misbehaving[contact.ID.String()]
+}
+
+// NumMisbehaving returns the number of contacts that have misbehaved
+func (routingTable *RoutingTable) NumMisbehaving() int {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+
+	return len(routingTable.misbehaving)
 }
 
 // FindClosestContacts finds the count closest Contacts to the target in the RoutingTable
 func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count int) []Contact {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+
 	var candidates ContactCandidates
 	bucketIndex := routingTable.getBucketIndex(target)
 	bucket := routingTable.buckets[bucketIndex]
@@ -54,6 +115,49 @@ func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count
 	return candidates.GetContacts(count)
 }
 
+// MarkLookup records that a lookup was done for target in the Bucket target falls into
+func (routingTable *RoutingTable) MarkLookup(target *KademliaID) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+
+	routingTable.buckets[routingTable.getBucketIndex(target)].lastLookup = time.Now()
+}
+
+// IdleBuckets returns the indexes of the Buckets no further in than the Bucket of the
+// closest contact that have not had a lookup for longer than interval
+func (routingTable *RoutingTable) IdleBuckets(interval time.Duration) []int {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+
+	// Buckets closer than our closest contact are empty and stay that way
+	closestIndex := -1
+	for i, bucket := range routingTable.buckets {
+		if bucket.Len() > 0 {
+			closestIndex = i
+		}
+	}
+
+	idle := []int{}
+	for i := 0; i <= closestIndex; i++ {
+		if time.Since(routingTable.buckets[i].lastLookup) > interval {
+			idle = append(idle, i)
+		}
+	}
+	return idle
+}
+
+// NumContacts returns the number of contacts in the RoutingTable
+func (routingTable *RoutingTable) NumContacts() int {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+
+	count := 0
+	for _, bucket := range routingTable.buckets {
+		count += bucket.Len()
+	}
+	return count
+}
+
 // getBucketIndex get the correct Bucket index for the KademliaID
 func (routingTable *RoutingTable) getBucketIndex(id *KademliaID) int {
 	distance := id.CalcDistance(routingTable.me.ID)
@@ -67,3 +171,20 @@ func (routingTable *RoutingTable) getBucketIndex(id *KademliaID) int {
 
 	return IDLength*8 - 1
 }
+
+// RandomIDInBucket returns a random KademliaID that falls into the Bucket at bucketIndex
+func (routingTable *RoutingTable) RandomIDInBucket(bucketIndex int) *KademliaID {
+	id := NewRandomKademliaID()
+	me := routingTable.me.ID
+	byteIndex, bit := bucketIndex/8, uint8(7-bucketIndex%8)
+
+	// Share the prefix with me, differ at the bucket bit and keep the remaining bits random
+	for i := 0; i < byteIndex; i++ {
+		id[i] = me[i]
+	}
+	prefixMask := byte(0xFF) << (bit + 1)
+	bucketBit := byte(1) << bit
+	id[byteIndex] = (me[byteIndex] & prefixMask) | (^me[byteIndex] & bucketBit) | (id[byteIndex] & (bucketBit - 1))
+
+	return id
+}
@@ -3,6 +3,7 @@ package kademlia
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestRoutingTable(t *testing.T) {
@@ -20,3 +21,44 @@ func TestRoutingTable(t *testing.T) {
 		fmt.Println(contacts[i].String())
 	}
 }
+
+func TestRandomIDInBucket(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewRandomKademliaID(), "localhost:8000"))
+
+	for bucketIndex := 0; bucketIndex < IDLength*8; bucketIndex++ {
+		id := rt.RandomIDInBucket(bucketIndex)
+		if rt.getBucketIndex(id) != bucketIndex {
+			t.Errorf("Expected random ID %s to fall into bucket %d, but it falls into bucket %d", id.String(), bucketIndex, rt.getBucketIndex(id))
+		}
+	}
+}
+
+func TestIdleBuckets(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID("0000000000000000000000000000000000000000"), "localhost:8000"))
+
+	// Test that an empty routing table has nothing to refresh
+	if idle := rt.IdleBuckets(0); len(idle) != 0 {
+		t.Errorf("Expected no idle buckets in an empty routing table, but got %v", idle)
+	}
+
+	// Only buckets up to the one holding the closest contact are refreshed
+	rt.AddContact(NewContact(NewKademliaID("0100000000000000000000000000000000000000"), "localhost:8001"))
+	idle := rt.IdleBuckets(0)
+	if len(idle) != 8 {
+		t.Fatalf("Expected buckets 0 to 7 to be idle, but got %v", idle)
+	}
+
+	// Test that a lookup in a bucket keeps it from being idle
+	rt.MarkLookup(rt.RandomIDInBucket(3))
+	idle = rt.IdleBuckets(time.Minute)
+	if len(idle) != 0 {
+		t.Errorf("Expected no buckets to be idle for longer than a minute, but got %v", idle)
+	}
+	time.Sleep(10 * time.Millisecond)
+	rt.MarkLookup(rt.RandomIDInBucket(3))
+	for _, bucketIndex := range rt.IdleBuckets(5 * time.Millisecond) {
+		if bucketIndex == 3 {
+			t.Error("Bucket 3 should not be idle right after a lookup")
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package kademlia
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"d7024e/erasure"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default number of contacts each shard of an erasure coded object is stored on
+const defaultShardReplicas = 2
+
+// Returns the key shard index of the object with hash is stored under. The keys are hashes, so the
+// shards of an object end up near different parts of the keyspace and on different nodes.
+func ShardKey(hash string, index int) *KademliaID {
+	return NewKademliaID(utils.Hash([]byte(fmt.Sprintf("%s/%d", hash, index))))
+}
+
+// Turns on erasure coding. Objects are then stored as total shards, any required of which rebuild them,
+// instead of being stored whole on the k closest contacts.
+func (kademlia *Kademlia) EnableErasureCoding(required int, total int) error {
+	encoder, err := erasure.NewEncoder(required, total)
+	if err != nil {
+		return fmt.Errorf("EnableErasureCoding: %w", err)
+	}
+	kademlia.encoder = encoder
+	return nil
+}
+
+// Shard records must be valid shards stored under the key derived from their object and index,
+// and hold the data listed in their shard hashes.
+func validateShardRecord(key *KademliaID, data []byte) error {
+	shard, err := protobuf.DeserializeShard(data)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	if len(shard.Object) != IDLength || len(shard.ShardHashes) != int(shard.Total) {
+		return fmt.Errorf("%w: shard of %d bytes object hash with %d of %d shard hashes", ErrInvalidRecord, len(shard.Object), len(shard.ShardHashes), shard.Total)
+	}
+	if shard.Required == 0 || shard.Total < shard.Required || shard.Total > 256 || shard.Index >= shard.Total {
+		return fmt.Errorf("%w: shard %d of %d with %d required", ErrInvalidRecord, shard.Index, shard.Total, shard.Required)
+	}
+	if !ShardKey(hex.EncodeToString(shard.Object), int(shard.Index)).Equals(key) {
+		return fmt.Errorf("%w: key %s is not derived from the object and index of the shard", ErrInvalidRecord, key.String())
+	}
+	if hash := sha1.Sum(shard.Data); !bytes.Equal(hash[:], shard.ShardHashes[shard.Index]) {
+		return fmt.Errorf("%w: shard data does not match its hash", ErrInvalidRecord)
+	}
+	if size := (shard.Size + uint64(shard.Required) - 1) / uint64(shard.Required); uint64(len(shard.Data)) != size {
+		return fmt.Errorf("%w: shard has %d bytes, expected %d", ErrInvalidRecord, len(shard.Data), size)
+	}
+	return nil
+}
+
+// Erasure codes data and stores every shard on the ShardReplicas closest contacts to its key. Returns
+// the hash of the data and the number of shards stored on at least one contact.
+func (kademlia *Kademlia) storeErasureCoded(ctx context.Context, data []byte) (string, int, error) {
+	encoder := kademlia.encoder
+	hash := utils.Hash(data)
+	object, _ := hex.DecodeString(hash)
+	shards := encoder.Encode(data)
+	utils.Log(1, "Storing %d bytes as %d shards, %d required", len(data), encoder.Total(), encoder.Required())
+
+	shardHashes := make([][]byte, len(shards))
+	for index, shard := range shards {
+		shardHash := sha1.Sum(shard)
+		shardHashes[index] = shardHash[:]
+	}
+
+	type storedShard struct {
+		key      *KademliaID
+		record   []byte
+		replicas []Contact
+	}
+	stored := make([]storedShard, len(shards))
+	var stores sync.WaitGroup
+	for index, shard := range shards {
+		record, err := protobuf.SerializeShard(&protobuf.Shard{
+			Object:      object,
+			Index:       uint32(index),
+			Total:       uint32(encoder.Total()),
+			Required:    uint32(encoder.Required()),
+			Size:        uint64(len(data)),
+			ShardHashes: shardHashes,
+			Data:        shard,
+		})
+		if err != nil {
+			return hash, 0, fmt.Errorf("Store: %w", err)
+		}
+		stored[index] = storedShard{key: ShardKey(hash, index), record: record}
+
+		stores.Add(1)
+		go func(index int) {
+			defer stores.Done()
+			replicas, err := kademlia.storeOnClosest(ctx, stored[index].key, SHARD_RECORD, stored[index].record, 0)
+			if err != nil {
+				utils.LogError("Store: shard %d: %s", index, err)
+			}
+			stored[index].replicas = replicas
+		}(index)
+	}
+	stores.Wait()
+
+	// Every shard is republished on its own
+	storedShards := 0
+	kademlia.mu.Lock()
+	for _, shard := range stored {
+		if len(shard.replicas) > 0 {
+			storedShards++
+		}
+		kademlia.published[shard.key.String()] = &publishedValue{shard.record, SHARD_RECORD, time.Now()}
+	}
+	kademlia.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return hash, storedShards, fmt.Errorf("Store: %w", ctx.Err())
+	}
+	if storedShards < encoder.Required() {
+		return hash, storedShards, fmt.Errorf("Store: %w (%d of %d shards stored, %d required)", ErrInsufficientReplicas, storedShards, encoder.Total(), encoder.Required())
+	}
+	if storedShards < encoder.Total() {
+		utils.Log(2, "Only %d of %d shards of %s were stored", storedShards, encoder.Total(), hash)
+	}
+	return hash, storedShards, nil
+}
+
+// Looks up the shards of the object with hash in parallel and rebuilds it as soon as enough shards that
+// agree with each other have been found. Returns nil if the object could not be rebuilt.
+func (kademlia *Kademlia) lookupErasureCoded(ctx context.Context, hash string) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	total := kademlia.encoder.Total()
+	found := make(chan *protobuf.Shard, total)
+	for index := 0; index < total; index++ {
+		go func(key *KademliaID) {
+			_, data, err := kademlia.nodeLookup(ctx, key, FIND_VALUE)
+			if err != nil || data == nil {
+				found <- nil
+				return
+			}
+			// A content record can be stored under any key, so the data is checked to be a shard
+			if err := validateShardRecord(key, data); err != nil {
+				utils.LogError("lookupErasureCoded: %s", err)
+				found <- nil
+				return
+			}
+			shard, _ := protobuf.DeserializeShard(data)
+			found <- shard
+		}(ShardKey(hash, index))
+	}
+
+	// Shards are grouped by how they say the object was encoded, so that a shard that lies about it
+	// cannot spoil the shards that tell the truth
+	groups := make(map[string][][]byte)
+	for received := 0; received < total; received++ {
+		var shard *protobuf.Shard
+		select {
+		case shard = <-found:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("LookupData: %w", ctx.Err())
+		}
+		if shard == nil {
+			continue
+		}
+		if shard.Data == nil {
+			shard.Data = []byte{} // The shards of an empty object are empty, but not missing
+		}
+
+		encoding := shardEncoding(shard)
+		group, exist := groups[encoding]
+		if !exist {
+			group = make([][]byte, shard.Total)
+		}
+		if group == nil {
+			continue
+		}
+		group[shard.Index] = shard.Data
+		groups[encoding] = group
+
+		data, err := rebuildObject(shard, group)
+		if err != nil {
+			continue
+		}
+		if utils.Hash(data) != hash {
+			utils.LogError("lookupErasureCoded: shards of %s rebuild data with hash %s", hash, utils.Hash(data))
+			groups[encoding] = nil
+			continue
+		}
+		utils.Log(1, "Rebuilt %d bytes of %s from %d of %d shards", len(data), hash, shard.Required, shard.Total)
+		return data, nil
+	}
+	return nil, nil
+}
+
+// Returns a string that identifies how the object of shard was encoded.
+func shardEncoding(shard *protobuf.Shard) string {
+	return fmt.Sprintf("%d/%d/%d/%x", shard.Required, shard.Total, shard.Size, bytes.Join(shard.ShardHashes, nil))
+}
+
+// Rebuilds the object of shard from the shards in group, which are held by index with nil for those that
+// have not been found. Returns an error if there are not enough shards yet.
+func rebuildObject(shard *protobuf.Shard, group [][]byte) ([]byte, error) {
+	present := 0
+	for _, data := range group {
+		if data != nil {
+			present++
+		}
+	}
+	if present < int(shard.Required) {
+		return nil, erasure.ErrTooFewShards
+	}
+
+	encoder, err := erasure.NewEncoder(int(shard.Required), int(shard.Total))
+	if err != nil {
+		return nil, err
+	}
+	return encoder.Decode(group, int(shard.Size))
+}
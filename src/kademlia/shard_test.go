@@ -0,0 +1,105 @@
+package kademlia
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"d7024e/protobuf"
+	"d7024e/utils"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// Returns a serialized shard with the given data that claims to be shard index of the object with hash.
+func newTestShard(hash string, index int, data []byte) []byte {
+	object, _ := hex.DecodeString(hash)
+	shardHash := sha1.Sum(data)
+	shardHashes := [][]byte{make([]byte, 20), make([]byte, 20)}
+	shardHashes[index] = shardHash[:]
+	record, _ := protobuf.SerializeShard(&protobuf.Shard{Object: object, Index: uint32(index), Total: 2, Required: 1, Size: uint64(len(data)), ShardHashes: shardHashes, Data: data})
+	return record
+}
+
+func TestValidateShardRecord(t *testing.T) {
+	hash := utils.Hash([]byte("object"))
+	record := newTestShard(hash, 1, []byte("shard"))
+	if err := ValidateRecord(SHARD_RECORD, ShardKey(hash, 1), record); err != nil {
+		t.Errorf("Expected a shard under its derived key to be valid, but got %v", err)
+	}
+
+	tests := map[string]struct {
+		key    *KademliaID
+		record []byte
+	}{
+		"another index":  {ShardKey(hash, 0), record},
+		"another object": {ShardKey(utils.Hash([]byte("other")), 1), record},
+		"not a shard":    {ShardKey(hash, 1), []byte("shard")},
+		"tampered data":  {ShardKey(hash, 1), bytes.Replace(record, []byte("shard"), []byte("SHARD"), 1)},
+	}
+	for name, test := range tests {
+		if err := ValidateRecord(SHARD_RECORD, test.key, test.record); !errors.Is(err, ErrInvalidRecord) {
+			t.Errorf("%s: expected ErrInvalidRecord, but got %v", name, err)
+		}
+	}
+
+	if ShardKey(hash, 0).Equals(ShardKey(hash, 1)) {
+		t.Error("Expected the shards of an object to have different keys")
+	}
+}
+
+func TestErasureCodedStore(t *testing.T) {
+	memory := NewMemoryNetwork()
+	nodes, err := memory.NewCluster(10)
+	if err != nil {
+		t.Fatalf("NewCluster() returned an error: %v", err)
+	}
+	for _, node := range nodes {
+		if err := node.EnableErasureCoding(3, 6); err != nil {
+			t.Fatalf("EnableErasureCoding() returned an error: %v", err)
+		}
+	}
+
+	data := []byte("stored as six shards, any three of which are enough")
+	hash, shards, err := nodes[1].Store(context.Background(), data)
+	if err != nil || shards != 6 {
+		t.Fatalf("Expected all 6 shards to be stored, but got %d %v", shards, err)
+	}
+
+	// Test that every shard is stored on ShardReplicas nodes and the object itself on none
+	holders := func(key string) []*MemoryStorage {
+		found := []*MemoryStorage{}
+		for _, node := range nodes {
+			if _, exist := node.network.storage.FetchData(key); exist {
+				found = append(found, node.network.storage.(*MemoryStorage))
+			}
+		}
+		return found
+	}
+	if len(holders(hash)) != 0 {
+		t.Error("Expected the object not to be stored whole")
+	}
+	for index := 0; index < 6; index++ {
+		if count := len(holders(ShardKey(hash, index).String())); count != defaultShardReplicas {
+			t.Errorf("Expected shard %d to be stored on %d nodes, but it is on %d", index, defaultShardReplicas, count)
+		}
+	}
+
+	// Test that the object is rebuilt after losing any three shards, but not four
+	for index := 0; index < 4; index++ {
+		retrieved, err := nodes[9].LookupData(context.Background(), hash)
+		if err != nil || !bytes.Equal(retrieved, data) {
+			t.Fatalf("Expected the object to be rebuilt with %d shards lost, but got %q %v", index, retrieved, err)
+		}
+
+		key := ShardKey(hash, index).String()
+		for _, storage := range holders(key) {
+			storage.mu.Lock()
+			storage.remove(key)
+			storage.mu.Unlock()
+		}
+	}
+	if retrieved, err := nodes[9].LookupData(context.Background(), hash); err != nil || retrieved != nil {
+		t.Errorf("Expected the object not to be found with 4 shards lost, but got %q %v", retrieved, err)
+	}
+}
@@ -0,0 +1,68 @@
+package kademlia
+
+import (
+	"sync"
+	"time"
+)
+
+// NodeStats is a snapshot of the state and activity of a node
+type NodeStats struct {
+	ID                string       `json:"id"`
+	Address           string       `json:"address"`
+	Contacts          int          `json:"contacts"`
+	Requests          PendingStats `json:"requests"`
+	BucketRefreshes   int          `json:"bucketRefreshes"`
+	LastBucketRefresh time.Time    `json:"lastBucketRefresh"`
+	Storage           StorageUsage `json:"storage"`
+	InvalidValues     int          `json:"invalidValues"`    // FIND_VALUE responses with data that did not match the key
+	MisbehavingNodes  int          `json:"misbehavingNodes"` // Contacts that responded with invalid data
+	InvalidMessages   int          `json:"invalidMessages"`  // Messages dropped because they were not signed by their sender
+	InvalidPuzzles    int          `json:"invalidPuzzles"`   // Messages dropped because the ID of their sender did not solve the puzzles
+	IPRejections      int          `json:"ipRejections"`     // Contacts not added because too many contacts share their IP or subnet
+}
+
+// nodeStats holds the counters of a node that are not kept anywhere else
+type nodeStats struct {
+	mu                sync.Mutex
+	bucketRefreshes   int
+	lastBucketRefresh time.Time
+	invalidValues     int
+}
+
+// Counts a refreshed bucket.
+func (stats *nodeStats) bucketRefreshed() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.bucketRefreshes++
+	stats.lastBucketRefresh = time.Now()
+}
+
+// Counts a FIND_VALUE response with invalid data.
+func (stats *nodeStats) invalidValue() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.invalidValues++
+}
+
+// Returns a snapshot of the state and activity of the node.
+func (kademlia *Kademlia) Stats() NodeStats {
+	kademlia.stats.mu.Lock()
+	defer kademlia.stats.mu.Unlock()
+
+	return NodeStats{
+		ID:                kademlia.network.rt.me.ID.String(),
+		Address:           kademlia.network.rt.me.Address,
+		Contacts:          kademlia.network.rt.NumContacts(),
+		Requests:          kademlia.network.PendingStats(),
+		BucketRefreshes:   kademlia.stats.bucketRefreshes,
+		LastBucketRefresh: kademlia.stats.lastBucketRefresh,
+		Storage:           kademlia.network.storage.Usage(),
+		InvalidValues:     kademlia.stats.invalidValues,
+		MisbehavingNodes:  kademlia.network.rt.NumMisbehaving(),
+		InvalidMessages:   kademlia.network.InvalidMessages(),
+		InvalidPuzzles:    kademlia.network.InvalidPuzzles(),
+		IPRejections:      kademlia.network.rt.NumIPRejections(),
+	}
+}
@@ -1,28 +1,108 @@
 package kademlia
 
 import (
+	"bytes"
 	"d7024e/utils"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 )
 
-type Storage struct {
+// Names of the storage backends that can be chosen in the configuration
+const (
+	MEMORY_STORAGE string = "memory"
+	FILE_STORAGE   string = "file"
+)
+
+// Storage defines how a Network keeps the values it is responsible for
+type Storage interface {
+	// StoreData stores a content record with data under key for ttl. Storing the same data again
+	// extends its TTL, storing different data under an unexpired key returns an error.
+	StoreData(key string, data []byte, ttl time.Duration) error
+
+	// StoreRecord stores a record of kind like StoreData
+	StoreRecord(key string, kind RecordKind, data []byte, ttl time.Duration) error
+
+	// CacheData stores a cached copy of data under key for ttl. A cached copy never changes a
+	// replica stored with StoreData, its TTL is not reset when it is fetched and it is not republished.
+	CacheData(key string, data []byte, ttl time.Duration) error
+
+	// FetchData returns the data stored under key and resets its TTL to the default TTL
+	FetchData(key string) ([]byte, bool)
+
+	// Kind returns the kind of the record stored under key
+	Kind(key string) RecordKind
+
+	// RefreshDataTTL resets the TTL of an unexpired value. Returns true if the TTL was refreshed.
+	RefreshDataTTL(key string, ttl time.Duration) bool
+
+	// ValuesStoredBefore returns the unexpired replicas that have not been stored or republished for at least age
+	ValuesStoredBefore(age time.Duration) []StoredValue
+
+	// MarkRepublished records that key was just republished
+	MarkRepublished(key string)
+
+	// Usage returns how much of the storage limits is used
+	Usage() StorageUsage
+
+	// Close releases the resources held by the storage
+	Close() error
+}
+
+// Opens the storage backend with the given name. path is only used by the file backend.
+func OpenStorage(backend string, path string, defaultTTL time.Duration, limits StorageLimits) (Storage, error) {
+	switch backend {
+	case MEMORY_STORAGE:
+		return NewMemoryStorage(defaultTTL, limits), nil
+	case FILE_STORAGE:
+		return OpenFileStorage(path, defaultTTL, limits)
+	default:
+		return nil, fmt.Errorf("OpenStorage: unknown storage backend %q", backend)
+	}
+}
+
+// MemoryStorage keeps every value in a map, so nothing survives a restart
+type MemoryStorage struct {
 	mu        sync.Mutex
 	dataStore map[string]struct {
 		Data []byte
 		TTL  time.Time
 	}
+	lastStored map[string]time.Time  // Last time each key was stored or republished
+	cached     map[string]bool       // Keys that only hold a cached copy
+	kinds      map[string]RecordKind // Kinds of the records that are not content records
 	DefaultTTL time.Duration
+
+	limits   StorageLimits
+	bytes    int64 // Size of all data in dataStore, expired or not
+	evicted  int
+	rejected int
+	done     chan struct{} // Closed by Close to stop the cleanup task
+}
+
+// A stored value together with the time it expires
+type StoredValue struct {
+	Key     string
+	Data    []byte
+	Expires time.Time
+	Cached  bool
+	Kind    RecordKind
 }
 
-// Initializes the Storage struct with a default TTL value
-func NewStorage(defaultTTL time.Duration) *Storage {
-	storage := &Storage{
+// Initializes the MemoryStorage struct with a default TTL value and the limits of what it may hold
+func NewMemoryStorage(defaultTTL time.Duration, limits StorageLimits) *MemoryStorage {
+	storage := &MemoryStorage{
 		dataStore: make(map[string]struct {
 			Data []byte
 			TTL  time.Time
 		}),
+		lastStored: make(map[string]time.Time),
+		cached:     make(map[string]bool),
+		kinds:      make(map[string]RecordKind),
 		DefaultTTL: defaultTTL,
+		limits:     limits,
+		done:       make(chan struct{}),
 	}
 
 	// Start a goroutine to periodically clean up expired objects
@@ -30,33 +110,97 @@ func NewStorage(defaultTTL time.Duration) *Storage {
 	return storage
 }
 
-// Stores data locally but does not overwrite any already defined key data pairs
-func (storage *Storage) StoreData(key string, data []byte, ttl time.Duration) {
+// Stores data locally but does not overwrite any already defined key data pairs. Storing the same data
+// again extends its TTL if the new TTL ends later. Returns an error if the data could not be stored.
+func (storage *MemoryStorage) StoreData(key string, data []byte, ttl time.Duration) error {
+	_, err := storage.storeData(StoredValue{Key: key, Data: data}, ttl)
+	return err
+}
+
+// Stores a record of kind like StoreData.
+func (storage *MemoryStorage) StoreRecord(key string, kind RecordKind, data []byte, ttl time.Duration) error {
+	_, err := storage.storeData(StoredValue{Key: key, Data: data, Kind: kind}, ttl)
+	return err
+}
+
+// Stores a cached copy of data that expires after ttl. A replica already stored under key is left as it is.
+func (storage *MemoryStorage) CacheData(key string, data []byte, ttl time.Duration) error {
+	_, err := storage.storeData(StoredValue{Key: key, Data: data, Cached: true}, ttl)
+	return err
+}
+
+// Stores the key, data, kind and cached flag of value for ttl like StoreData, or like CacheData if it is
+// cached, and returns the keys that were evicted to make room for it. Storing a replica over a cached copy
+// turns it into a replica.
+func (storage *MemoryStorage) storeData(value StoredValue, ttl time.Duration) ([]string, error) {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
 
+	key, data, cached := value.Key, value.Data, value.Cached
+	expirationTime := time.Now().Add(ttl)
 	existingData, exist := storage.dataStore[key]
-	if exist && time.Now().Before(existingData.TTL) {
-		utils.Log(3, "Tried to store data %s with key %s but that key already stores data %s", string(data), key, existingData.Data)
-		return
+	replace := false
+	if exist && time.Now().Before(existingData.TTL) && storage.kinds[key] != value.Kind {
+		utils.Log(3, "Tried to store a record of kind %d with key %s but that key stores a record of kind %d", value.Kind, key, storage.kinds[key])
+		return nil, fmt.Errorf("%w: key %s stores a record of another kind", ErrRecordExists, key)
+	}
+	if exist && time.Now().Before(existingData.TTL) && !bytes.Equal(existingData.Data, data) {
+		if err := ReplaceRecord(value.Kind, existingData.Data, data); err != nil {
+			utils.Log(3, "Tried to store %d bytes with key %s but that key already stores %d bytes: %s", len(data), key, len(existingData.Data), err)
+			return nil, fmt.Errorf("key %s: %w", key, err)
+		}
+		replace = true
 	}
+	if exist && time.Now().Before(existingData.TTL) && !replace {
 
-	expirationTime := time.Now().Add(ttl)
-	storage.dataStore[key] = struct {
-		Data []byte
-		TTL  time.Time
-	}{Data: data, TTL: expirationTime}
+		if cached && !storage.cached[key] {
+			return nil, nil
+		}
+		if expirationTime.After(existingData.TTL) {
+			existingData.TTL = expirationTime
+			storage.dataStore[key] = existingData
+		}
+		if !cached {
+			delete(storage.cached, key)
+			storage.lastStored[key] = time.Now()
+		}
+		return nil, nil
+	}
 
-	utils.Log(1, "Successfully stored data %s with key %s (TTL: %s)", string(data), key, expirationTime.String())
+	// An expired value or an older version of a mutable record under the same key is replaced
+	previous, previousStored, _ := storage.entryLocked(key)
+	if exist {
+		storage.remove(key)
+	}
+
+	value.Expires = expirationTime
+	evicted, err := storage.makeRoom(value)
+	if err != nil {
+		if replace {
+			storage.put(previous, previousStored)
+		}
+		storage.rejected++
+		utils.Log(2, "Rejected %d bytes with key %s: %s", len(data), key, err)
+		return nil, err
+	}
+
+	storage.put(value, time.Now())
+
+	utils.Log(1, "Successfully stored %d bytes with key %s (TTL: %s)", len(data), key, expirationTime.String())
+	return evicted, nil
 }
 
 // Tries to retrieve data and returns it together with the success of the fetch
-func (storage *Storage) FetchData(key string) ([]byte, bool) {
+func (storage *MemoryStorage) FetchData(key string) ([]byte, bool) {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
 
 	existingData, exist := storage.dataStore[key]
 	if exist && time.Now().Before(existingData.TTL) {
+		if storage.cached[key] {
+			return existingData.Data, true
+		}
+
 		// Reset TTL since data object is requested
 		storage.dataStore[key] = struct {
 			Data []byte
@@ -66,12 +210,45 @@ func (storage *Storage) FetchData(key string) ([]byte, bool) {
 	}
 
 	// Delete the data object if TTL has expired
-	delete(storage.dataStore, key)
+	storage.remove(key)
 	return nil, false
 }
 
+// Returns the unexpired replicas that have not been stored or republished for at least age. Cached copies are left out.
+func (storage *MemoryStorage) ValuesStoredBefore(age time.Duration) []StoredValue {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	values := []StoredValue{}
+	for key, storedData := range storage.dataStore {
+		if storage.cached[key] || time.Now().After(storedData.TTL) || time.Since(storage.lastStored[key]) < age {
+			continue
+		}
+		values = append(values, StoredValue{key, storedData.Data, storedData.TTL, false, storage.kinds[key]})
+	}
+	return values
+}
+
+// Returns the kind of the record stored under key.
+func (storage *MemoryStorage) Kind(key string) RecordKind {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	return storage.kinds[key]
+}
+
+// Records that key was just republished, so it is skipped until it is due again.
+func (storage *MemoryStorage) MarkRepublished(key string) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	if _, exist := storage.dataStore[key]; exist {
+		storage.lastStored[key] = time.Now()
+	}
+}
+
 // Refreshes the TTL for a data object if it exists and has not expired. Returns true if the TTL was refreshed.
-func (storage *Storage) RefreshDataTTL(key string, ttl time.Duration) bool {
+func (storage *MemoryStorage) RefreshDataTTL(key string, ttl time.Duration) bool {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
 
@@ -86,17 +263,139 @@ func (storage *Storage) RefreshDataTTL(key string, ttl time.Duration) bool {
 	return false
 }
 
-// Periodically checks and deletes expired objects from the data store
-func (storage *Storage) startCleanupTask() {
+// Periodically checks and deletes expired objects from the data store until the storage is closed
+func (storage *MemoryStorage) startCleanupTask() {
 	ticker := time.NewTicker(storage.DefaultTTL)
-	for range ticker.C {
-		storage.mu.Lock()
-		for key, data := range storage.dataStore {
-			if time.Now().After(data.TTL) {
-				utils.Log(2, "Deleting expired data with key %s", key)
-				delete(storage.dataStore, key)
-			}
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			storage.removeExpired(now)
+		case <-storage.done:
+			return
+		}
+	}
+}
+
+// Deletes the objects that expired before now from the data store.
+func (storage *MemoryStorage) removeExpired(now time.Time) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	for key, data := range storage.dataStore {
+		if now.After(data.TTL) {
+			utils.Log(2, "Deleting expired data with key %s", key)
+			storage.remove(key)
+		}
+	}
+}
+
+// Returns how much of the storage limits is used.
+func (storage *MemoryStorage) Usage() StorageUsage {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	return StorageUsage{
+		Keys:         len(storage.dataStore),
+		Cached:       len(storage.cached),
+		Bytes:        storage.bytes,
+		MaxKeys:      storage.limits.MaxKeys,
+		MaxBytes:     storage.limits.MaxBytes,
+		MaxValueSize: storage.limits.MaxValueSize,
+		Evicted:      storage.evicted,
+		Rejected:     storage.rejected,
+	}
+}
+
+// Stops the cleanup task, nothing else has to be released for a MemoryStorage.
+func (storage *MemoryStorage) Close() error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	select {
+	case <-storage.done:
+		return errors.New("MemoryStorage.Close: already closed")
+	default:
+		close(storage.done)
+	}
+	return nil
+}
+
+// Returns the value stored under key together with the last time it was stored, whether it has expired or not.
+func (storage *MemoryStorage) entry(key string) (StoredValue, time.Time, bool) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	return storage.entryLocked(key)
+}
+
+// Returns the value stored under key like entry. Must be called with mu held.
+func (storage *MemoryStorage) entryLocked(key string) (StoredValue, time.Time, bool) {
+	storedData, exist := storage.dataStore[key]
+	if !exist {
+		return StoredValue{}, time.Time{}, false
+	}
+	return StoredValue{key, storedData.Data, storedData.TTL, storage.cached[key], storage.kinds[key]}, storage.lastStored[key], true
+}
+
+// Puts back a value exactly as it was, without the checks done by StoreData.
+func (storage *MemoryStorage) restore(value StoredValue, lastStored time.Time) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	storage.remove(value.Key)
+	storage.put(value, lastStored)
+}
+
+// Removes the value stored under key, for values that were evicted according to the log of a FileStorage.
+func (storage *MemoryStorage) discard(key string) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	storage.remove(key)
+}
+
+// Adds a value that is not stored yet. Must be called with mu held.
+func (storage *MemoryStorage) put(value StoredValue, lastStored time.Time) {
+	storage.dataStore[value.Key] = struct {
+		Data []byte
+		TTL  time.Time
+	}{Data: value.Data, TTL: value.Expires}
+	storage.lastStored[value.Key] = lastStored
+	if value.Cached {
+		storage.cached[value.Key] = true
+	}
+	if value.Kind != CONTENT_RECORD {
+		storage.kinds[value.Key] = value.Kind
+	}
+	storage.bytes += int64(len(value.Data))
+}
+
+// Removes the value stored under key if there is one. Must be called with mu held.
+func (storage *MemoryStorage) remove(key string) {
+	if storedData, exist := storage.dataStore[key]; exist {
+		storage.bytes -= int64(len(storedData.Data))
+		delete(storage.dataStore, key)
+		delete(storage.lastStored, key)
+		delete(storage.cached, key)
+		delete(storage.kinds, key)
+	}
+}
+
+// Returns every unexpired value together with the last time it was stored.
+func (storage *MemoryStorage) snapshot() ([]StoredValue, []time.Time) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	values := []StoredValue{}
+	lastStored := []time.Time{}
+	for key, storedData := range storage.dataStore {
+		if time.Now().After(storedData.TTL) {
+			continue
 		}
-		storage.mu.Unlock()
+		values = append(values, StoredValue{key, storedData.Data, storedData.TTL, storage.cached[key], storage.kinds[key]})
+		lastStored = append(lastStored, storage.lastStored[key])
 	}
+	return values, lastStored
 }
@@ -6,7 +6,7 @@ import (
 )
 
 func TestStorage_StoreData(t *testing.T) {
-	storage := NewStorage(1 * time.Second) // Default TTL set to 1 second
+	storage := NewMemoryStorage(1*time.Second, StorageLimits{}) // Default TTL set to 1 second
 
 	key := "test_key"
 	data := []byte("test_data")
@@ -22,7 +22,10 @@ func TestStorage_StoreData(t *testing.T) {
 	}
 
 	// Test storing data for the same key again with a shorter TTL
-	storage.StoreData(key, []byte("new_test_data"), 1*time.Second) // Custom TTL set to 1 second
+	err := storage.StoreData(key, []byte("new_test_data"), 1*time.Second) // Custom TTL set to 1 second
+	if err == nil {
+		t.Errorf("Expected an error when storing different data for key %s", key)
+	}
 	storedData, exists = storage.dataStore[key]
 	if !exists {
 		t.Errorf("Expected data to be stored for key %s, but it was not", key)
@@ -33,7 +36,7 @@ func TestStorage_StoreData(t *testing.T) {
 }
 
 func TestStorage_FetchData(t *testing.T) {
-	storage := NewStorage(1 * time.Second) // Default TTL set to 1 second
+	storage := NewMemoryStorage(1*time.Second, StorageLimits{}) // Default TTL set to 1 second
 
 	key := "test_key"
 	data := []byte("test_data")
@@ -62,7 +65,7 @@ func TestStorage_FetchData(t *testing.T) {
 }
 
 func TestStorage_RefreshDataTTL(t *testing.T) {
-	storage := &Storage{
+	storage := &MemoryStorage{
 		dataStore: make(map[string]struct {
 			Data []byte
 			TTL  time.Time
@@ -112,3 +115,82 @@ func TestStorage_RefreshDataTTL(t *testing.T) {
 		t.Errorf("Expected TTL not to be refreshed for a non-existing key, but it was refreshed")
 	}
 }
+
+func TestStorage_ValuesStoredBefore(t *testing.T) {
+	storage := NewMemoryStorage(time.Minute, StorageLimits{})
+	storage.StoreData("key", []byte("data"), time.Second)
+
+	// Test that a value that was just stored is not due
+	if values := storage.ValuesStoredBefore(time.Minute); len(values) != 0 {
+		t.Errorf("Expected no values stored more than a minute ago, but got %d", len(values))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	values := storage.ValuesStoredBefore(5 * time.Millisecond)
+	if len(values) != 1 || values[0].Key != "key" || string(values[0].Data) != "data" {
+		t.Fatalf("Expected the stored value to be due, but got %v", values)
+	}
+
+	// Test that storing the same data again extends the TTL and counts as a store
+	storage.StoreData("key", []byte("data"), time.Minute)
+	if values := storage.ValuesStoredBefore(5 * time.Millisecond); len(values) != 0 {
+		t.Error("Expected a value that was stored again not to be due")
+	}
+	if storage.dataStore["key"].TTL.Before(values[0].Expires.Add(30 * time.Second)) {
+		t.Error("Expected storing the same data with a longer TTL to extend the TTL")
+	}
+
+	// Test that a republished value is not due
+	time.Sleep(10 * time.Millisecond)
+	storage.MarkRepublished("key")
+	if values := storage.ValuesStoredBefore(5 * time.Millisecond); len(values) != 0 {
+		t.Error("Expected a republished value not to be due")
+	}
+}
+
+func TestStorage_CacheData(t *testing.T) {
+	storage := NewMemoryStorage(time.Hour, StorageLimits{})
+
+	// Test that a cached copy keeps its TTL when fetched and is not republished
+	storage.CacheData("cached", []byte("data"), time.Minute)
+	storage.FetchData("cached")
+	if value, _, _ := storage.entry("cached"); !value.Cached || value.Expires.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Expected the cached copy to keep its TTL, but got %+v", value)
+	}
+	if values := storage.ValuesStoredBefore(0); len(values) != 0 {
+		t.Errorf("Expected cached copies not to be republished, but got %v", values)
+	}
+
+	// Test that caching does not change a replica
+	storage.StoreData("replica", []byte("data"), time.Minute)
+	storage.CacheData("replica", []byte("data"), time.Hour)
+	if value, _, _ := storage.entry("replica"); value.Cached || value.Expires.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Expected the replica to be left as it was, but got %+v", value)
+	}
+
+	// Test that storing a replica over a cached copy turns it into a replica
+	storage.StoreData("cached", []byte("data"), time.Hour)
+	if value, _, _ := storage.entry("cached"); value.Cached || value.Expires.Before(time.Now().Add(time.Minute)) {
+		t.Errorf("Expected the cached copy to become a replica, but got %+v", value)
+	}
+	if usage := storage.Usage(); usage.Keys != 2 || usage.Cached != 0 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestStorage_Close(t *testing.T) {
+	storage := NewMemoryStorage(time.Minute, StorageLimits{})
+
+	// Test that closing stops the cleanup task and that the storage cannot be closed twice
+	if err := storage.Close(); err != nil {
+		t.Errorf("Close() returned an error: %v", err)
+	}
+	select {
+	case <-storage.done:
+	default:
+		t.Error("Expected Close() to stop the cleanup task")
+	}
+	if err := storage.Close(); err == nil {
+		t.Error("Close() should return an error when the storage is already closed")
+	}
+}
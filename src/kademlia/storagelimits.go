@@ -0,0 +1,160 @@
+package kademlia
+
+import (
+	"d7024e/utils"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Returned when a value is larger than a storage accepts
+var ErrValueTooLarge = errors.New("value too large")
+
+// Returned when a storage is full and no value can be evicted to make room
+var ErrStorageFull = errors.New("storage full")
+
+// Names of the eviction policies that can be chosen in the configuration
+const (
+	EVICT_SOONEST_EXPIRING string = "soonest-expiring"
+	EVICT_FARTHEST         string = "farthest"
+)
+
+// StorageLimits bounds what a storage holds. A limit of zero means there is no limit.
+type StorageLimits struct {
+	MaxBytes     int64          // Total size of the data of all values
+	MaxKeys      int            // Number of values
+	MaxValueSize int            // Size of the data of a single value
+	Eviction     EvictionPolicy // Chooses the values to evict when the storage is full, nil rejects new values instead
+}
+
+// EvictionPolicy reports whether a should be evicted before b
+type EvictionPolicy func(a StoredValue, b StoredValue) bool
+
+// StorageUsage shows how much of its limits a storage uses
+type StorageUsage struct {
+	Keys         int   `json:"keys"`
+	Cached       int   `json:"cached"` // Keys that only hold a cached copy
+	Bytes        int64 `json:"bytes"`
+	MaxKeys      int   `json:"maxKeys"`
+	MaxBytes     int64 `json:"maxBytes"`
+	MaxValueSize int   `json:"maxValueSize"`
+	Evicted      int   `json:"evicted"`  // Values evicted to make room for new ones
+	Rejected     int   `json:"rejected"` // Stores rejected because of the limits
+}
+
+// Evicts the values that expire soonest first.
+func EvictSoonestExpiring(a StoredValue, b StoredValue) bool {
+	return a.Expires.Before(b.Expires)
+}
+
+// Returns a policy that evicts the values whose keys are farthest from id first, since other nodes
+// are closer to them. Keys that are not IDs are evicted before any other key.
+func EvictFarthestFrom(id *KademliaID) EvictionPolicy {
+	distance := func(key string) *KademliaID {
+		decoded, err := hex.DecodeString(key)
+		if err != nil {
+			return nil
+		}
+		keyID, err := NewKademliaIDFromBytes(decoded)
+		if err != nil {
+			return nil
+		}
+		return keyID.CalcDistance(id)
+	}
+
+	return func(a StoredValue, b StoredValue) bool {
+		distanceA, distanceB := distance(a.Key), distance(b.Key)
+		if distanceA == nil || distanceB == nil {
+			return distanceA == nil && distanceB != nil
+		}
+		return distanceB.Less(distanceA)
+	}
+}
+
+// Returns the eviction policy with the given name. id is the ID of the node, used by the farthest policy.
+func NewEvictionPolicy(name string, id *KademliaID) (EvictionPolicy, error) {
+	switch name {
+	case EVICT_SOONEST_EXPIRING:
+		return EvictSoonestExpiring, nil
+	case EVICT_FARTHEST:
+		return EvictFarthestFrom(id), nil
+	default:
+		return nil, fmt.Errorf("NewEvictionPolicy: unknown eviction policy %q", name)
+	}
+}
+
+// Makes room for value within the limits of the storage by removing expired values and, if that is
+// not enough, evicting the values the eviction policy ranks below value. Returns the evicted keys, or
+// an error if value does not fit. Nothing is evicted if an error is returned. Must be called with mu held.
+func (storage *MemoryStorage) makeRoom(value StoredValue) ([]string, error) {
+	limits := storage.limits
+	size := int64(len(value.Data))
+	if limits.MaxValueSize > 0 && len(value.Data) > limits.MaxValueSize {
+		return nil, fmt.Errorf("%w: %d bytes is more than the limit of %d bytes per value", ErrValueTooLarge, len(value.Data), limits.MaxValueSize)
+	}
+	if limits.MaxBytes > 0 && size > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes is more than the limit of %d bytes in total", ErrValueTooLarge, len(value.Data), limits.MaxBytes)
+	}
+
+	keys, bytes := len(storage.dataStore), storage.bytes
+	fits := func() bool {
+		return (limits.MaxKeys <= 0 || keys < limits.MaxKeys) && (limits.MaxBytes <= 0 || bytes+size <= limits.MaxBytes)
+	}
+	if fits() {
+		return nil, nil
+	}
+
+	// Expired values are removed before any value is evicted
+	for key, storedData := range storage.dataStore {
+		if time.Now().After(storedData.TTL) {
+			storage.remove(key)
+		}
+	}
+	keys, bytes = len(storage.dataStore), storage.bytes
+	if fits() {
+		return nil, nil
+	}
+
+	if limits.Eviction == nil {
+		return nil, fmt.Errorf("%w: %d values and %d bytes stored", ErrStorageFull, keys, bytes)
+	}
+
+	candidates := make([]StoredValue, 0, len(storage.dataStore))
+	for key, storedData := range storage.dataStore {
+		candidates = append(candidates, StoredValue{key, storedData.Data, storedData.TTL, storage.cached[key], storage.kinds[key]})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return evictBefore(limits.Eviction, candidates[i], candidates[j])
+	})
+
+	// A value is only evicted for a value the policy would keep over it
+	evicted := []string{}
+	for _, candidate := range candidates {
+		if fits() {
+			break
+		}
+		if !evictBefore(limits.Eviction, candidate, value) {
+			return nil, fmt.Errorf("%w: the eviction policy keeps every stored value over this one", ErrStorageFull)
+		}
+		evicted = append(evicted, candidate.Key)
+		keys--
+		bytes -= int64(len(candidate.Data))
+	}
+
+	for _, key := range evicted {
+		utils.Log(2, "Evicting data with key %s to make room for key %s", key, value.Key)
+		storage.remove(key)
+	}
+	storage.evicted += len(evicted)
+	return evicted, nil
+}
+
+// Reports whether a should be evicted before b. Cached copies are evicted before replicas, otherwise policy decides.
+func evictBefore(policy EvictionPolicy, a StoredValue, b StoredValue) bool {
+	if a.Cached != b.Cached {
+		return a.Cached
+	}
+	return policy(a, b)
+}
@@ -0,0 +1,123 @@
+package kademlia
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorageLimitsReject(t *testing.T) {
+	storage := NewMemoryStorage(time.Minute, StorageLimits{MaxKeys: 2, MaxBytes: 10, MaxValueSize: 8})
+
+	// Test that values larger than the limits are rejected
+	if err := storage.StoreData("big", []byte("123456789"), time.Hour); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected ErrValueTooLarge, but got %v", err)
+	}
+
+	// Test that a full storage without an eviction policy rejects new values
+	storage.StoreData("key1", []byte("1234"), time.Hour)
+	storage.StoreData("key2", []byte("1234"), time.Hour)
+	if err := storage.StoreData("key3", []byte("1"), time.Hour); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("Expected ErrStorageFull when the number of keys is reached, but got %v", err)
+	}
+
+	// Test that storing a value again does not count against the limits
+	if err := storage.StoreData("key1", []byte("1234"), time.Hour); err != nil {
+		t.Errorf("Expected storing the same value again to succeed, but got %v", err)
+	}
+
+	usage := storage.Usage()
+	if usage.Keys != 2 || usage.Bytes != 8 || usage.Rejected != 2 || usage.Evicted != 0 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	// Test that expired values make room before anything is rejected
+	storage = NewMemoryStorage(time.Minute, StorageLimits{MaxBytes: 10})
+	storage.StoreData("expired", []byte("12345678"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if err := storage.StoreData("key", []byte("12345678"), time.Hour); err != nil {
+		t.Errorf("Expected an expired value to make room, but got %v", err)
+	}
+	if usage := storage.Usage(); usage.Keys != 1 || usage.Bytes != 8 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestEvictSoonestExpiring(t *testing.T) {
+	storage := NewMemoryStorage(time.Minute, StorageLimits{MaxKeys: 2, Eviction: EvictSoonestExpiring})
+	storage.StoreData("key1", []byte("data1"), time.Hour)
+	storage.StoreData("key2", []byte("data2"), 2*time.Hour)
+
+	// Test that the value that expires soonest is evicted
+	if err := storage.StoreData("key3", []byte("data3"), 3*time.Hour); err != nil {
+		t.Fatalf("StoreData() returned an error: %v", err)
+	}
+	if _, exist := storage.FetchData("key1"); exist {
+		t.Error("Expected key1 to be evicted")
+	}
+
+	// Test that a value that would expire before every stored value is rejected instead
+	if err := storage.StoreData("key4", []byte("data4"), time.Second); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("Expected ErrStorageFull, but got %v", err)
+	}
+
+	if usage := storage.Usage(); usage.Keys != 2 || usage.Evicted != 1 || usage.Rejected != 1 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestEvictFarthest(t *testing.T) {
+	me := NewKademliaID("0000000000000000000000000000000000000000")
+	near := "0000000000000000000000000000000000000001"
+	middle := "00000000000000000000000000000000000000ff"
+	far := "ff00000000000000000000000000000000000000"
+
+	storage := NewMemoryStorage(time.Minute, StorageLimits{MaxBytes: 8, Eviction: EvictFarthestFrom(me)})
+	storage.StoreData(far, []byte("1234"), time.Hour)
+	storage.StoreData(middle, []byte("1234"), time.Hour)
+
+	// Test that values are evicted until the new value fits, farthest first
+	if err := storage.StoreData(near, []byte("123456"), time.Hour); err != nil {
+		t.Fatalf("StoreData() returned an error: %v", err)
+	}
+	if _, exist := storage.FetchData(far); exist {
+		t.Error("Expected the farthest value to be evicted")
+	}
+	if _, exist := storage.FetchData(middle); exist {
+		t.Error("Expected the middle value to be evicted to make room")
+	}
+
+	// Test that a value farther away than every stored value is rejected
+	if err := storage.StoreData(far, []byte("1234"), time.Hour); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("Expected ErrStorageFull, but got %v", err)
+	}
+	if _, exist := storage.FetchData(near); !exist {
+		t.Error("Expected the nearest value to be kept")
+	}
+}
+
+func TestFileStorageEviction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kademlia.log")
+	limits := StorageLimits{MaxKeys: 1, Eviction: EvictSoonestExpiring}
+	storage, err := OpenFileStorage(path, time.Minute, limits)
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	storage.StoreData("key1", []byte("data1"), time.Hour)
+	storage.StoreData("key2", []byte("data2"), 2*time.Hour)
+	storage.Close()
+
+	// Test that an evicted value does not come back after a restart
+	storage, err = OpenFileStorage(path, time.Minute, limits)
+	if err != nil {
+		t.Fatalf("OpenFileStorage() returned an error: %v", err)
+	}
+	defer storage.Close()
+	if _, exist := storage.FetchData("key1"); exist {
+		t.Error("Expected the evicted value not to be loaded")
+	}
+	if usage := storage.Usage(); usage.Keys != 1 || usage.Bytes != 5 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
@@ -0,0 +1,141 @@
+package kademlia
+
+import (
+	"d7024e/utils"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Largest packet a transport has to deliver in one piece, larger messages are fragmented by the Network
+const maxPacketSize = 4096
+
+// Returned when sending on a transport that has not been started
+var ErrTransportNotStarted = errors.New("transport not started")
+
+// Transport defines how a Network sends and receives raw packets
+type Transport interface {
+	// Start binds to address and passes every received packet to handler in the background,
+	// together with the address it was sent from
+	Start(address string, handler func(source string, data []byte)) error
+
+	// Send delivers data to the transport listening on address
+	Send(address string, data []byte) error
+
+	// Stop unbinds the transport and waits for the receive loop to exit
+	Stop() error
+}
+
+// UDPTransport sends and receives packets over a single UDP socket, so
+// peers see requests and replies coming from the same address
+type UDPTransport struct {
+	mu   sync.RWMutex
+	conn *net.UDPConn
+	done chan struct{}
+}
+
+// Create a new UDPTransport instance.
+func NewUDPTransport() *UDPTransport {
+	return &UDPTransport{}
+}
+
+// Opens the UDP socket on address and starts passing incoming packets to handler.
+func (transport *UDPTransport) Start(address string, handler func(source string, data []byte)) error {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	if transport.conn != nil {
+		return fmt.Errorf("UDPTransport.Start: already listening on %s", transport.conn.LocalAddr())
+	}
+
+	// Resolve the UDP address to bind to
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return fmt.Errorf("UDPTransport.Start: failed to resolve address %w", err)
+	}
+
+	// Create a UDP connection to listen on the specified address
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("UDPTransport.Start: failed to listen on udp %w", err)
+	}
+
+	transport.conn = conn
+	transport.done = make(chan struct{})
+	go transport.receive(conn, transport.done, handler)
+
+	return nil
+}
+
+// Reads packets from conn until it is closed.
+func (transport *UDPTransport) receive(conn *net.UDPConn, done chan struct{}, handler func(source string, data []byte)) {
+	defer close(done)
+
+	for {
+		buffer := make([]byte, maxPacketSize)
+		n, source, err := conn.ReadFromUDP(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			utils.LogError("UDPTransport.receive reading from udp %s", err)
+			continue
+		}
+
+		handler(source.String(), buffer[:n])
+	}
+}
+
+// Sends data to address from the listening socket.
+func (transport *UDPTransport) Send(address string, data []byte) error {
+	transport.mu.RLock()
+	defer transport.mu.RUnlock()
+
+	if transport.conn == nil {
+		return fmt.Errorf("UDPTransport.Send: %w", ErrTransportNotStarted)
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return fmt.Errorf("UDPTransport.Send: failed to resolve %s %w", address, err)
+	}
+
+	_, err = transport.conn.WriteToUDP(data, udpAddr)
+	if err != nil {
+		return fmt.Errorf("UDPTransport.Send: failed to write to %s %w", address, err)
+	}
+
+	return nil
+}
+
+// Closes the UDP socket and waits for the receive loop to exit.
+func (transport *UDPTransport) Stop() error {
+	transport.mu.Lock()
+	conn, done := transport.conn, transport.done
+	transport.conn = nil
+	transport.mu.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("UDPTransport.Stop: %w", ErrTransportNotStarted)
+	}
+
+	err := conn.Close()
+	<-done
+	if err != nil {
+		return fmt.Errorf("UDPTransport.Stop: failed to close socket %w", err)
+	}
+
+	return nil
+}
+
+// Returns the local address of the socket, or an empty string if the transport is not started.
+func (transport *UDPTransport) Address() string {
+	transport.mu.RLock()
+	defer transport.mu.RUnlock()
+
+	if transport.conn == nil {
+		return ""
+	}
+	return transport.conn.LocalAddr().String()
+}
@@ -0,0 +1,86 @@
+package kademlia
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPTransport(t *testing.T) {
+	transport := NewUDPTransport()
+
+	// Test that sending before starting returns an error
+	err := transport.Send("127.0.0.1:1", []byte("hello world"))
+	if !errors.Is(err, ErrTransportNotStarted) {
+		t.Errorf("Expected Send() to return ErrTransportNotStarted, but got %v", err)
+	}
+
+	received := make(chan []byte, 1)
+	sources := make(chan string, 1)
+	err = transport.Start("127.0.0.1:0", func(source string, data []byte) {
+		sources <- source
+		received <- data
+	})
+	if err != nil {
+		t.Fatalf("Start() returned an error: %v", err)
+	}
+
+	// Test that a started transport cannot be started again
+	if err := transport.Start("127.0.0.1:0", func(source string, data []byte) {}); err == nil {
+		t.Error("Start() should return an error when the transport is already started")
+	}
+
+	// Create a plain UDP peer to talk to the transport
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Could not create UDP peer: %v", err)
+	}
+	defer peer.Close()
+
+	// Test that outgoing packets come from the listening socket
+	if err := transport.Send(peer.LocalAddr().String(), []byte("hello peer")); err != nil {
+		t.Errorf("Send() returned an error: %v", err)
+	}
+
+	buffer := make([]byte, 4096)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := peer.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("Peer did not receive packet: %v", err)
+	}
+	if string(buffer[:n]) != "hello peer" {
+		t.Errorf("Expected peer to receive %s, but got %s", "hello peer", string(buffer[:n]))
+	}
+	if from.String() != transport.Address() {
+		t.Errorf("Expected packet to be sent from %s, but it was sent from %s", transport.Address(), from.String())
+	}
+
+	// Test that incoming packets are passed to the handler with the address they came from
+	if _, err := peer.WriteToUDP([]byte("hello transport"), from); err != nil {
+		t.Fatalf("Peer could not send packet: %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if string(data) != "hello transport" {
+			t.Errorf("Expected to receive %s, but got %s", "hello transport", string(data))
+		}
+		if source := <-sources; source != peer.LocalAddr().String() {
+			t.Errorf("Expected packet to come from %s, but got %s", peer.LocalAddr().String(), source)
+		}
+	case <-time.After(time.Second):
+		t.Error("Packet was not passed to the handler")
+	}
+
+	// Test that stopping closes the socket
+	if err := transport.Stop(); err != nil {
+		t.Errorf("Stop() returned an error: %v", err)
+	}
+	if transport.Address() != "" {
+		t.Error("Address() should be empty after Stop()")
+	}
+	if err := transport.Send(peer.LocalAddr().String(), []byte("hello peer")); !errors.Is(err, ErrTransportNotStarted) {
+		t.Errorf("Expected Send() after Stop() to return ErrTransportNotStarted, but got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"d7024e/api"
 	"d7024e/cli"
 	"d7024e/kademlia"
@@ -10,12 +12,35 @@ import (
 	"time"
 )
 
-var bootstrap = kademlia.NewContact(kademlia.NewKademliaID("0000000000000000000000000000000000000000"), "172.20.0.10:80")
+var bootstrapAddress = "172.20.0.10:80"
 var k = 20
 var alpha = 3
 var ttl = time.Second * 86430             // 24 hours and 30 seconds
 var refreshInterval = time.Second * 86400 // 24 hours
+var bucketRefreshInterval = time.Hour     // Time a bucket can go without a lookup before it is refreshed
+var replicateInterval = time.Hour         // How often stored values are republished to the closest contacts
 var port = 80
+var minReplicas = 1 // Nodes that must confirm a store for it to succeed
+var joinTimeout = time.Minute
+var storageBackend = kademlia.MEMORY_STORAGE // kademlia.MEMORY_STORAGE or kademlia.FILE_STORAGE to keep values across restarts
+var storagePath = "/data/kademlia.log"       // Log file used by the file storage backend
+var maxStorageBytes int64 = 256 << 20        // Total size of the values a node stores, 0 for no limit
+var maxStorageKeys = 100000                  // Number of values a node stores, 0 for no limit
+var maxValueSize = 16 << 20                  // Size of a single value a node stores, 0 for no limit
+var evictionPolicy = kademlia.EVICT_FARTHEST // kademlia.EVICT_FARTHEST or kademlia.EVICT_SOONEST_EXPIRING
+var erasureShards = 0                        // Shards objects are erasure coded into, 0 stores objects whole on k nodes
+var erasureRequired = 0                      // Shards needed to rebuild an erasure coded object
+var shardReplicas = 2                        // Nodes each shard is stored on
+var recordKeyPath = "/data/record.key"       // File the key mutable records are signed with is kept in, "" generates a new key at every start
+var identityKeyPath = ""                     // File the key the node ID is derived from is kept in, "" generates a new ID at every start
+var encryptMessages = false                  // Encrypt all messages between nodes, every node of a network must use the same setting
+var disjointPaths = 1                        // Disjoint paths every lookup takes, more than 1 resists nodes that steer lookups
+var staticPuzzleDifficulty = 12              // Leading zero bits of the hash of every node ID, every node of a network must use the same setting
+var dynamicPuzzleDifficulty = 16             // Leading zero bits of the hash of every node ID XOR its puzzle solution
+var bucketIPLimit = 2                        // Contacts with the same IP in a bucket, 0 for no limit
+var bucketSubnetLimit = 0                    // Contacts in the same /24 or /48 in a bucket, 0 for no limit
+var tableIPLimit = 10                        // Contacts with the same IP in the routing table, 0 for no limit
+var tableSubnetLimit = 0                     // Contacts in the same /24 or /48 in the routing table, 0 for no limit
 
 func main() {
 
@@ -32,26 +57,100 @@ func main() {
 
 	utils.Log(1, "Hello I exist and my ip is %s", ip)
 
+	// The node ID is derived from the key messages are signed with, so it cannot be chosen freely, and
+	// it must solve the puzzles, so that placing many nodes near a key takes a lot of work
+	puzzle := kademlia.PuzzleDifficulty{Static: staticPuzzleDifficulty, Dynamic: dynamicPuzzleDifficulty}
+	var identity *kademlia.Identity
+	if identityKeyPath != "" {
+		var key ed25519.PrivateKey
+		key, err = utils.LoadOrCreateKeyFunc(identityKeyPath, puzzle.GenerateKey)
+		if err != nil {
+			utils.LogError("%s", err)
+			return
+		}
+		identity, err = kademlia.NewPuzzleIdentityFromKey(key, puzzle)
+	} else {
+		identity, err = kademlia.NewPuzzleIdentity(puzzle)
+	}
+	if err != nil {
+		utils.LogError("%s", err)
+		return
+	}
 	address := fmt.Sprintf("%s:%d", ip, port)
-	me := kademlia.NewContact(kademlia.NewRandomKademliaID(), address)
+	me := identity.Contact(address)
 	rt := kademlia.NewRoutingTable(me)
-	net := kademlia.NewNetwork(rt, k, alpha, ttl, refreshInterval)
+	rt.SetIPLimits(kademlia.IPLimits{BucketIP: bucketIPLimit, BucketSubnet: bucketSubnetLimit, TableIP: tableIPLimit, TableSubnet: tableSubnetLimit})
+	eviction, err := kademlia.NewEvictionPolicy(evictionPolicy, me.ID)
+	if err != nil {
+		utils.LogError("%s", err)
+		return
+	}
+	limits := kademlia.StorageLimits{MaxBytes: maxStorageBytes, MaxKeys: maxStorageKeys, MaxValueSize: maxValueSize, Eviction: eviction}
+	storage, err := kademlia.OpenStorage(storageBackend, storagePath, ttl, limits)
+	if err != nil {
+		utils.LogError("%s", err)
+		return
+	}
+	defer storage.Close()
+	net, err := kademlia.NewNetworkWithStorage(kademlia.NewUDPTransport(), storage, identity, rt, k, alpha, ttl, refreshInterval)
+	if err != nil {
+		utils.LogError("%s", err)
+		return
+	}
+	net.RequirePuzzles(puzzle)
+	if encryptMessages {
+		if err := net.EnableEncryption(); err != nil {
+			utils.LogError("%s", err)
+			return
+		}
+	}
 	kad := kademlia.NewKademlia(net)
-	kad.StartRefreshRoutine()
+	kad.MinReplicas = minReplicas
+	kad.BucketRefreshInterval = bucketRefreshInterval
+	kad.ReplicateInterval = replicateInterval
+	kad.ShardReplicas = shardReplicas
+	kad.DisjointPaths = disjointPaths
+	if recordKeyPath != "" {
+		kad.RecordKey, err = utils.LoadOrCreateKey(recordKeyPath)
+		if err != nil {
+			utils.LogError("%s", err)
+			return
+		}
+	}
+	if erasureShards > 0 {
+		if err := kad.EnableErasureCoding(erasureRequired, erasureShards); err != nil {
+			utils.LogError("%s", err)
+			return
+		}
+	}
+	kad.StartRefreshRoutine(context.Background())
 
 	// Start listening on network
 	utils.Log(1, "Listening on %s:%d", ip, port)
-	go net.Listen(ip, port)
+	err = net.Start(ip, port)
+	if err != nil {
+		utils.LogError("%s", err)
+		return
+	}
+	defer net.Stop()
 
 	// if this is bootsrap node
-	if me.Address == bootstrap.Address {
+	if me.Address == bootstrapAddress {
 		utils.Log(1, "Im the bootstrap node")
-		me.ID = bootstrap.ID
 	} else {
 		utils.Log(1, "Joining kademlia network...")
-		kad.JoinNetwork(&bootstrap)
+		ctx, cancel := context.WithTimeout(context.Background(), joinTimeout)
+		// The ID of the bootstrap node is not known until it responds
+		bootstrap := kademlia.NewContact(nil, bootstrapAddress)
+		err = kad.JoinNetwork(ctx, &bootstrap)
+		cancel()
+		if err != nil {
+			utils.LogError("%s", err)
+			return
+		}
 		utils.Log(1, "Kademlia network joined")
 	}
+	kad.StartBucketRefreshRoutine(context.Background())
 
 	// CLI
 	local := cli.NewCLI(kad, &exit)
@@ -20,35 +20,1083 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// How the key of a stored record relates to its data
+type RecordKind int32
+
+const (
+	// The key is the hash of the data
+	RecordKind_CONTENT RecordKind = 0
+	// The data is a Shard and the key is derived from the hash of the object and the index of the shard
+	RecordKind_SHARD RecordKind = 1
+	// The data is a MutableRecord and the key is the hash of its public key
+	RecordKind_MUTABLE RecordKind = 2
+)
+
+// Enum value maps for RecordKind.
+var (
+	RecordKind_name = map[int32]string{
+		0: "CONTENT",
+		1: "SHARD",
+		2: "MUTABLE",
+	}
+	RecordKind_value = map[string]int32{
+		"CONTENT": 0,
+		"SHARD":   1,
+		"MUTABLE": 2,
+	}
+)
+
+func (x RecordKind) Enum() *RecordKind {
+	p := new(RecordKind)
+	*p = x
+	return p
+}
+
+func (x RecordKind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RecordKind) Descriptor() protoreflect.EnumDescriptor {
+	return file_kademlia_proto_enumTypes[0].Descriptor()
+}
+
+func (RecordKind) Type() protoreflect.EnumType {
+	return &file_kademlia_proto_enumTypes[0]
+}
+
+func (x RecordKind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RecordKind.Descriptor instead.
+func (RecordKind) EnumDescriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{0}
+}
+
 type KademliaMessage struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Sender *Node  `protobuf:"bytes,1,opt,name=sender,proto3" json:"sender,omitempty"`
-	RpcId  string `protobuf:"bytes,5,opt,name=rpc_id,json=rpcId,proto3" json:"rpc_id,omitempty"`
-	Type   string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
-	Key    string `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
-	Data   string `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Sender *Node  `protobuf:"bytes,1,opt,name=sender,proto3" json:"sender,omitempty"`
+	RpcId  []byte `protobuf:"bytes,2,opt,name=rpc_id,json=rpcId,proto3" json:"rpc_id,omitempty"`
+	// ed25519 public key of the sender, the ID of the sender is the hash of it
+	PublicKey []byte `protobuf:"bytes,3,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	// ed25519 signature of the message with this field left out
+	Signature []byte `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	// Solution of the dynamic crypto puzzle of the sender ID
+	PuzzleSolution []byte `protobuf:"bytes,5,opt,name=puzzle_solution,json=puzzleSolution,proto3" json:"puzzle_solution,omitempty"`
+	// Types that are assignable to Body:
+	//	*KademliaMessage_Ping
+	//	*KademliaMessage_Pong
+	//	*KademliaMessage_FindNode
+	//	*KademliaMessage_FindNodeResponse
+	//	*KademliaMessage_FindValue
+	//	*KademliaMessage_FindValueResponse
+	//	*KademliaMessage_Store
+	//	*KademliaMessage_Fragment
+	//	*KademliaMessage_FragmentAck
+	//	*KademliaMessage_StoreResponse
+	Body isKademliaMessage_Body `protobuf_oneof:"body"`
+}
+
+func (x *KademliaMessage) Reset() {
+	*x = KademliaMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *KademliaMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KademliaMessage) ProtoMessage() {}
+
+func (x *KademliaMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KademliaMessage.ProtoReflect.Descriptor instead.
+func (*KademliaMessage) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *KademliaMessage) GetSender() *Node {
+	if x != nil {
+		return x.Sender
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetRpcId() []byte {
+	if x != nil {
+		return x.RpcId
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetPublicKey() []byte {
+	if x != nil {
+		return x.PublicKey
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetPuzzleSolution() []byte {
+	if x != nil {
+		return x.PuzzleSolution
+	}
+	return nil
+}
+
+func (m *KademliaMessage) GetBody() isKademliaMessage_Body {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetPing() *Ping {
+	if x, ok := x.GetBody().(*KademliaMessage_Ping); ok {
+		return x.Ping
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetPong() *Pong {
+	if x, ok := x.GetBody().(*KademliaMessage_Pong); ok {
+		return x.Pong
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetFindNode() *FindNode {
+	if x, ok := x.GetBody().(*KademliaMessage_FindNode); ok {
+		return x.FindNode
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetFindNodeResponse() *FindNodeResponse {
+	if x, ok := x.GetBody().(*KademliaMessage_FindNodeResponse); ok {
+		return x.FindNodeResponse
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetFindValue() *FindValue {
+	if x, ok := x.GetBody().(*KademliaMessage_FindValue); ok {
+		return x.FindValue
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetFindValueResponse() *FindValueResponse {
+	if x, ok := x.GetBody().(*KademliaMessage_FindValueResponse); ok {
+		return x.FindValueResponse
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetStore() *Store {
+	if x, ok := x.GetBody().(*KademliaMessage_Store); ok {
+		return x.Store
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetFragment() *Fragment {
+	if x, ok := x.GetBody().(*KademliaMessage_Fragment); ok {
+		return x.Fragment
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetFragmentAck() *FragmentAck {
+	if x, ok := x.GetBody().(*KademliaMessage_FragmentAck); ok {
+		return x.FragmentAck
+	}
+	return nil
+}
+
+func (x *KademliaMessage) GetStoreResponse() *StoreResponse {
+	if x, ok := x.GetBody().(*KademliaMessage_StoreResponse); ok {
+		return x.StoreResponse
+	}
+	return nil
+}
+
+type isKademliaMessage_Body interface {
+	isKademliaMessage_Body()
+}
+
+type KademliaMessage_Ping struct {
+	Ping *Ping `protobuf:"bytes,10,opt,name=ping,proto3,oneof"`
+}
+
+type KademliaMessage_Pong struct {
+	Pong *Pong `protobuf:"bytes,11,opt,name=pong,proto3,oneof"`
+}
+
+type KademliaMessage_FindNode struct {
+	FindNode *FindNode `protobuf:"bytes,12,opt,name=find_node,json=findNode,proto3,oneof"`
+}
+
+type KademliaMessage_FindNodeResponse struct {
+	FindNodeResponse *FindNodeResponse `protobuf:"bytes,13,opt,name=find_node_response,json=findNodeResponse,proto3,oneof"`
+}
+
+type KademliaMessage_FindValue struct {
+	FindValue *FindValue `protobuf:"bytes,14,opt,name=find_value,json=findValue,proto3,oneof"`
+}
+
+type KademliaMessage_FindValueResponse struct {
+	FindValueResponse *FindValueResponse `protobuf:"bytes,15,opt,name=find_value_response,json=findValueResponse,proto3,oneof"`
+}
+
+type KademliaMessage_Store struct {
+	Store *Store `protobuf:"bytes,16,opt,name=store,proto3,oneof"`
+}
+
+type KademliaMessage_Fragment struct {
+	Fragment *Fragment `protobuf:"bytes,18,opt,name=fragment,proto3,oneof"`
+}
+
+type KademliaMessage_FragmentAck struct {
+	FragmentAck *FragmentAck `protobuf:"bytes,19,opt,name=fragment_ack,json=fragmentAck,proto3,oneof"`
+}
+
+type KademliaMessage_StoreResponse struct {
+	StoreResponse *StoreResponse `protobuf:"bytes,20,opt,name=store_response,json=storeResponse,proto3,oneof"`
+}
+
+func (*KademliaMessage_Ping) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_Pong) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_FindNode) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_FindNodeResponse) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_FindValue) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_FindValueResponse) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_Store) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_Fragment) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_FragmentAck) isKademliaMessage_Body() {}
+
+func (*KademliaMessage_StoreResponse) isKademliaMessage_Body() {}
+
+type Node struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id      []byte `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Address string `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+}
+
+func (x *Node) Reset() {
+	*x = Node{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Node) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Node) ProtoMessage() {}
+
+func (x *Node) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Node.ProtoReflect.Descriptor instead.
+func (*Node) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Node) GetId() []byte {
+	if x != nil {
+		return x.Id
+	}
+	return nil
+}
+
+func (x *Node) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type Ping struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *Ping) Reset() {
+	*x = Ping{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Ping) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ping) ProtoMessage() {}
+
+func (x *Ping) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ping.ProtoReflect.Descriptor instead.
+func (*Ping) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{2}
+}
+
+type Pong struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *Pong) Reset() {
+	*x = Pong{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Pong) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pong) ProtoMessage() {}
+
+func (x *Pong) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pong.ProtoReflect.Descriptor instead.
+func (*Pong) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{3}
+}
+
+type FindNode struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Target []byte `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+}
+
+func (x *FindNode) Reset() {
+	*x = FindNode{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindNode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindNode) ProtoMessage() {}
+
+func (x *FindNode) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindNode.ProtoReflect.Descriptor instead.
+func (*FindNode) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *FindNode) GetTarget() []byte {
+	if x != nil {
+		return x.Target
+	}
+	return nil
+}
+
+type FindNodeResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Nodes []*Node `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`
+}
+
+func (x *FindNodeResponse) Reset() {
+	*x = FindNodeResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindNodeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindNodeResponse) ProtoMessage() {}
+
+func (x *FindNodeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindNodeResponse.ProtoReflect.Descriptor instead.
+func (*FindNodeResponse) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FindNodeResponse) GetNodes() []*Node {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+type FindValue struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+}
+
+func (x *FindValue) Reset() {
+	*x = FindValue{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindValue) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindValue) ProtoMessage() {}
+
+func (x *FindValue) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindValue.ProtoReflect.Descriptor instead.
+func (*FindValue) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FindValue) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type FindValueResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key  []byte     `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Data []byte     `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Kind RecordKind `protobuf:"varint,3,opt,name=kind,proto3,enum=protobuf.RecordKind" json:"kind,omitempty"`
+}
+
+func (x *FindValueResponse) Reset() {
+	*x = FindValueResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindValueResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindValueResponse) ProtoMessage() {}
+
+func (x *FindValueResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindValueResponse.ProtoReflect.Descriptor instead.
+func (*FindValueResponse) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *FindValueResponse) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *FindValueResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *FindValueResponse) GetKind() RecordKind {
+	if x != nil {
+		return x.Kind
+	}
+	return RecordKind_CONTENT
+}
+
+// One of the shards an object is erasure coded into, stored as the data of a SHARD record
+type Shard struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Hash of the object
+	Object []byte `protobuf:"bytes,1,opt,name=object,proto3" json:"object,omitempty"`
+	Index  uint32 `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	// Number of shards the object was encoded into
+	Total uint32 `protobuf:"varint,3,opt,name=total,proto3" json:"total,omitempty"`
+	// Number of shards needed to rebuild the object
+	Required uint32 `protobuf:"varint,4,opt,name=required,proto3" json:"required,omitempty"`
+	// Size of the object in bytes
+	Size uint64 `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	// Hashes of the data of every shard, so that each shard can be checked on its own
+	ShardHashes [][]byte `protobuf:"bytes,6,rep,name=shard_hashes,json=shardHashes,proto3" json:"shard_hashes,omitempty"`
+	Data        []byte   `protobuf:"bytes,7,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *Shard) Reset() {
+	*x = Shard{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Shard) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Shard) ProtoMessage() {}
+
+func (x *Shard) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Shard.ProtoReflect.Descriptor instead.
+func (*Shard) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Shard) GetObject() []byte {
+	if x != nil {
+		return x.Object
+	}
+	return nil
+}
+
+func (x *Shard) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *Shard) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *Shard) GetRequired() uint32 {
+	if x != nil {
+		return x.Required
+	}
+	return 0
+}
+
+func (x *Shard) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Shard) GetShardHashes() [][]byte {
+	if x != nil {
+		return x.ShardHashes
+	}
+	return nil
+}
+
+func (x *Shard) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type Store struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key  []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// Milliseconds the data should be kept for, 0 means the default TTL of the receiver
+	TtlMs uint64 `protobuf:"varint,3,opt,name=ttl_ms,json=ttlMs,proto3" json:"ttl_ms,omitempty"`
+	// The data is a cached copy left by a lookup rather than a replica, so it is not republished
+	Cached bool       `protobuf:"varint,4,opt,name=cached,proto3" json:"cached,omitempty"`
+	Kind   RecordKind `protobuf:"varint,5,opt,name=kind,proto3,enum=protobuf.RecordKind" json:"kind,omitempty"`
+}
+
+func (x *Store) Reset() {
+	*x = Store{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Store) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Store) ProtoMessage() {}
+
+func (x *Store) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Store.ProtoReflect.Descriptor instead.
+func (*Store) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Store) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *Store) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Store) GetTtlMs() uint64 {
+	if x != nil {
+		return x.TtlMs
+	}
+	return 0
+}
+
+func (x *Store) GetCached() bool {
+	if x != nil {
+		return x.Cached
+	}
+	return false
+}
+
+func (x *Store) GetKind() RecordKind {
+	if x != nil {
+		return x.Kind
+	}
+	return RecordKind_CONTENT
+}
+
+type StoreResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Success bool   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Reason  string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+}
+
+func (x *StoreResponse) Reset() {
+	*x = StoreResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoreResponse) ProtoMessage() {}
+
+func (x *StoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoreResponse.ProtoReflect.Descriptor instead.
+func (*StoreResponse) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *StoreResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *StoreResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// Part of a serialized message that is too large for a single packet.
+// The rpc_id of the enclosing message identifies the transfer.
+type Fragment struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Index   uint32 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Count   uint32 `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	Payload []byte `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (x *Fragment) Reset() {
+	*x = Fragment{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Fragment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Fragment) ProtoMessage() {}
+
+func (x *Fragment) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Fragment.ProtoReflect.Descriptor instead.
+func (*Fragment) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Fragment) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *Fragment) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Fragment) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+type FragmentAck struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Index uint32 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+}
+
+func (x *FragmentAck) Reset() {
+	*x = FragmentAck{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FragmentAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FragmentAck) ProtoMessage() {}
+
+func (x *FragmentAck) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FragmentAck.ProtoReflect.Descriptor instead.
+func (*FragmentAck) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *FragmentAck) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+// A record that can be replaced by its owner, stored as the data of a MUTABLE record
+type MutableRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// ed25519 public key the record is signed with
+	PublicKey []byte `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	// A record only replaces records with a lower sequence number
+	Sequence uint64 `protobuf:"varint,2,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Value    []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	// ed25519 signature of the sequence number and value
+	Signature []byte `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (x *MutableRecord) Reset() {
+	*x = MutableRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MutableRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MutableRecord) ProtoMessage() {}
+
+func (x *MutableRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MutableRecord.ProtoReflect.Descriptor instead.
+func (*MutableRecord) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MutableRecord) GetPublicKey() []byte {
+	if x != nil {
+		return x.PublicKey
+	}
+	return nil
+}
+
+func (x *MutableRecord) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *MutableRecord) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *MutableRecord) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// What is sent between nodes when messages are encrypted. The field numbers are not used by
+// KademliaMessage, so a plaintext message is never mistaken for a packet.
+type Packet struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Body:
+	//	*Packet_Handshake
+	//	*Packet_Sealed
+	Body isPacket_Body `protobuf_oneof:"body"`
 }
 
-func (x *KademliaMessage) Reset() {
-	*x = KademliaMessage{}
+func (x *Packet) Reset() {
+	*x = Packet{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_kademlia_proto_msgTypes[0]
+		mi := &file_kademlia_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *KademliaMessage) String() string {
+func (x *Packet) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*KademliaMessage) ProtoMessage() {}
+func (*Packet) ProtoMessage() {}
 
-func (x *KademliaMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_kademlia_proto_msgTypes[0]
+func (x *Packet) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[14]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -59,72 +1107,173 @@ func (x *KademliaMessage) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use KademliaMessage.ProtoReflect.Descriptor instead.
-func (*KademliaMessage) Descriptor() ([]byte, []int) {
-	return file_kademlia_proto_rawDescGZIP(), []int{0}
+// Deprecated: Use Packet.ProtoReflect.Descriptor instead.
+func (*Packet) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{14}
 }
 
-func (x *KademliaMessage) GetSender() *Node {
+func (m *Packet) GetBody() isPacket_Body {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+func (x *Packet) GetHandshake() *Handshake {
+	if x, ok := x.GetBody().(*Packet_Handshake); ok {
+		return x.Handshake
+	}
+	return nil
+}
+
+func (x *Packet) GetSealed() *Sealed {
+	if x, ok := x.GetBody().(*Packet_Sealed); ok {
+		return x.Sealed
+	}
+	return nil
+}
+
+type isPacket_Body interface {
+	isPacket_Body()
+}
+
+type Packet_Handshake struct {
+	Handshake *Handshake `protobuf:"bytes,30,opt,name=handshake,proto3,oneof"`
+}
+
+type Packet_Sealed struct {
+	Sealed *Sealed `protobuf:"bytes,31,opt,name=sealed,proto3,oneof"`
+}
+
+func (*Packet_Handshake) isPacket_Body() {}
+
+func (*Packet_Sealed) isPacket_Body() {}
+
+// Announces the X25519 key of a node, signed with its ed25519 key
+type Handshake struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// ed25519 public key of the sender, the ID of the sender is the hash of it
+	PublicKey []byte `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	// X25519 public key the session key is agreed with
+	ExchangeKey []byte `protobuf:"bytes,2,opt,name=exchange_key,json=exchangeKey,proto3" json:"exchange_key,omitempty"`
+	// Address the sender listens on
+	Address string `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	// Unix time in milliseconds, old handshakes are not accepted
+	Timestamp int64 `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// Whether the receiver should answer with a handshake of its own
+	Reply bool `protobuf:"varint,5,opt,name=reply,proto3" json:"reply,omitempty"`
+	// ed25519 signature of the fields above
+	Signature []byte `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (x *Handshake) Reset() {
+	*x = Handshake{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kademlia_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Handshake) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Handshake) ProtoMessage() {}
+
+func (x *Handshake) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Handshake.ProtoReflect.Descriptor instead.
+func (*Handshake) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *Handshake) GetPublicKey() []byte {
 	if x != nil {
-		return x.Sender
+		return x.PublicKey
 	}
 	return nil
 }
 
-func (x *KademliaMessage) GetRpcId() string {
+func (x *Handshake) GetExchangeKey() []byte {
 	if x != nil {
-		return x.RpcId
+		return x.ExchangeKey
 	}
-	return ""
+	return nil
 }
 
-func (x *KademliaMessage) GetType() string {
+func (x *Handshake) GetAddress() string {
 	if x != nil {
-		return x.Type
+		return x.Address
 	}
 	return ""
 }
 
-func (x *KademliaMessage) GetKey() string {
+func (x *Handshake) GetTimestamp() int64 {
 	if x != nil {
-		return x.Key
+		return x.Timestamp
 	}
-	return ""
+	return 0
 }
 
-func (x *KademliaMessage) GetData() string {
+func (x *Handshake) GetReply() bool {
 	if x != nil {
-		return x.Data
+		return x.Reply
 	}
-	return ""
+	return false
 }
 
-type Node struct {
+func (x *Handshake) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// A serialized KademliaMessage encrypted with the session key of the sender and receiver
+type Sealed struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Id      string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Address string `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	// X25519 public key of the sender, which identifies the session
+	ExchangeKey []byte `protobuf:"bytes,1,opt,name=exchange_key,json=exchangeKey,proto3" json:"exchange_key,omitempty"`
+	// Address the sender listens on, where a new handshake is sent if the session is unknown
+	Address    string `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	Nonce      []byte `protobuf:"bytes,3,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Ciphertext []byte `protobuf:"bytes,4,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
 }
 
-func (x *Node) Reset() {
-	*x = Node{}
+func (x *Sealed) Reset() {
+	*x = Sealed{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_kademlia_proto_msgTypes[1]
+		mi := &file_kademlia_proto_msgTypes[16]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *Node) String() string {
+func (x *Sealed) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Node) ProtoMessage() {}
+func (*Sealed) ProtoMessage() {}
 
-func (x *Node) ProtoReflect() protoreflect.Message {
-	mi := &file_kademlia_proto_msgTypes[1]
+func (x *Sealed) ProtoReflect() protoreflect.Message {
+	mi := &file_kademlia_proto_msgTypes[16]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -135,43 +1284,180 @@ func (x *Node) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Node.ProtoReflect.Descriptor instead.
-func (*Node) Descriptor() ([]byte, []int) {
-	return file_kademlia_proto_rawDescGZIP(), []int{1}
+// Deprecated: Use Sealed.ProtoReflect.Descriptor instead.
+func (*Sealed) Descriptor() ([]byte, []int) {
+	return file_kademlia_proto_rawDescGZIP(), []int{16}
 }
 
-func (x *Node) GetId() string {
+func (x *Sealed) GetExchangeKey() []byte {
 	if x != nil {
-		return x.Id
+		return x.ExchangeKey
 	}
-	return ""
+	return nil
 }
 
-func (x *Node) GetAddress() string {
+func (x *Sealed) GetAddress() string {
 	if x != nil {
 		return x.Address
 	}
 	return ""
 }
 
+func (x *Sealed) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *Sealed) GetCiphertext() []byte {
+	if x != nil {
+		return x.Ciphertext
+	}
+	return nil
+}
+
 var File_kademlia_proto protoreflect.FileDescriptor
 
 var file_kademlia_proto_rawDesc = []byte{
 	0x0a, 0x0e, 0x6b, 0x61, 0x64, 0x65, 0x6d, 0x6c, 0x69, 0x61, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x12, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x22, 0x8a, 0x01, 0x0a, 0x0f, 0x4b,
+	0x12, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x22, 0xed, 0x05, 0x0a, 0x0f, 0x4b,
 	0x61, 0x64, 0x65, 0x6d, 0x6c, 0x69, 0x61, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x26,
 	0x0a, 0x06, 0x73, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e,
 	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x06,
 	0x73, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x12, 0x15, 0x0a, 0x06, 0x72, 0x70, 0x63, 0x5f, 0x69, 0x64,
-	0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x72, 0x70, 0x63, 0x49, 0x64, 0x12, 0x12, 0x0a,
-	0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x79, 0x70,
-	0x65, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03,
-	0x6b, 0x65, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x30, 0x0a, 0x04, 0x4e, 0x6f, 0x64, 0x65, 0x12,
-	0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12,
-	0x18, 0x0a, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x42, 0x0d, 0x5a, 0x0b, 0x2e, 0x2e, 0x2f,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x72, 0x70, 0x63, 0x49, 0x64, 0x12, 0x1d, 0x0a,
+	0x0a, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x09, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x4b, 0x65, 0x79, 0x12, 0x1c, 0x0a, 0x09,
+	0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x75,
+	0x7a, 0x7a, 0x6c, 0x65, 0x5f, 0x73, 0x6f, 0x6c, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x0e, 0x70, 0x75, 0x7a, 0x7a, 0x6c, 0x65, 0x53, 0x6f, 0x6c, 0x75, 0x74,
+	0x69, 0x6f, 0x6e, 0x12, 0x24, 0x0a, 0x04, 0x70, 0x69, 0x6e, 0x67, 0x18, 0x0a, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x50, 0x69, 0x6e,
+	0x67, 0x48, 0x00, 0x52, 0x04, 0x70, 0x69, 0x6e, 0x67, 0x12, 0x24, 0x0a, 0x04, 0x70, 0x6f, 0x6e,
+	0x67, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x50, 0x6f, 0x6e, 0x67, 0x48, 0x00, 0x52, 0x04, 0x70, 0x6f, 0x6e, 0x67, 0x12,
+	0x31, 0x0a, 0x09, 0x66, 0x69, 0x6e, 0x64, 0x5f, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x0c, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x46, 0x69,
+	0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x48, 0x00, 0x52, 0x08, 0x66, 0x69, 0x6e, 0x64, 0x4e, 0x6f,
+	0x64, 0x65, 0x12, 0x4a, 0x0a, 0x12, 0x66, 0x69, 0x6e, 0x64, 0x5f, 0x6e, 0x6f, 0x64, 0x65, 0x5f,
+	0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x4e, 0x6f,
+	0x64, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00, 0x52, 0x10, 0x66, 0x69,
+	0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x34,
+	0x0a, 0x0a, 0x66, 0x69, 0x6e, 0x64, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x46, 0x69,
+	0x6e, 0x64, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x48, 0x00, 0x52, 0x09, 0x66, 0x69, 0x6e, 0x64, 0x56,
+	0x61, 0x6c, 0x75, 0x65, 0x12, 0x4d, 0x0a, 0x13, 0x66, 0x69, 0x6e, 0x64, 0x5f, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x5f, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x1b, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x46, 0x69, 0x6e,
+	0x64, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00,
+	0x52, 0x11, 0x66, 0x69, 0x6e, 0x64, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x12, 0x27, 0x0a, 0x05, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x18, 0x10, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x48, 0x00, 0x52, 0x05, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x12, 0x30, 0x0a, 0x08,
+	0x66, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x18, 0x12, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x46, 0x72, 0x61, 0x67, 0x6d, 0x65,
+	0x6e, 0x74, 0x48, 0x00, 0x52, 0x08, 0x66, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x12, 0x3a,
+	0x0a, 0x0c, 0x66, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x5f, 0x61, 0x63, 0x6b, 0x18, 0x13,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x46, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x41, 0x63, 0x6b, 0x48, 0x00, 0x52, 0x0b, 0x66,
+	0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x41, 0x63, 0x6b, 0x12, 0x40, 0x0a, 0x0e, 0x73, 0x74,
+	0x6f, 0x72, 0x65, 0x5f, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x14, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00, 0x52, 0x0d, 0x73,
+	0x74, 0x6f, 0x72, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x06, 0x0a, 0x04,
+	0x62, 0x6f, 0x64, 0x79, 0x4a, 0x04, 0x08, 0x11, 0x10, 0x12, 0x22, 0x30, 0x0a, 0x04, 0x4e, 0x6f,
+	0x64, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02,
+	0x69, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x22, 0x06, 0x0a, 0x04,
+	0x50, 0x69, 0x6e, 0x67, 0x22, 0x06, 0x0a, 0x04, 0x50, 0x6f, 0x6e, 0x67, 0x22, 0x22, 0x0a, 0x08,
+	0x46, 0x69, 0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67,
+	0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74,
+	0x22, 0x38, 0x0a, 0x10, 0x46, 0x69, 0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x24, 0x0a, 0x05, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4e,
+	0x6f, 0x64, 0x65, 0x52, 0x05, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x22, 0x1d, 0x0a, 0x09, 0x46, 0x69,
+	0x6e, 0x64, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x63, 0x0a, 0x11, 0x46, 0x69, 0x6e,
+	0x64, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10,
+	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79,
+	0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04,
+	0x64, 0x61, 0x74, 0x61, 0x12, 0x28, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x14, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x52, 0x65,
+	0x63, 0x6f, 0x72, 0x64, 0x4b, 0x69, 0x6e, 0x64, 0x52, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x22, 0xb2,
+	0x01, 0x0a, 0x05, 0x53, 0x68, 0x61, 0x72, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x62, 0x6a, 0x65,
+	0x63, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x6f, 0x62, 0x6a, 0x65, 0x63, 0x74,
+	0x12, 0x14, 0x0a, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x1a, 0x0a, 0x08,
+	0x72, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08,
+	0x72, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x12, 0x21, 0x0a, 0x0c,
+	0x73, 0x68, 0x61, 0x72, 0x64, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x65, 0x73, 0x18, 0x06, 0x20, 0x03,
+	0x28, 0x0c, 0x52, 0x0b, 0x73, 0x68, 0x61, 0x72, 0x64, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x12,
+	0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64,
+	0x61, 0x74, 0x61, 0x22, 0x86, 0x01, 0x0a, 0x05, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x12, 0x10, 0x0a,
+	0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12,
+	0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64,
+	0x61, 0x74, 0x61, 0x12, 0x15, 0x0a, 0x06, 0x74, 0x74, 0x6c, 0x5f, 0x6d, 0x73, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x05, 0x74, 0x74, 0x6c, 0x4d, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x61,
+	0x63, 0x68, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x63, 0x61, 0x63, 0x68,
+	0x65, 0x64, 0x12, 0x28, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x14, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x52, 0x65, 0x63, 0x6f,
+	0x72, 0x64, 0x4b, 0x69, 0x6e, 0x64, 0x52, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x22, 0x41, 0x0a, 0x0d,
+	0x53, 0x74, 0x6f, 0x72, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a,
+	0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07,
+	0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f,
+	0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x22,
+	0x50, 0x0a, 0x08, 0x46, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x69,
+	0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x69, 0x6e, 0x64, 0x65,
+	0x78, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f,
+	0x61, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61,
+	0x64, 0x22, 0x23, 0x0a, 0x0b, 0x46, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x41, 0x63, 0x6b,
+	0x12, 0x14, 0x0a, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x22, 0x7e, 0x0a, 0x0d, 0x4d, 0x75, 0x74, 0x61, 0x62, 0x6c,
+	0x65, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x75, 0x62, 0x6c, 0x69,
+	0x63, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x70, 0x75, 0x62,
+	0x6c, 0x69, 0x63, 0x4b, 0x65, 0x79, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e,
+	0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e,
+	0x63, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x69, 0x67, 0x6e,
+	0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x73, 0x69, 0x67,
+	0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x22, 0x71, 0x0a, 0x06, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74,
+	0x12, 0x33, 0x0a, 0x09, 0x68, 0x61, 0x6e, 0x64, 0x73, 0x68, 0x61, 0x6b, 0x65, 0x18, 0x1e, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x48,
+	0x61, 0x6e, 0x64, 0x73, 0x68, 0x61, 0x6b, 0x65, 0x48, 0x00, 0x52, 0x09, 0x68, 0x61, 0x6e, 0x64,
+	0x73, 0x68, 0x61, 0x6b, 0x65, 0x12, 0x2a, 0x0a, 0x06, 0x73, 0x65, 0x61, 0x6c, 0x65, 0x64, 0x18,
+	0x1f, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x53, 0x65, 0x61, 0x6c, 0x65, 0x64, 0x48, 0x00, 0x52, 0x06, 0x73, 0x65, 0x61, 0x6c, 0x65,
+	0x64, 0x42, 0x06, 0x0a, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x22, 0xb9, 0x01, 0x0a, 0x09, 0x48, 0x61,
+	0x6e, 0x64, 0x73, 0x68, 0x61, 0x6b, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x75, 0x62, 0x6c, 0x69,
+	0x63, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x70, 0x75, 0x62,
+	0x6c, 0x69, 0x63, 0x4b, 0x65, 0x79, 0x12, 0x21, 0x0a, 0x0c, 0x65, 0x78, 0x63, 0x68, 0x61, 0x6e,
+	0x67, 0x65, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0b, 0x65, 0x78,
+	0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x64, 0x64,
+	0x72, 0x65, 0x73, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72,
+	0x65, 0x73, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x12, 0x14, 0x0a, 0x05, 0x72, 0x65, 0x70, 0x6c, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x05, 0x72, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61,
+	0x74, 0x75, 0x72, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x73, 0x69, 0x67, 0x6e,
+	0x61, 0x74, 0x75, 0x72, 0x65, 0x22, 0x7b, 0x0a, 0x06, 0x53, 0x65, 0x61, 0x6c, 0x65, 0x64, 0x12,
+	0x21, 0x0a, 0x0c, 0x65, 0x78, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x5f, 0x6b, 0x65, 0x79, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0b, 0x65, 0x78, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x4b,
+	0x65, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x14, 0x0a, 0x05,
+	0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e,
+	0x63, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x63, 0x69, 0x70, 0x68, 0x65, 0x72, 0x74, 0x65, 0x78, 0x74,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x63, 0x69, 0x70, 0x68, 0x65, 0x72, 0x74, 0x65,
+	0x78, 0x74, 0x2a, 0x31, 0x0a, 0x0a, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x4b, 0x69, 0x6e, 0x64,
+	0x12, 0x0b, 0x0a, 0x07, 0x43, 0x4f, 0x4e, 0x54, 0x45, 0x4e, 0x54, 0x10, 0x00, 0x12, 0x09, 0x0a,
+	0x05, 0x53, 0x48, 0x41, 0x52, 0x44, 0x10, 0x01, 0x12, 0x0b, 0x0a, 0x07, 0x4d, 0x55, 0x54, 0x41,
+	0x42, 0x4c, 0x45, 0x10, 0x02, 0x42, 0x0d, 0x5a, 0x0b, 0x2e, 0x2e, 0x2f, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -186,18 +1472,50 @@ func file_kademlia_proto_rawDescGZIP() []byte {
 	return file_kademlia_proto_rawDescData
 }
 
-var file_kademlia_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_kademlia_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_kademlia_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
 var file_kademlia_proto_goTypes = []interface{}{
-	(*KademliaMessage)(nil), // 0: protobuf.KademliaMessage
-	(*Node)(nil),            // 1: protobuf.Node
+	(RecordKind)(0),           // 0: protobuf.RecordKind
+	(*KademliaMessage)(nil),   // 1: protobuf.KademliaMessage
+	(*Node)(nil),              // 2: protobuf.Node
+	(*Ping)(nil),              // 3: protobuf.Ping
+	(*Pong)(nil),              // 4: protobuf.Pong
+	(*FindNode)(nil),          // 5: protobuf.FindNode
+	(*FindNodeResponse)(nil),  // 6: protobuf.FindNodeResponse
+	(*FindValue)(nil),         // 7: protobuf.FindValue
+	(*FindValueResponse)(nil), // 8: protobuf.FindValueResponse
+	(*Shard)(nil),             // 9: protobuf.Shard
+	(*Store)(nil),             // 10: protobuf.Store
+	(*StoreResponse)(nil),     // 11: protobuf.StoreResponse
+	(*Fragment)(nil),          // 12: protobuf.Fragment
+	(*FragmentAck)(nil),       // 13: protobuf.FragmentAck
+	(*MutableRecord)(nil),     // 14: protobuf.MutableRecord
+	(*Packet)(nil),            // 15: protobuf.Packet
+	(*Handshake)(nil),         // 16: protobuf.Handshake
+	(*Sealed)(nil),            // 17: protobuf.Sealed
 }
 var file_kademlia_proto_depIdxs = []int32{
-	1, // 0: protobuf.KademliaMessage.sender:type_name -> protobuf.Node
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	2,  // 0: protobuf.KademliaMessage.sender:type_name -> protobuf.Node
+	3,  // 1: protobuf.KademliaMessage.ping:type_name -> protobuf.Ping
+	4,  // 2: protobuf.KademliaMessage.pong:type_name -> protobuf.Pong
+	5,  // 3: protobuf.KademliaMessage.find_node:type_name -> protobuf.FindNode
+	6,  // 4: protobuf.KademliaMessage.find_node_response:type_name -> protobuf.FindNodeResponse
+	7,  // 5: protobuf.KademliaMessage.find_value:type_name -> protobuf.FindValue
+	8,  // 6: protobuf.KademliaMessage.find_value_response:type_name -> protobuf.FindValueResponse
+	10, // 7: protobuf.KademliaMessage.store:type_name -> protobuf.Store
+	12, // 8: protobuf.KademliaMessage.fragment:type_name -> protobuf.Fragment
+	13, // 9: protobuf.KademliaMessage.fragment_ack:type_name -> protobuf.FragmentAck
+	11, // 10: protobuf.KademliaMessage.store_response:type_name -> protobuf.StoreResponse
+	2,  // 11: protobuf.FindNodeResponse.nodes:type_name -> protobuf.Node
+	0,  // 12: protobuf.FindValueResponse.kind:type_name -> protobuf.RecordKind
+	0,  // 13: protobuf.Store.kind:type_name -> protobuf.RecordKind
+	16, // 14: protobuf.Packet.handshake:type_name -> protobuf.Handshake
+	17, // 15: protobuf.Packet.sealed:type_name -> protobuf.Sealed
+	16, // [16:16] is the sub-list for method output_type
+	16, // [16:16] is the sub-list for method input_type
+	16, // [16:16] is the sub-list for extension type_name
+	16, // [16:16] is the sub-list for extension extendee
+	0,  // [0:16] is the sub-list for field type_name
 }
 
 func init() { file_kademlia_proto_init() }
@@ -230,19 +1548,216 @@ func file_kademlia_proto_init() {
 				return nil
 			}
 		}
+		file_kademlia_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Ping); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Pong); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindNode); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindNodeResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindValue); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindValueResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Shard); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Store); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StoreResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Fragment); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FragmentAck); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MutableRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Packet); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Handshake); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kademlia_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Sealed); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_kademlia_proto_msgTypes[0].OneofWrappers = []interface{}{
+		(*KademliaMessage_Ping)(nil),
+		(*KademliaMessage_Pong)(nil),
+		(*KademliaMessage_FindNode)(nil),
+		(*KademliaMessage_FindNodeResponse)(nil),
+		(*KademliaMessage_FindValue)(nil),
+		(*KademliaMessage_FindValueResponse)(nil),
+		(*KademliaMessage_Store)(nil),
+		(*KademliaMessage_Fragment)(nil),
+		(*KademliaMessage_FragmentAck)(nil),
+		(*KademliaMessage_StoreResponse)(nil),
+	}
+	file_kademlia_proto_msgTypes[14].OneofWrappers = []interface{}{
+		(*Packet_Handshake)(nil),
+		(*Packet_Sealed)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_kademlia_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      1,
+			NumMessages:   17,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_kademlia_proto_goTypes,
 		DependencyIndexes: file_kademlia_proto_depIdxs,
+		EnumInfos:         file_kademlia_proto_enumTypes,
 		MessageInfos:      file_kademlia_proto_msgTypes,
 	}.Build()
 	File_kademlia_proto = out.File
@@ -6,45 +6,99 @@ import (
 	proto "google.golang.org/protobuf/proto"
 )
 
-// SerializeMessage takes a map of values and returns the serialized data
-func SerializeMessage(values map[string]string) ([]byte, error) {
-
-	// Define message
-	msg := &KademliaMessage{
-		Sender: &Node{
-			Id:      values["sender_id"],
-			Address: values["sender_address"],
-		},
-		RpcId: values["rpc_id"],
-		Type:  values["type"],
-		Key:   values["key"],
-		Data:  values["data"],
+// SerializeMessage takes a message and returns the serialized data
+func SerializeMessage(msg *KademliaMessage) ([]byte, error) {
+	if msg.GetSender() == nil || msg.GetBody() == nil {
+		return nil, fmt.Errorf("SerializeMessage: message must have a sender and a body")
 	}
 
 	// Serialize message
 	data, err := proto.Marshal(msg)
 	if err != nil {
-		return nil, fmt.Errorf("BuildMessage: failed to marshal data %w", err)
+		return nil, fmt.Errorf("SerializeMessage: failed to marshal data %w", err)
 	}
 
 	return data, nil
 }
 
-// DeserializeMessage takes a serialized message and returns a map of values
-func DeserializeMessage(data []byte) (map[string]string, error) {
+// SigningData returns the data the signature of a message is made over, which is the message serialized
+// without its signature. The serialization is deterministic, so the sender and receiver get the same data.
+func SigningData(msg *KademliaMessage) ([]byte, error) {
+	unsigned := proto.Clone(msg).(*KademliaMessage)
+	unsigned.Signature = nil
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("SigningData: failed to marshal data %w", err)
+	}
+	return data, nil
+}
+
+// DeserializeMessage takes serialized data and returns the message
+func DeserializeMessage(data []byte) (*KademliaMessage, error) {
 	msg := &KademliaMessage{}
 	err := proto.Unmarshal(data, msg)
 	if err != nil {
-		return nil, fmt.Errorf("DeconstructMessage: failed to unmarshal data %w", err)
+		return nil, fmt.Errorf("DeserializeMessage: failed to unmarshal data %w", err)
+	}
+
+	if msg.GetSender() == nil || msg.GetBody() == nil {
+		return nil, fmt.Errorf("DeserializeMessage: message is missing a sender or a body")
+	}
+
+	return msg, nil
+}
+
+// SerializeShard takes a shard and returns the serialized data
+func SerializeShard(shard *Shard) ([]byte, error) {
+	data, err := proto.Marshal(shard)
+	if err != nil {
+		return nil, fmt.Errorf("SerializeShard: failed to marshal data %w", err)
+	}
+	return data, nil
+}
+
+// DeserializeShard takes serialized data and returns the shard
+func DeserializeShard(data []byte) (*Shard, error) {
+	shard := &Shard{}
+	if err := proto.Unmarshal(data, shard); err != nil {
+		return nil, fmt.Errorf("DeserializeShard: failed to unmarshal data %w", err)
+	}
+	return shard, nil
+}
+
+// SerializeMutableRecord takes a mutable record and returns the serialized data
+func SerializeMutableRecord(record *MutableRecord) ([]byte, error) {
+	data, err := proto.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("SerializeMutableRecord: failed to marshal data %w", err)
+	}
+	return data, nil
+}
+
+// DeserializeMutableRecord takes serialized data and returns the mutable record
+func DeserializeMutableRecord(data []byte) (*MutableRecord, error) {
+	record := &MutableRecord{}
+	if err := proto.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("DeserializeMutableRecord: failed to unmarshal data %w", err)
 	}
+	return record, nil
+}
 
-	values := make(map[string]string)
-	values["sender_id"] = msg.Sender.Id
-	values["sender_address"] = msg.Sender.Address
-	values["rpc_id"] = msg.RpcId
-	values["type"] = msg.Type
-	values["key"] = msg.Key
-	values["data"] = msg.Data
+// SerializePacket takes a packet and returns the serialized data
+func SerializePacket(packet *Packet) ([]byte, error) {
+	data, err := proto.Marshal(packet)
+	if err != nil {
+		return nil, fmt.Errorf("SerializePacket: failed to marshal data %w", err)
+	}
+	return data, nil
+}
 
-	return values, nil
+// DeserializePacket takes serialized data and returns the packet
+func DeserializePacket(data []byte) (*Packet, error) {
+	packet := &Packet{}
+	if err := proto.Unmarshal(data, packet); err != nil {
+		return nil, fmt.Errorf("DeserializePacket: failed to unmarshal data %w", err)
+	}
+	return packet, nil
 }
@@ -0,0 +1,52 @@
+package protobuf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSerializeMessage(t *testing.T) {
+	msg := &KademliaMessage{
+		Sender: &Node{Id: bytes.Repeat([]byte{1}, 20), Address: "172.20.0.10:80"},
+		RpcId:  bytes.Repeat([]byte{2}, 20),
+		Body:   &KademliaMessage_Store{Store: &Store{Key: bytes.Repeat([]byte{3}, 20), Data: []byte{0, 255, 10, 0}}},
+	}
+
+	data, err := SerializeMessage(msg)
+	if err != nil {
+		t.Fatalf("SerializeMessage() returned an error: %v", err)
+	}
+
+	result, err := DeserializeMessage(data)
+	if err != nil {
+		t.Fatalf("DeserializeMessage() returned an error: %v", err)
+	}
+
+	if result.Sender.Address != msg.Sender.Address || !bytes.Equal(result.RpcId, msg.RpcId) {
+		t.Errorf("DeserializeMessage() returned a message with a different sender or rpc id")
+	}
+
+	// Test that binary data survives the round trip
+	if !bytes.Equal(result.GetStore().GetData(), msg.GetStore().GetData()) {
+		t.Errorf("Expected data %v, but got %v", msg.GetStore().GetData(), result.GetStore().GetData())
+	}
+}
+
+func TestSerializeMessageInvalid(t *testing.T) {
+	// Test that messages without a body are rejected
+	_, err := SerializeMessage(&KademliaMessage{Sender: &Node{}})
+	if err == nil {
+		t.Error("SerializeMessage() did not return an error for a message without a body")
+	}
+
+	// Test that garbage and messages without a sender are rejected
+	_, err = DeserializeMessage([]byte("not a message"))
+	if err == nil {
+		t.Error("DeserializeMessage() did not return an error for invalid data")
+	}
+
+	_, err = DeserializeMessage([]byte{})
+	if err == nil {
+		t.Error("DeserializeMessage() did not return an error for a message without a sender")
+	}
+}
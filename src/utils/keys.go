@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Loads the ed25519 private key whose seed is stored hex encoded at path. If there is no file at path,
+// a new key is generated and its seed is written there, so that the key stays the same across restarts.
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	return LoadOrCreateKeyFunc(path, func() (ed25519.PrivateKey, error) {
+		_, key, err := ed25519.GenerateKey(nil)
+		return key, err
+	})
+}
+
+// Same as LoadOrCreateKey, but a missing key is created with generate.
+func LoadOrCreateKeyFunc(path string, generate func() (ed25519.PrivateKey, error)) (ed25519.PrivateKey, error) {
+	encoded, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("LoadOrCreateKey: %s does not hold a %d byte hex encoded seed", path, ed25519.SeedSize)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("LoadOrCreateKey: %w", err)
+	}
+
+	key, err := generate()
+	if err != nil {
+		return nil, fmt.Errorf("LoadOrCreateKey: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("LoadOrCreateKey: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("LoadOrCreateKey: %w", err)
+	}
+	return key, nil
+}
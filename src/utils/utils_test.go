@@ -3,6 +3,8 @@ package utils
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -43,3 +45,22 @@ func TestHash(t *testing.T) {
 		t.Errorf("Hash() did not change with a small input change")
 	}
 }
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "record.key")
+	key, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateKey() returned an error: %v", err)
+	}
+
+	// Test that the same key is loaded again
+	loaded, err := LoadOrCreateKey(path)
+	if err != nil || !key.Equal(loaded) {
+		t.Errorf("Expected the stored key to be loaded, but got %v", err)
+	}
+
+	os.WriteFile(path, []byte("not a seed"), 0600)
+	if _, err := LoadOrCreateKey(path); err == nil {
+		t.Error("Expected an error for a file that does not hold a seed")
+	}
+}